@@ -5,7 +5,9 @@ import (
 	"os"
 	"os/exec"
 	"runtime"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
@@ -68,12 +70,18 @@ __pgp-tomb_custom_func() {
 )
 
 var (
-	cfgFile  string
-	verbose  bool
-	root     string
-	key      string
-	identity string
-	rootCmd  = &cobra.Command{
+	cfgFile      string
+	verbose      bool
+	root         string
+	keys         []string
+	keyring      string
+	ageKey       string
+	passphraseFd int
+	identities   []string
+	strict       bool
+	trusted      []string
+	quorum       int
+	rootCmd      = &cobra.Command{
 		Use:                    "pgp-tomb",
 		Version:                config.GetVersion(),
 		SilenceErrors:          true,
@@ -127,7 +135,22 @@ func initConfig() {
 		}).Info("Configuration successfully loaded")
 	}
 
-	// Validate & initialize configuration.
+	// Validate & initialize configuration. Strict mode & keepers trusted to
+	// sign the configuration can only be set using the command line (or the
+	// environment), never from the (maybe tampered) config file.
+	viper.Set("strict", strict)
+	if len(trusted) == 0 {
+		if value := os.Getenv("PGP_TOMB_TRUSTED_KEEPERS"); value != "" {
+			trusted = strings.Split(value, ",")
+		}
+	}
+	viper.Set("trusted-keepers", trusted)
+	if quorum == 0 {
+		if value, err := strconv.Atoi(os.Getenv("PGP_TOMB_TRUSTED_QUORUM")); err == nil {
+			quorum = value
+		}
+	}
+	viper.Set("trusted-quorum", quorum)
 	config.Init(viper.ConfigFileUsed())
 }
 
@@ -190,18 +213,40 @@ func main() {
 		&root, "root", "",
 		"override 'root' option in config file")
 	viper.BindPFlag("root", rootCmd.PersistentFlags().Lookup("root"))
-	rootCmd.PersistentFlags().StringVar(
-		&identity, "identity", "",
-		"override 'identity' option in config file")
+	rootCmd.PersistentFlags().StringSliceVar(
+		&identities, "identity", []string{},
+		"override 'identity' option in config file (repeat for several identities)")
 	viper.BindPFlag("identity", rootCmd.PersistentFlags().Lookup("identity"))
-	rootCmd.PersistentFlags().StringVar(
-		&key, "key", "",
-		"override 'key' option in config file")
+	rootCmd.PersistentFlags().StringSliceVar(
+		&keys, "key", []string{},
+		"override 'key' option in config file (repeat for several private keys)")
 	viper.BindPFlag("key", rootCmd.PersistentFlags().Lookup("key"))
+	rootCmd.PersistentFlags().StringVar(
+		&keyring, "keyring", "",
+		"override 'keyring' option in config file")
+	viper.BindPFlag("keyring", rootCmd.PersistentFlags().Lookup("keyring"))
+	rootCmd.PersistentFlags().StringVar(
+		&ageKey, "age-key", "",
+		"override 'age-key' option in config file")
+	viper.BindPFlag("age-key", rootCmd.PersistentFlags().Lookup("age-key"))
+	rootCmd.PersistentFlags().IntVar(
+		&passphraseFd, "passphrase-fd", -1,
+		"read passphrase of private keys from this file descriptor")
+	viper.BindPFlag("passphrase-fd", rootCmd.PersistentFlags().Lookup("passphrase-fd"))
+	rootCmd.PersistentFlags().BoolVar(
+		&strict, "strict", false,
+		"refuse to run if configuration signature cannot be verified")
+	rootCmd.PersistentFlags().StringSliceVar(
+		&trusted, "trusted-keepers", []string{},
+		"fingerprints of keepers trusted to sign the configuration (defaults to $PGP_TOMB_TRUSTED_KEEPERS)")
+	rootCmd.PersistentFlags().IntVar(
+		&quorum, "trusted-quorum", 0,
+		"minimum number of keeper signatures, no matter the 'signature-quorum' option (defaults to $PGP_TOMB_TRUSTED_QUORUM)")
 
 	// 'get' command.
 	var cmdGetFile string
 	var cmdGetCopy bool
+	var cmdGetTags bool
 	cmdGet := &cobra.Command{
 		Use:     "get <secret URI>",
 		Aliases: []string{"cat", "show"},
@@ -216,7 +261,7 @@ func main() {
 			return nil
 		},
 		Run: func(cmd *cobra.Command, args []string) {
-			core.Get(args[0], cmdGetFile, cmdGetCopy)
+			core.Get(args[0], cmdGetFile, cmdGetCopy, cmdGetTags)
 		},
 	}
 	cmdGet.PersistentFlags().StringVarP(
@@ -225,6 +270,9 @@ func main() {
 	cmdGet.PersistentFlags().BoolVar(
 		&cmdGetCopy, "copy", false,
 		"copy secret into system clipboard")
+	cmdGet.PersistentFlags().BoolVar(
+		&cmdGetTags, "tags", false,
+		"show tags (including private ones) instead of secret")
 
 	// 'set' command.
 	var cmdSetFile string
@@ -294,10 +342,14 @@ func main() {
 
 	// 'rebuild' command.
 	var cmdRebuildQuery string
-	var cmdRebuildRecipient string
+	var cmdRebuildRecipients []string
 	var cmdRebuildWorkers int
 	var cmdRebuildForce bool
+	var cmdRebuildCheckTags bool
+	var cmdRebuildCheckAlgorithms bool
 	var cmdRebuildDryRun bool
+	var cmdRebuildResume bool
+	var cmdRebuildJson bool
 	cmdRebuild := &cobra.Command{
 		Use:   "rebuild [<folder>|<secret URI<]",
 		Short: "Rebuild / check secrets",
@@ -316,31 +368,68 @@ func main() {
 				folderOrUri = args[0]
 			}
 			core.Rebuild(
-				folderOrUri, cmdRebuildQuery, cmdRebuildRecipient, cmdRebuildWorkers,
-				cmdRebuildForce, cmdRebuildDryRun)
+				folderOrUri, cmdRebuildQuery, cmdRebuildRecipients, cmdRebuildWorkers,
+				cmdRebuildForce, cmdRebuildCheckTags, cmdRebuildCheckAlgorithms, cmdRebuildDryRun,
+				cmdRebuildResume, cmdRebuildJson)
 		},
 	}
 	cmdRebuild.PersistentFlags().StringVarP(
 		&cmdRebuildQuery, "query", "q", "",
 		"limit rebuild to secrets matching this query")
-	cmdRebuild.PersistentFlags().StringVar(
-		&cmdRebuildRecipient, "recipient", "",
-		"limit rebuild to secrets readable by this key alias (defaults to --identity)")
+	cmdRebuild.PersistentFlags().StringSliceVar(
+		&cmdRebuildRecipients, "recipient", []string{},
+		"limit rebuild to secrets readable by any of these key aliases (defaults to --identity)")
 	cmdRebuild.PersistentFlags().IntVar(
 		&cmdRebuildWorkers, "workers", runtime.NumCPU(),
 		"set preferred number of workers")
 	cmdRebuild.PersistentFlags().BoolVar(
 		&cmdRebuildForce, "force", false,
 		"force rebuild even when not needed")
+	cmdRebuild.PersistentFlags().BoolVar(
+		&cmdRebuildCheckTags, "check-tags", false,
+		"decrypt secrets to check tags have not been tampered with")
+	cmdRebuild.PersistentFlags().BoolVar(
+		&cmdRebuildCheckAlgorithms, "check-algorithms", false,
+		"decrypt secrets to re-encrypt those using weaker algorithms than configured")
 	cmdRebuild.PersistentFlags().BoolVar(
 		&cmdRebuildDryRun, "dry-run", false,
 		"run without actually executing any side effect")
+	cmdRebuild.PersistentFlags().BoolVar(
+		&cmdRebuildResume, "resume", false,
+		"resume an interrupted rebuild (using the same options), skipping already handled secrets")
+	cmdRebuild.PersistentFlags().BoolVarP(
+		&cmdRebuildJson, "json", "j", false,
+		"enable JSON output")
+
+	// 'migrate' command.
+	var cmdMigrateDryRun bool
+	cmdMigrate := &cobra.Command{
+		Use:   "migrate [<folder>|<secret URI>]",
+		Short: "Convert secrets to the latest file format version",
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) > 1 {
+				return errors.New("migrating multiple folders / URIs is not supported")
+			}
+			return nil
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+			var folderOrUri string = ""
+			if len(args) > 0 {
+				folderOrUri = args[0]
+			}
+			core.Migrate(folderOrUri, cmdMigrateDryRun)
+		},
+	}
+	cmdMigrate.PersistentFlags().BoolVar(
+		&cmdMigrateDryRun, "dry-run", false,
+		"run without actually executing any side effect")
 
 	// 'list' command.
 	var cmdListLong bool
 	var cmdListQuery string
-	var cmdListRecipient string
+	var cmdListRecipients []string
 	var cmdListIgnoreSchema bool
+	var cmdListVerify bool
 	var cmdListJson bool
 	cmdList := &cobra.Command{
 		Use:     "list [<folder>|<secret URI>]",
@@ -358,8 +447,8 @@ func main() {
 				folderOrUri = args[0]
 			}
 			core.List(
-				folderOrUri, cmdListLong, cmdListQuery, cmdListRecipient,
-				cmdListIgnoreSchema, cmdListJson)
+				folderOrUri, cmdListLong, cmdListQuery, cmdListRecipients,
+				cmdListIgnoreSchema, cmdListVerify, cmdListJson)
 		},
 	}
 	cmdList.PersistentFlags().BoolVarP(
@@ -368,16 +457,349 @@ func main() {
 	cmdList.PersistentFlags().StringVarP(
 		&cmdListQuery, "query", "q", "",
 		"limit listing to secrets matching this query")
-	cmdList.PersistentFlags().StringVar(
-		&cmdListRecipient, "recipient", "",
-		"limit listing to secrets readable by this key alias (defaults to --identity)")
+	cmdList.PersistentFlags().StringSliceVar(
+		&cmdListRecipients, "recipient", []string{},
+		"limit listing to secrets readable by any of these key aliases (defaults to --identity)")
 	cmdList.PersistentFlags().BoolVar(
 		&cmdListIgnoreSchema, "ignore-schema", false,
 		"skip schema validations, both for tags and secrets")
+	cmdList.PersistentFlags().BoolVar(
+		&cmdListVerify, "verify", false,
+		"decrypt secrets to check signatures, tags digests & algorithms")
 	cmdList.PersistentFlags().BoolVarP(
 		&cmdListJson, "json", "j", false,
 		"enable JSON output")
 
+	// 'plan' command. The alternative config file uses its own flag, given
+	// that '--config' sets the current one.
+	var cmdPlanNewConfig string
+	var cmdPlanQuery string
+	var cmdPlanJson bool
+	cmdPlan := &cobra.Command{
+		Use:   "plan --new-config <config file> [<folder>|<secret URI>]",
+		Short: "Preview changes in expected recipients using an alternative config file",
+		Args: func(cmd *cobra.Command, args []string) error {
+			if cmdPlanNewConfig == "" {
+				return errors.New("requires the --new-config flag")
+			}
+			if len(args) > 1 {
+				return errors.New("planning multiple folders / URIs is not supported")
+			}
+			return nil
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+			var folderOrUri string = ""
+			if len(args) > 0 {
+				folderOrUri = args[0]
+			}
+			core.Plan(cmdPlanNewConfig, folderOrUri, cmdPlanQuery, cmdPlanJson)
+		},
+	}
+	cmdPlan.PersistentFlags().StringVar(
+		&cmdPlanNewConfig, "new-config", "",
+		"alternative config file replacing the current one (see --config)")
+	cmdPlan.PersistentFlags().StringVarP(
+		&cmdPlanQuery, "query", "q", "",
+		"limit plan to secrets matching this query")
+	cmdPlan.PersistentFlags().BoolVarP(
+		&cmdPlanJson, "json", "j", false,
+		"enable JSON output")
+
+	// 'report' command.
+	cmdReport := &cobra.Command{
+		Use:   "report",
+		Short: "Generate reports",
+	}
+
+	// 'report access' command.
+	var cmdReportAccessQuery string
+	var cmdReportAccessFormat string
+	cmdReportAccess := &cobra.Command{
+		Use:   "access [<folder>|<secret URI>]",
+		Short: "Generate matrix of secrets vs. keys (no decryption required)",
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) > 1 {
+				return errors.New("reporting multiple folders / URIs is not supported")
+			}
+			for _, format := range core.ReportFormats {
+				if format == cmdReportAccessFormat {
+					return nil
+				}
+			}
+			return errors.Errorf(
+				"supported formats are: %s", strings.Join(core.ReportFormats, ", "))
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+			var folderOrUri string = ""
+			if len(args) > 0 {
+				folderOrUri = args[0]
+			}
+			core.ReportAccess(folderOrUri, cmdReportAccessQuery, cmdReportAccessFormat)
+		},
+	}
+	cmdReportAccess.PersistentFlags().StringVarP(
+		&cmdReportAccessQuery, "query", "q", "",
+		"limit report to secrets matching this query")
+	cmdReportAccess.PersistentFlags().StringVar(
+		&cmdReportAccessFormat, "format", "csv",
+		"set output format (csv, json, markdown or html)")
+	cmdReport.AddCommand(cmdReportAccess)
+
+	// 'keys' command.
+	cmdKeys := &cobra.Command{
+		Use:   "keys",
+		Short: "Manage public keys",
+	}
+
+	// 'keys list' command.
+	var cmdKeysListJson bool
+	cmdKeysList := &cobra.Command{
+		Use:     "list",
+		Short:   "List public keys",
+		Aliases: []string{"ls"},
+		Args:    cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			core.KeysList(cmdKeysListJson)
+		},
+	}
+	cmdKeysList.PersistentFlags().BoolVarP(
+		&cmdKeysListJson, "json", "j", false,
+		"enable JSON output")
+
+	// 'keys show' command.
+	var cmdKeysShowJson bool
+	cmdKeysShow := &cobra.Command{
+		Use:   "show <key alias>",
+		Short: "Show public key details",
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 1 {
+				return errors.New("requires a key alias argument")
+			}
+			return nil
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+			core.KeysShow(args[0], cmdKeysShowJson)
+		},
+	}
+	cmdKeysShow.PersistentFlags().BoolVarP(
+		&cmdKeysShowJson, "json", "j", false,
+		"enable JSON output")
+
+	// 'keys add' command.
+	var cmdKeysAddFolder string
+	var cmdKeysAddForce bool
+	cmdKeysAdd := &cobra.Command{
+		Use:   "add <key alias> <file|->",
+		Short: "Add public key (PGP, ASCII armored or binary, or age; '-' reads from stdin)",
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 2 {
+				return errors.New("requires a key alias and a file arguments")
+			}
+			return nil
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+			core.KeysAdd(args[0], args[1], cmdKeysAddFolder, cmdKeysAddForce)
+		},
+	}
+	cmdKeysAdd.PersistentFlags().StringVar(
+		&cmdKeysAddFolder, "folder", "",
+		"store key in this subfolder of 'keys/'")
+	cmdKeysAdd.PersistentFlags().BoolVar(
+		&cmdKeysAddForce, "force", false,
+		"replace key if already exists")
+
+	// 'keys remove' command.
+	var cmdKeysRemoveForce bool
+	cmdKeysRemove := &cobra.Command{
+		Use:     "remove <key alias>",
+		Short:   "Remove public key",
+		Aliases: []string{"rm"},
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 1 {
+				return errors.New("requires a key alias argument")
+			}
+			return nil
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+			core.KeysRemove(args[0], cmdKeysRemoveForce)
+		},
+	}
+	cmdKeysRemove.PersistentFlags().BoolVar(
+		&cmdKeysRemoveForce, "force", false,
+		"remove key even when referenced in config file")
+
+	// 'keys rotate' command.
+	var cmdKeysRotateWorkers int
+	cmdKeysRotate := &cobra.Command{
+		Use:   "rotate <key alias> <file|->",
+		Short: "Replace public key & re-encrypt secrets encrypted with the previous one",
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 2 {
+				return errors.New("requires a key alias and a file arguments")
+			}
+			if cmdKeysRotateWorkers < 1 {
+				return errors.New("at least one worker is needed")
+			}
+			return nil
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+			core.KeysRotate(viper.ConfigFileUsed(), args[0], args[1], cmdKeysRotateWorkers)
+		},
+	}
+	cmdKeysRotate.PersistentFlags().IntVar(
+		&cmdKeysRotateWorkers, "workers", runtime.NumCPU(),
+		"set preferred number of workers")
+
+	// 'keys check' command.
+	var cmdKeysCheckJson bool
+	cmdKeysCheck := &cobra.Command{
+		Use:   "check [<key alias>]",
+		Short: "Check public keys for expiration, revocation & weak algorithms",
+		Args:  cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			alias := ""
+			if len(args) > 0 {
+				alias = args[0]
+			}
+			core.KeysCheck(alias, cmdKeysCheckJson)
+		},
+	}
+	cmdKeysCheck.PersistentFlags().BoolVarP(
+		&cmdKeysCheckJson, "json", "j", false,
+		"enable JSON output")
+
+	// 'keys pin' command.
+	cmdKeysPin := &cobra.Command{
+		Use:   "pin [<key alias>...]",
+		Short: "Pin current fingerprints of public keys in the configuration file",
+		Run: func(cmd *cobra.Command, args []string) {
+			core.KeysPin(viper.ConfigFileUsed(), args)
+		},
+	}
+
+	cmdKeys.AddCommand(
+		cmdKeysList, cmdKeysShow, cmdKeysAdd, cmdKeysRotate, cmdKeysRemove,
+		cmdKeysCheck, cmdKeysPin)
+
+	// 'config' command.
+	cmdConfig := &cobra.Command{
+		Use:   "config",
+		Short: "Manage configuration signatures",
+	}
+
+	// 'config sign' command.
+	cmdConfigSign := &cobra.Command{
+		Use:   "sign",
+		Short: "Sign configuration, public keys & templates (keepers only)",
+		Args:  cobra.NoArgs,
+		PersistentPreRun: func(cmd *cobra.Command, args []string) {
+			// Signing unsigned changes must be possible in strict mode.
+			strict = false
+			initConfig()
+			executeHook("pre", cmd.Name())
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+			core.ConfigSign(viper.ConfigFileUsed())
+		},
+	}
+
+	// 'config verify' command.
+	cmdConfigVerify := &cobra.Command{
+		Use:   "verify",
+		Short: "Verify signatures of configuration, public keys & templates",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			core.ConfigVerify()
+		},
+	}
+
+	cmdConfig.AddCommand(cmdConfigSign, cmdConfigVerify)
+
+	// 'agent' command.
+	var cmdAgentTTL time.Duration
+	cmdAgent := &cobra.Command{
+		Use:   "agent",
+		Short: "Hold the unlocked private key in memory to decrypt secrets",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			core.Agent(cmdAgentTTL)
+		},
+	}
+	cmdAgent.Flags().DurationVar(
+		&cmdAgentTTL, "ttl", 15*time.Minute,
+		"lock the private key after this time")
+
+	// 'agent status' command.
+	cmdAgentStatus := &cobra.Command{
+		Use:   "status",
+		Short: "Show status of the running agent",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			core.AgentStatus()
+		},
+	}
+
+	// 'agent lock' command.
+	cmdAgentLock := &cobra.Command{
+		Use:   "lock",
+		Short: "Forget the private key & stop the running agent",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			core.AgentLock()
+		},
+	}
+
+	cmdAgent.AddCommand(cmdAgentStatus, cmdAgentLock)
+
+	// 'breakglass' command.
+	cmdBreakglass := &cobra.Command{
+		Use:   "breakglass",
+		Short: "Manage shares of the break-glass private key",
+	}
+
+	// 'breakglass split' command.
+	var cmdBreakglassSplitThreshold int
+	var cmdBreakglassSplitOutput string
+	cmdBreakglassSplit := &cobra.Command{
+		Use:   "split <private key file>",
+		Short: "Split the break-glass private key into shares encrypted for keepers",
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 1 {
+				return errors.New("requires a private key file argument")
+			}
+			return nil
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+			core.BreakglassSplit(args[0], cmdBreakglassSplitThreshold, cmdBreakglassSplitOutput)
+		},
+	}
+	cmdBreakglassSplit.PersistentFlags().IntVar(
+		&cmdBreakglassSplitThreshold, "threshold", 2,
+		"number of shares required to recover the private key")
+	cmdBreakglassSplit.PersistentFlags().StringVar(
+		&cmdBreakglassSplitOutput, "output", ".",
+		"write shares to this folder")
+
+	// 'breakglass recover' command.
+	var cmdBreakglassRecoverShares []string
+	var cmdBreakglassRecoverOutput string
+	cmdBreakglassRecover := &cobra.Command{
+		Use:   "recover",
+		Short: "Recover the break-glass private key from shares decrypted by keepers",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			core.BreakglassRecover(cmdBreakglassRecoverShares, cmdBreakglassRecoverOutput)
+		},
+	}
+	cmdBreakglassRecover.PersistentFlags().StringSliceVar(
+		&cmdBreakglassRecoverShares, "shares", []string{},
+		"decrypted share files")
+	cmdBreakglassRecover.PersistentFlags().StringVar(
+		&cmdBreakglassRecoverOutput, "output", "",
+		"write private key to this file instead of stdout")
+
+	cmdBreakglass.AddCommand(cmdBreakglassSplit, cmdBreakglassRecover)
+
 	// 'init' command.
 	cmdInit := &cobra.Command{
 		Use:   "init <path>",
@@ -431,7 +853,8 @@ func main() {
 
 	// Register commands & execute.
 	rootCmd.AddCommand(
-		cmdGet, cmdSet, cmdEdit, cmdRebuild, cmdList, cmdInit, cmdBash, cmdZsh)
+		cmdGet, cmdSet, cmdEdit, cmdRebuild, cmdMigrate, cmdList, cmdPlan, cmdReport, cmdKeys,
+		cmdConfig, cmdAgent, cmdBreakglass, cmdInit, cmdBash, cmdZsh)
 	if err := rootCmd.Execute(); err != nil {
 		args := append([]string{"get"}, os.Args[1:]...)
 		rootCmd.SetArgs(args)
@@ -0,0 +1,3 @@
+# created: 2026-10-19T10:00:00Z
+# public key: age14df0e0hm9psa5fm2vvmqc6j3lh8adjl6m0thdslk9e3t3n7qzvaq9c8w6w
+AGE-SECRET-KEY-1FCTGVZZZRP4U8W9D52FKQS0CFNWP0064YVM5D5RF0RKH8GGL2M4QM78G6U
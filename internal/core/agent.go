@@ -0,0 +1,77 @@
+package core
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/carlosabalde/pgp-tomb/internal/core/config"
+	"github.com/carlosabalde/pgp-tomb/internal/helpers/agent"
+	"github.com/carlosabalde/pgp-tomb/internal/helpers/pgp"
+)
+
+func Agent(ttl time.Duration) {
+	// Check private key.
+	key := config.GetPrivateKey()
+	if key == nil {
+		fmt.Fprintln(os.Stderr, "A private key is required! Use --key or --keyring.")
+		os.Exit(1)
+	}
+
+	// Unlock private key.
+	if err := pgp.UnlockPrivateKey(config.GetPassphrasePrompt(), key); err != nil {
+		fmt.Fprintln(os.Stderr, "Unable to unlock private key!")
+		os.Exit(1)
+	}
+
+	// Create socket.
+	server, err := agent.Listen(config.GetAgentSocket(), key)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error":  err,
+			"socket": config.GetAgentSocket(),
+		}).Fatal("Failed to start agent!")
+	}
+
+	// Serve until TTL expires or agent is locked.
+	fmt.Printf(
+		"Agent listening on '%s' until %s...\n",
+		config.GetAgentSocket(), time.Now().Add(ttl).Format(time.RFC3339))
+	if err := server.Serve(ttl); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error":  err,
+			"socket": config.GetAgentSocket(),
+		}).Fatal("Failed to serve agent requests!")
+	}
+
+	// Done!
+	fmt.Println("Done! Agent locked.")
+}
+
+func AgentStatus() {
+	status, err := agent.NewClient(config.GetAgentSocket()).Status()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Agent is not running!")
+		os.Exit(1)
+	}
+
+	fmt.Printf("Socket: %s\n", config.GetAgentSocket())
+	fmt.Printf("Fingerprint: %s\n", status.Fingerprint)
+	fmt.Printf("Expires: %s\n", status.Expires.Format(time.RFC3339))
+	if config.GetAgent() == nil {
+		fmt.Println("Used: no (private key does not match --key, --keyring or --identity)")
+	} else {
+		fmt.Println("Used: yes")
+	}
+}
+
+func AgentLock() {
+	if err := agent.NewClient(config.GetAgentSocket()).Lock(); err != nil {
+		fmt.Fprintln(os.Stderr, "Agent is not running!")
+		os.Exit(1)
+	}
+
+	fmt.Println("Done! Agent locked.")
+}
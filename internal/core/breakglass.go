@@ -0,0 +1,240 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/carlosabalde/pgp-tomb/internal/core/config"
+	"github.com/carlosabalde/pgp-tomb/internal/helpers/age"
+	"github.com/carlosabalde/pgp-tomb/internal/helpers/backend"
+	"github.com/carlosabalde/pgp-tomb/internal/helpers/pgp"
+	"github.com/carlosabalde/pgp-tomb/internal/helpers/shamir"
+)
+
+const breakglassShareExtension = ".share"
+
+// Plain contents of a share. Shares are stored encrypted using the public key
+// of each keeper.
+type breakglassShare struct {
+	Key         string `json:"key"`
+	Backend     string `json:"backend"`
+	Fingerprint string `json:"fingerprint"`
+	Threshold   int    `json:"threshold"`
+	Share       []byte `json:"share"`
+}
+
+func BreakglassSplit(keyFile string, threshold int, outputFolder string) {
+	// Check break-glass key.
+	key := config.GetBreakglassKey()
+	if key == nil {
+		fmt.Fprintln(os.Stderr, "No break-glass key! Use the 'breakglass.key' option.")
+		os.Exit(1)
+	}
+
+	// Check threshold.
+	keepers := config.GetKeepers()
+	if threshold < 2 || threshold > len(keepers) {
+		fmt.Fprintf(
+			os.Stderr, "Threshold must be between 2 and the number of keepers (%d)!\n",
+			len(keepers))
+		os.Exit(1)
+	}
+
+	// Check private key matches the break-glass key.
+	privateKey, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err,
+			"file":  keyFile,
+		}).Fatal("Failed to read private key!")
+	}
+	aBackend, err := checkBreakglassPrivateKey(key, privateKey)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid break-glass private key: %s!\n", err)
+		os.Exit(1)
+	}
+
+	// Split private key.
+	shares, err := shamir.Split(privateKey, len(keepers), threshold)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err,
+		}).Fatal("Failed to split private key!")
+	}
+
+	// Encrypt & write one share per keeper.
+	if err := os.MkdirAll(outputFolder, 0700); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error":  err,
+			"folder": outputFolder,
+		}).Fatal("Failed to create output folder!")
+	}
+	for i, keeper := range keepers {
+		file := path.Join(outputFolder, keeper.Alias+breakglassShareExtension)
+		fmt.Printf("- Writing share for '%s' to '%s'...", keeper.Alias, file)
+		if err := writeBreakglassShare(file, keeper, breakglassShare{
+			Key:         key.Alias,
+			Backend:     aBackend,
+			Fingerprint: key.GetFingerprint(),
+			Threshold:   threshold,
+			Share:       shares[i],
+		}); err != nil {
+			fmt.Println(" ✗")
+			logrus.WithFields(logrus.Fields{
+				"error":  err,
+				"keeper": keeper.Alias,
+			}).Fatal("Failed to write share!")
+		}
+		fmt.Println(" ✓")
+	}
+
+	// Done!
+	fmt.Printf(
+		"Done! %d shares written (%d required to recover the private key).\n",
+		len(keepers), threshold)
+}
+
+func BreakglassRecover(files []string, outputFile string) {
+	// Load shares.
+	shares := make([]breakglassShare, 0, len(files))
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"error": err,
+				"file":  file,
+			}).Fatal("Failed to read share!")
+		}
+		var share breakglassShare
+		if err := json.Unmarshal(data, &share); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid share '%s'! Has it been decrypted by its keeper?\n", file)
+			os.Exit(1)
+		}
+		shares = append(shares, share)
+	}
+	if len(shares) == 0 {
+		fmt.Fprintln(os.Stderr, "No shares! Use --shares.")
+		os.Exit(1)
+	}
+
+	// Check shares belong to the same split.
+	for _, share := range shares[1:] {
+		if share.Fingerprint != shares[0].Fingerprint || share.Threshold != shares[0].Threshold {
+			fmt.Fprintln(os.Stderr, "Shares do not belong to the same break-glass key!")
+			os.Exit(1)
+		}
+	}
+	if key := config.GetBreakglassKey(); key != nil && key.GetFingerprint() != shares[0].Fingerprint {
+		logrus.WithFields(logrus.Fields{
+			"key":         key.Alias,
+			"fingerprint": shares[0].Fingerprint,
+		}).Warn("Shares do not belong to the current break-glass key!")
+	}
+	if len(shares) < shares[0].Threshold {
+		fmt.Fprintf(os.Stderr, "At least %d shares are required!\n", shares[0].Threshold)
+		os.Exit(1)
+	}
+
+	// Combine shares.
+	parts := make([][]byte, 0, len(shares))
+	for _, share := range shares {
+		parts = append(parts, share.Share)
+	}
+	privateKey, err := shamir.Combine(parts)
+	if err == nil {
+		err = checkRecoveredPrivateKey(shares[0], privateKey)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to recover break-glass private key: %s!\n", err)
+		os.Exit(1)
+	}
+
+	// Write private key.
+	if outputFile == "" {
+		os.Stdout.Write(privateKey)
+	} else {
+		if err := ioutil.WriteFile(outputFile, privateKey, 0600); err != nil {
+			logrus.WithFields(logrus.Fields{
+				"error": err,
+				"file":  outputFile,
+			}).Fatal("Failed to write private key!")
+		}
+		fmt.Printf(
+			"Done! Break-glass private key '%s' (%s) written to '%s'.\n",
+			shares[0].Key, shares[0].Fingerprint, outputFile)
+	}
+}
+
+// Returns the backend of the private key.
+func checkBreakglassPrivateKey(key *backend.PublicKey, privateKey []byte) (string, error) {
+	if key.PGP != nil {
+		loaded, err := pgp.LoadASCIIArmoredPrivateKey(bytes.NewReader(privateKey))
+		if err != nil {
+			return "", err
+		}
+		if fingerprint := fmt.Sprintf("%X", loaded.Entity.PrimaryKey.Fingerprint); fingerprint != key.PGP.GetFingerprint() {
+			return "", errors.Errorf("fingerprint %s does not match '%s'", fingerprint, key.Alias)
+		}
+		return backend.PGP, nil
+	}
+
+	// age public keys cannot be derived from private keys without unlocking
+	// them, so only the format is checked.
+	if _, err := age.LoadPrivateKey(bytes.NewReader(privateKey)); err != nil {
+		return "", err
+	}
+	return backend.Age, nil
+}
+
+func checkRecoveredPrivateKey(share breakglassShare, privateKey []byte) error {
+	if share.Backend == backend.PGP {
+		loaded, err := pgp.LoadASCIIArmoredPrivateKey(bytes.NewReader(privateKey))
+		if err != nil {
+			return errors.New("invalid shares")
+		}
+		if fmt.Sprintf("%X", loaded.Entity.PrimaryKey.Fingerprint) != share.Fingerprint {
+			return errors.New("fingerprint does not match")
+		}
+		return nil
+	}
+
+	if _, err := age.LoadPrivateKey(bytes.NewReader(privateKey)); err != nil {
+		return errors.New("invalid shares")
+	}
+	return nil
+}
+
+func writeBreakglassShare(file string, keeper *backend.PublicKey, share breakglassShare) error {
+	data, err := json.Marshal(share)
+	if err != nil {
+		return err
+	}
+
+	var aBackend backend.Backend
+	if keeper.PGP != nil {
+		aBackend = &backend.PGPBackend{
+			Settings: pgp.Settings{
+				Cipher:      config.GetEncryption().Cipher,
+				Hash:        config.GetEncryption().Hash,
+				AEAD:        config.GetEncryption().AEAD,
+				Compression: "none",
+			},
+		}
+	} else {
+		aBackend = &backend.AgeBackend{}
+	}
+
+	var encrypted bytes.Buffer
+	if err := aBackend.Encrypt(bytes.NewReader(data), &encrypted, []*backend.PublicKey{keeper}, ""); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(file, encrypted.Bytes(), 0600)
+}
@@ -1,10 +1,18 @@
 package config
 
 import (
+	"path"
+	"strings"
+	"time"
+
 	"github.com/spf13/viper"
 	"github.com/xeipuuv/gojsonschema"
 
 	"github.com/carlosabalde/pgp-tomb/internal/core/query"
+	"github.com/carlosabalde/pgp-tomb/internal/helpers/age"
+	"github.com/carlosabalde/pgp-tomb/internal/helpers/agent"
+	"github.com/carlosabalde/pgp-tomb/internal/helpers/backend"
+	"github.com/carlosabalde/pgp-tomb/internal/helpers/passphrase"
 	"github.com/carlosabalde/pgp-tomb/internal/helpers/pgp"
 )
 
@@ -13,22 +21,110 @@ var (
 	revision string
 )
 
+const AgePublicKeyExtension = ".age"
 const HookExtension = ".hook"
+const PreviousKeysExtension = ".json"
 const PublicKeyExtension = ".pub"
 const SecretExtension = ".secret"
+const SignatureExtension = ".sig"
 const TemplateSchemaExtension = ".schema"
 const TemplateSkeletonExtension = ".skeleton"
 
 const DefaultEditor = "vim"
 
+const SignaturePolicyIgnore = "ignore"
+const SignaturePolicyWarn = "warn"
+const SignaturePolicyRequire = "require"
+
+const PaddingNone = "none"
+const PaddingPowerOfTwo = "power-of-two"
+const PaddingBlock = "block"
+
+const DefaultPaddingBlockSize = 1024
+
+const DefaultBackend = backend.PGP
+
+const DefaultAEAD = "none"
+
+const CompressionNone = "none"
+const CompressionGzip = "gzip"
+const CompressionZstd = "zstd"
+const CompressionZip = "zip"
+const CompressionZlib = "zlib"
+
+// Levels are ignored when using the default level of each algorithm.
+const DefaultCompressionLevel = -1
+
+const DefaultMinRSABits = 2048
+const DefaultExpirationWarningDays = 30
+
 type Hook struct {
 	Alias string
 	Path  string
 }
 
 type Team struct {
-	Alias string
-	Keys  []*pgp.PublicKey
+	Alias   string
+	Keys    []*backend.PublicKey
+	Members []string
+	Query   string
+}
+
+type KeyPolicy struct {
+	Refuse            bool
+	MinRSABits        int
+	ExpirationWarning time.Duration
+}
+
+// Public key replaced using 'pgp-tomb keys rotate'. Identifiers are backend
+// specific (e.g. PGP key IDs) and allow recognizing secrets still encrypted
+// with the previous key.
+type PreviousKey struct {
+	Alias       string   `json:"alias"`
+	Backend     string   `json:"backend"`
+	Fingerprint string   `json:"fingerprint"`
+	KeyIds      []string `json:"ids"`
+	Rotated     string   `json:"rotated"`
+}
+
+type Padding struct {
+	Scheme    string
+	BlockSize int
+}
+
+// OpenPGP algorithms used when encrypting & signing secrets.
+// Empty cipher & hash are negotiated according with preferences of keys.
+type Encryption struct {
+	Cipher string
+	Hash   string
+	AEAD   string
+}
+
+// Compression of the '.secret' file (i.e. container) and compression of the
+// OpenPGP compressed data packet (i.e. packet).
+type Compression struct {
+	Container      string
+	ContainerLevel int
+	Packet         string
+	PacketLevel    int
+}
+
+// Used when no compression rule matches.
+var DefaultCompression = Compression{
+	Container:      CompressionGzip,
+	ContainerLevel: DefaultCompressionLevel,
+	Packet:         CompressionNone,
+	PacketLevel:    DefaultCompressionLevel,
+}
+
+type CompressionRule struct {
+	Query       query.Query
+	Compression Compression
+}
+
+type BackendRule struct {
+	Query   query.Query
+	Backend string
 }
 
 type PermissionRule struct {
@@ -37,8 +133,11 @@ type PermissionRule struct {
 }
 
 type PermissionExpression struct {
-	Deny bool
-	Keys []*pgp.PublicKey
+	Deny    bool
+	Subject string
+	// Alias of the team referenced by the subject, if any.
+	Team string
+	Keys []*backend.PublicKey
 }
 
 type Template struct {
@@ -68,42 +167,210 @@ func GetHooks() map[string]Hook {
 	return viper.Get("hooks").(map[string]Hook)
 }
 
-func GetIdentity() *pgp.PublicKey {
-	return viper.Get("identity").(*pgp.PublicKey)
+// First identity, if any.
+func GetIdentity() *backend.PublicKey {
+	return viper.Get("identity").(*backend.PublicKey)
 }
 
+func GetIdentities() []*backend.PublicKey {
+	return viper.Get("identities").([]*backend.PublicKey)
+}
+
+// First private key, if any. Used to sign.
 func GetPrivateKey() *pgp.PrivateKey {
 	return viper.Get("key").(*pgp.PrivateKey)
 }
 
-func GetPublicKeys() map[string]*pgp.PublicKey {
-	return viper.Get("keys").(map[string]*pgp.PublicKey)
+// Private keys used to decrypt.
+func GetPrivateKeys() []*pgp.PrivateKey {
+	return viper.Get("private-keys").([]*pgp.PrivateKey)
+}
+
+// Running agent holding the private key, if any.
+func GetAgent() *agent.Client {
+	return viper.Get("agent").(*agent.Client)
+}
+
+func GetAgentSocket() string {
+	return viper.GetString("agent-socket")
+}
+
+func GetAgePrivateKey() *age.PrivateKey {
+	return viper.Get("age-key").(*age.PrivateKey)
+}
+
+func GetPublicKeys() map[string]*backend.PublicKey {
+	return viper.Get("keys").(map[string]*backend.PublicKey)
+}
+
+func GetPublicKeysRoot() string {
+	return path.Join(GetRoot(), "keys")
+}
+
+// Returns an empty string if the alias has no PGP public key.
+func GetPublicKeyFile(alias string) string {
+	return viper.Get("keys-files").(map[string]string)[alias]
+}
+
+// Returns an empty string if the alias has no age public key.
+func GetAgePublicKeyFile(alias string) string {
+	return viper.Get("age-keys-files").(map[string]string)[alias]
+}
+
+func GetPreviousKeysRoot() string {
+	return path.Join(GetPublicKeysRoot(), ".previous")
+}
+
+func GetPreviousKeysFile(alias string) string {
+	return path.Join(GetPreviousKeysRoot(), alias+PreviousKeysExtension)
+}
+
+// Returns previous keys of the alias, oldest first.
+func GetPreviousKeys(alias string) []PreviousKey {
+	return viper.Get("previous-keys").(map[string][]PreviousKey)[alias]
+}
+
+func GetAllPreviousKeys() map[string][]PreviousKey {
+	return viper.Get("previous-keys").(map[string][]PreviousKey)
+}
+
+func GetKeyPolicy() KeyPolicy {
+	return viper.Get("key-policy").(KeyPolicy)
+}
+
+func GetPublicKeyIssues(alias string) []pgp.KeyIssue {
+	return viper.Get("keys-issues").(map[string][]pgp.KeyIssue)[alias]
+}
+
+// Returns an empty string if the fingerprint of the key has not been pinned.
+func GetPinnedFingerprint(alias string) string {
+	return viper.Get("fingerprints").(map[string]string)[alias]
+}
+
+func GetPinnedFingerprints() map[string]string {
+	return viper.Get("fingerprints").(map[string]string)
+}
+
+func GetSignSecrets() bool {
+	return viper.GetBool("secret-signatures.sign")
+}
+
+func GetSecretSignaturePolicy() string {
+	return viper.GetString("secret-signatures.policy")
+}
+
+// Padding settings of the template (if any), falling back to global settings.
+func GetPadding(template *Template) Padding {
+	if template != nil {
+		templates := viper.Get("padding-templates").(map[string]Padding)
+		if padding, found := templates[template.Alias]; found {
+			return padding
+		}
+	}
+	return viper.Get("padding").(Padding)
 }
 
 func GetSecretsRoot() string {
 	return viper.GetString("secrets")
 }
 
-func GetKeepers() []*pgp.PublicKey {
-	return viper.Get("keepers").([]*pgp.PublicKey)
+// Used to resume interrupted rebuilds.
+func GetRebuildCheckpointFile() string {
+	return path.Join(GetRoot(), ".rebuild.checkpoint")
+}
+
+func GetKeepers() []*backend.PublicKey {
+	return viper.Get("keepers").([]*backend.PublicKey)
+}
+
+// Additional recipient of every secret, if any.
+func GetBreakglassKey() *backend.PublicKey {
+	return viper.Get("breakglass-key").(*backend.PublicKey)
+}
+
+// Break-glass key, if any and if it has a public key for the backend 'name'.
+func GetBreakglassKeyFor(name string) *backend.PublicKey {
+	for _, aBackend := range viper.Get("breakglass-backends").([]string) {
+		if aBackend == name {
+			return GetBreakglassKey()
+		}
+	}
+	return nil
+}
+
+// PGP public keys of keepers, used to check signatures over the
+// configuration. Keepers without a PGP public key cannot sign it.
+func GetKeeperPGPKeys() []*pgp.PublicKey {
+	result := make([]*pgp.PublicKey, 0)
+	for _, keeper := range GetKeepers() {
+		if keeper.PGP != nil {
+			result = append(result, keeper.PGP)
+		}
+	}
+	return result
 }
 
 func GetTeams() map[string]Team {
 	return viper.Get("teams").(map[string]Team)
 }
 
+func GetSignatureFile() string {
+	return viper.GetString("signature-file")
+}
+
+func GetSignatureQuorum() int {
+	return viper.GetInt("signature-quorum")
+}
+
+// PGP public keys of keepers whose fingerprints are trusted out of band (see
+// '--trusted-keepers'). Only their signatures over the configuration count.
+func GetTrustedKeeperPGPKeys() []*pgp.PublicKey {
+	return viper.Get("trusted-keepers").([]*pgp.PublicKey)
+}
+
+// Returns aliases of keepers with a valid signature over the current
+// configuration.
+func GetSigners() []string {
+	return viper.Get("signers").([]string)
+}
+
 func GetTags() *gojsonschema.Schema {
 	return viper.Get("tags").(*gojsonschema.Schema)
 }
 
+func IsPrivateTag(name string) bool {
+	return viper.Get("private-tags").(map[string]bool)[strings.ToLower(name)]
+}
+
 func GetPermissionRules() []PermissionRule {
 	return viper.Get("permission-rules").([]PermissionRule)
 }
 
+func GetTemplatesRoot() string {
+	return path.Join(GetRoot(), "templates")
+}
+
 func GetTemplates() map[string]*Template {
 	return viper.Get("templates").(map[string]*Template)
 }
 
+func GetEncryption() Encryption {
+	return viper.Get("encryption").(Encryption)
+}
+
+// Backend used when no backend rule matches.
+func GetBackend() string {
+	return viper.GetString("backend")
+}
+
+func GetBackendRules() []BackendRule {
+	return viper.Get("backend-rules").([]BackendRule)
+}
+
+func GetCompressionRules() []CompressionRule {
+	return viper.Get("compression-rules").([]CompressionRule)
+}
+
 func GetTemplateRules() []TemplateRule {
 	return viper.Get("template-rules").([]TemplateRule)
 }
@@ -116,6 +383,11 @@ func GetGPGConnectAgent() string {
 	return viper.GetString("gpg-connect-agent")
 }
 
+// Used to ask for passphrases of native private keys.
+func GetPassphrasePrompt() passphrase.Prompt {
+	return viper.Get("passphrase-prompt").(passphrase.Prompt)
+}
+
 func GetEditor() string {
 	return viper.GetString("editor")
 }
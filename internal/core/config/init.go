@@ -1,22 +1,33 @@
 package config
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"os/exec"
 	"path"
 	"path/filepath"
+	"reflect"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/ghodss/yaml"
+	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
 	"github.com/xeipuuv/gojsonschema"
 
 	"github.com/carlosabalde/pgp-tomb/internal/core/query"
+	"github.com/carlosabalde/pgp-tomb/internal/helpers/age"
+	"github.com/carlosabalde/pgp-tomb/internal/helpers/agent"
+	"github.com/carlosabalde/pgp-tomb/internal/helpers/backend"
 	"github.com/carlosabalde/pgp-tomb/internal/helpers/maps"
+	"github.com/carlosabalde/pgp-tomb/internal/helpers/passphrase"
 	"github.com/carlosabalde/pgp-tomb/internal/helpers/pgp"
+	"github.com/carlosabalde/pgp-tomb/internal/helpers/slices"
 )
 
 const (
@@ -31,11 +42,192 @@ const (
 		      "type": ["string", "null"]
 		    },
 		    "identity": {
-		      "type": ["string", "null"]
+		      "type": ["string", "array", "null"],
+		      "items": {
+		        "type": "string"
+		      }
 		    },
 		    "key": {
+		      "type": ["string", "array", "null"],
+		      "items": {
+		        "type": "string"
+		      }
+		    },
+		    "keyring": {
 		      "type": ["string", "null"]
 		    },
+		    "age-key": {
+		      "type": ["string", "null"]
+		    },
+		    "agent-socket": {
+		      "type": ["string", "null"]
+		    },
+		    "passphrase": {
+		      "type": ["object", "null"],
+		      "properties": {
+		        "env": {
+		          "type": "string",
+		          "minLength": 1
+		        },
+		        "file": {
+		          "type": "string",
+		          "minLength": 1
+		        },
+		        "command": {
+		          "type": "string",
+		          "minLength": 1
+		        },
+		        "fd": {
+		          "type": "integer",
+		          "minimum": 0
+		        }
+		      },
+		      "maxProperties": 1,
+		      "additionalProperties": false
+		    },
+		    "key-policy": {
+		      "type": ["object", "null"],
+		      "properties": {
+		        "action": {
+		          "type": "string",
+		          "enum": ["warn", "refuse"]
+		        },
+		        "min-rsa-bits": {
+		          "type": "integer",
+		          "minimum": 0
+		        },
+		        "expiration-warning-days": {
+		          "type": "integer",
+		          "minimum": 0
+		        }
+		      },
+		      "additionalProperties": false
+		    },
+		    "fingerprints": {
+		      "type": ["object", "null"],
+		      "patternProperties": {
+		        ".*": {
+		          "type": "string",
+		          "pattern": "^[0-9A-Fa-f]{40}$"
+		        }
+		      }
+		    },
+		    "secret-signatures": {
+		      "type": ["object", "null"],
+		      "properties": {
+		        "sign": {
+		          "type": "boolean"
+		        },
+		        "policy": {
+		          "type": "string",
+		          "enum": ["ignore", "warn", "require"]
+		        }
+		      },
+		      "additionalProperties": false
+		    },
+		    "padding": {
+		      "type": ["object", "null"],
+		      "properties": {
+		        "scheme": {
+		          "type": "string",
+		          "enum": ["none", "power-of-two", "block"]
+		        },
+		        "block-size": {
+		          "type": "integer",
+		          "minimum": 1
+		        },
+		        "templates": {
+		          "type": ["object", "null"],
+		          "patternProperties": {
+		            ".*": {
+		              "type": "object",
+		              "properties": {
+		                "scheme": {
+		                  "type": "string",
+		                  "enum": ["none", "power-of-two", "block"]
+		                },
+		                "block-size": {
+		                  "type": "integer",
+		                  "minimum": 1
+		                }
+		              },
+		              "additionalProperties": false
+		            }
+		          }
+		        }
+		      },
+		      "additionalProperties": false
+		    },
+		    "encryption": {
+		      "type": ["object", "null"],
+		      "properties": {
+		        "cipher": {
+		          "type": "string",
+		          "enum": ["aes128", "aes256"]
+		        },
+		        "hash": {
+		          "type": "string",
+		          "enum": ["sha256", "sha384", "sha512"]
+		        },
+		        "aead": {
+		          "type": "string",
+		          "enum": ["none", "eax", "ocb", "gcm"]
+		        }
+		      },
+		      "additionalProperties": false
+		    },
+		    "backend": {
+		      "type": ["string", "null"],
+		      "enum": ["pgp", "age", null]
+		    },
+		    "backends": {
+		      "type": ["array", "null"],
+		      "items": {
+		        "type": "object",
+		        "minProperties": 1,
+		        "maxProperties": 1,
+		        "patternProperties": {
+		          ".*": {
+		            "type": "string",
+		            "enum": ["pgp", "age"]
+		          }
+		        }
+		      }
+		    },
+		    "compression": {
+		      "type": ["array", "null"],
+		      "items": {
+		        "type": "object",
+		        "minProperties": 1,
+		        "maxProperties": 1,
+		        "patternProperties": {
+		          ".*": {
+		            "type": "object",
+		            "properties": {
+		              "container": {
+		                "type": "string",
+		                "enum": ["none", "gzip", "zstd"]
+		              },
+		              "container-level": {
+		                "type": "integer"
+		              },
+		              "packet": {
+		                "type": "string",
+		                "enum": ["none", "zip", "zlib"]
+		              },
+		              "packet-level": {
+		                "type": "integer"
+		              }
+		            },
+		            "additionalProperties": false
+		          }
+		        }
+		      }
+		    },
+		    "signature-quorum": {
+		      "type": ["integer", "null"],
+		      "minimum": 1
+		    },
 		    "keepers": {
 		      "type": "array",
 		      "items": {
@@ -43,11 +235,21 @@ const (
 		      },
 		      "minItems": 1
 		    },
+		    "breakglass": {
+		      "type": ["object", "null"],
+		      "properties": {
+		        "key": {
+		          "type": "string"
+		        }
+		      },
+		      "required": ["key"],
+		      "additionalProperties": false
+		    },
 		    "teams": {
 		      "type": ["object", "null"],
 		      "patternProperties": {
 		        ".*": {
-		          "type": ["array", "null"],
+		          "type": ["array", "string", "null"],
 		          "items": {
 		            "type": "string"
 		          }
@@ -57,6 +259,12 @@ const (
 		    "tags": {
 		      "type": ["string", "null"]
 		    },
+		    "private-tags": {
+		      "type": ["array", "null"],
+		      "items": {
+		        "type": "string"
+		      }
+		    },
 		    "permissions": {
 		      "type": ["array", "null"],
 		      "items": {
@@ -107,21 +315,108 @@ const (
 )
 
 func Init(file string) {
+	initConfig(file, true)
+}
+
+// Local initializations (i.e. editor, hooks & agent) have side effects and
+// are only needed when handling secrets, so they are skipped when evaluating
+// alternative configurations (see Reload()).
+func initConfig(file string, local bool) {
 	checkSchema(file)
 	initRootConfig()
 	initGPGConfig()
-	initEditorConfig()
-	initHooksConfig()
+	initPassphraseConfig()
+	if local {
+		initEditorConfig()
+		initHooksConfig()
+	} else {
+		viper.Set("editor", "")
+		viper.Set("hooks", make(map[string]Hook))
+	}
 	initKeyConfig()
+	initAgeKeyConfig()
+	initKeyPolicyConfig()
 	initPublicKeysConfig()
+	initFingerprintsConfig(file)
+	initPreviousKeysConfig()
 	initIdentity()
+	initKeyringConfig()
+	initSharedPassphraseConfig()
+	if local {
+		initAgentConfig()
+	} else {
+		viper.Set("agent", (*agent.Client)(nil))
+	}
 	initSecretsConfig()
+	initSecretSignaturesConfig()
 	initKeepersConfig()
+	initSignatureConfig(file)
 	initTeamsConfig()
 	initTagsConfig()
 	initPermissionRulesConfig()
 	initTemplatesConfig()
 	initTemplateRulesConfig()
+	initPaddingConfig()
+	initEncryptionConfig()
+	initCompressionRulesConfig()
+	initBackendRulesConfig()
+	initBreakglassConfig()
+}
+
+// Replaces the current configuration with the one in 'file'. The root folder
+// is preserved, as well as strict mode & keepers trusted to sign the
+// configuration (i.e. command line flags), while identity and private key are
+// ignored. Useful to evaluate alternative permission models over the same
+// tomb.
+func Reload(file string) {
+	root := GetRoot()
+	strict := viper.GetBool("strict")
+	trusted := viper.GetStringSlice("trusted-fingerprints")
+	quorum := viper.GetInt("trusted-quorum")
+
+	viper.Reset()
+	viper.SetConfigType("yaml")
+	viper.SetConfigFile(file)
+	if err := viper.ReadInConfig(); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"file":  file,
+			"error": err,
+		}).Fatal("Failed to read configuration file!")
+	}
+
+	viper.Set("root", root)
+	viper.Set("identity", "")
+	viper.Set("key", "")
+	viper.Set("keyring", "")
+	viper.Set("age-key", "")
+	viper.Set("passphrase-fd", -1)
+	viper.Set("strict", strict)
+	viper.Set("trusted-keepers", trusted)
+	viper.Set("trusted-quorum", quorum)
+
+	initConfig(file, false)
+}
+
+// Reads the top level option 'name' (a map of strings) from the YAML
+// configuration file, preserving the case of keys.
+func getRawStringMap(file, name string) (map[string]string, error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var options map[string]interface{}
+	if err := yaml.Unmarshal(content, &options); err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]string)
+	if values, ok := options[name].(map[string]interface{}); ok {
+		for key, value := range values {
+			result[key] = fmt.Sprint(value)
+		}
+	}
+	return result, nil
 }
 
 func checkSchema(file string) {
@@ -183,30 +478,86 @@ func initRootConfig() {
 }
 
 func initGPGConfig() {
-	var command string
-
 	viper.Set("gpg", "")
 	viper.Set("gpg-connect-agent", "")
 
-	if !viper.IsSet("key") || viper.GetString("key") == "" {
-		command = "gpg"
-	} else {
-		command = "gpg-connect-agent"
+	// GPG is only required when private keys are not natively handled, while
+	// GPG Connect Agent is used to ask for passphrases of native private keys
+	// (unless an alternative passphrase source is configured).
+	native := len(getStringList("key")) > 0 || viper.GetString("keyring") != ""
+	commands := make([]string, 0)
+	if !native {
+		commands = append(commands, "gpg")
+	}
+	if (native || viper.GetString("age-key") != "") && getPassphraseSource() == "" {
+		commands = append(commands, "gpg-connect-agent")
 	}
 
-	executable, err := exec.LookPath(command)
-	if err != nil {
+	for _, command := range commands {
+		executable, err := exec.LookPath(command)
+		if err != nil && command == "gpg" {
+			// Only needed to decrypt & sign, which will fail later with an
+			// actionable error.
+			logrus.WithFields(logrus.Fields{
+				"error":   err,
+				"command": command,
+			}).Warn("Failed to locate GPG executable!")
+			continue
+		} else if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"error":   err,
+				"command": command,
+			}).Fatal("Failed to locate GPG executable!")
+		}
+
 		logrus.WithFields(logrus.Fields{
-			"error":   err,
-			"command": command,
-		}).Fatal("Failed to locate GPG executable!")
+			"executable": executable,
+		}).Info("GPG initialized")
+
+		viper.Set(command, executable)
+	}
+}
+
+// Returns the kind of the configured passphrase source, if any. The
+// '--passphrase-fd' flag overrides the 'passphrase' option.
+func getPassphraseSource() string {
+	if viper.IsSet("passphrase-fd") && viper.GetInt("passphrase-fd") >= 0 {
+		return "fd"
+	}
+	for _, kind := range []string{"env", "file", "command", "fd"} {
+		if viper.IsSet("passphrase." + kind) {
+			return kind
+		}
+	}
+	return ""
+}
+
+func initPassphraseConfig() {
+	var prompt passphrase.Prompt
+	source := getPassphraseSource()
+	switch source {
+	case "env":
+		prompt = passphrase.NewEnvPrompt(viper.GetString("passphrase.env"))
+	case "file":
+		prompt = passphrase.NewFilePrompt(viper.GetString("passphrase.file"))
+	case "command":
+		prompt = passphrase.NewCommandPrompt(viper.GetString("passphrase.command"))
+	case "fd":
+		if viper.IsSet("passphrase-fd") && viper.GetInt("passphrase-fd") >= 0 {
+			prompt = passphrase.NewFdPrompt(viper.GetInt("passphrase-fd"))
+		} else {
+			prompt = passphrase.NewFdPrompt(viper.GetInt("passphrase.fd"))
+		}
+	default:
+		source = "agent"
+		prompt = passphrase.NewAgentPrompt(GetGPGConnectAgent())
 	}
 
 	logrus.WithFields(logrus.Fields{
-		"executable": executable,
-	}).Info("GPG initialized")
+		"source": source,
+	}).Info("Passphrase source initialized")
 
-	viper.Set(command, executable)
+	viper.Set("passphrase-prompt", prompt)
 }
 
 func initEditorConfig() {
@@ -265,39 +616,173 @@ func initHooksConfig() {
 	viper.Set("hooks", hooks)
 }
 
+// Several private keys are allowed (e.g. a personal key & a shared key). All
+// of them are used to decrypt, but only the first one is used to sign.
 func initKeyConfig() {
-	path := viper.GetString("key")
+	keys := make([]*pgp.PrivateKey, 0)
+	for _, path := range getStringList("key") {
+		keys = append(keys, loadPrivateKey(path))
+	}
+
+	setPrivateKeys(keys)
+}
+
+func loadPrivateKey(path string) *pgp.PrivateKey {
+	input, err := os.Open(path)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"file":  path,
+			"error": err,
+		}).Fatal("Failed to read private key!")
+	}
+	defer input.Close()
+
+	key, err := pgp.LoadASCIIArmoredPrivateKey(input)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"file":  path,
+			"error": err,
+		}).Fatal("Failed to load private key!")
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"file": path,
+	}).Info("Private key initialized")
+
+	return &key
+}
+
+func setPrivateKeys(keys []*pgp.PrivateKey) {
+	viper.Set("private-keys", keys)
+	if len(keys) > 0 {
+		viper.Set("key", keys[0])
+	} else {
+		viper.Set("key", (*pgp.PrivateKey)(nil))
+	}
+}
+
+// Options accepting a single value or a list of values (e.g. 'identity').
+func getStringList(name string) []string {
+	result := make([]string, 0)
+	switch value := viper.Get(name).(type) {
+	case string:
+		if value != "" {
+			result = append(result, value)
+		}
+	case []string:
+		for _, item := range value {
+			if item != "" {
+				result = append(result, item)
+			}
+		}
+	case []interface{}:
+		for _, item := range value {
+			if item, ok := item.(string); ok && item != "" {
+				result = append(result, item)
+			}
+		}
+	}
+	return result
+}
+
+func initAgeKeyConfig() {
+	path := viper.GetString("age-key")
 	if path != "" {
 		input, err := os.Open(path)
 		if err != nil {
 			logrus.WithFields(logrus.Fields{
 				"file":  path,
 				"error": err,
-			}).Fatal("Failed to read private key!")
+			}).Fatal("Failed to read age private key!")
 		}
 		defer input.Close()
 
-		key, err := pgp.LoadASCIIArmoredPrivateKey(input)
+		key, err := age.LoadPrivateKey(input)
 		if err != nil {
 			logrus.WithFields(logrus.Fields{
 				"file":  path,
 				"error": err,
-			}).Fatal("Failed to load private key!")
+			}).Fatal("Failed to load age private key!")
 		}
 
 		logrus.WithFields(logrus.Fields{
 			"file": path,
-		}).Info("Private key initialized")
+		}).Info("age private key initialized")
 
-		viper.Set("key", &key)
+		viper.Set("age-key", &key)
 	} else {
-		viper.Set("key", (*pgp.PrivateKey)(nil))
+		viper.Set("age-key", (*age.PrivateKey)(nil))
+	}
+}
+
+func initKeyPolicyConfig() {
+	policy := KeyPolicy{
+		Refuse:            viper.GetString("key-policy.action") == "refuse",
+		MinRSABits:        DefaultMinRSABits,
+		ExpirationWarning: DefaultExpirationWarningDays * 24 * time.Hour,
+	}
+	if viper.IsSet("key-policy.min-rsa-bits") {
+		policy.MinRSABits = viper.GetInt("key-policy.min-rsa-bits")
+	}
+	if viper.IsSet("key-policy.expiration-warning-days") {
+		policy.ExpirationWarning =
+			time.Duration(viper.GetInt("key-policy.expiration-warning-days")) * 24 * time.Hour
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"refuse":             policy.Refuse,
+		"min-rsa-bits":       policy.MinRSABits,
+		"expiration-warning": policy.ExpirationWarning,
+	}).Info("Key policy initialized")
+
+	viper.Set("key-policy", policy)
+}
+
+func initPaddingConfig() {
+	parse := func(key string, defaultValue Padding) Padding {
+		result := Padding{
+			Scheme:    defaultValue.Scheme,
+			BlockSize: defaultValue.BlockSize,
+		}
+		if viper.IsSet(key + ".scheme") {
+			result.Scheme = viper.GetString(key + ".scheme")
+		}
+		if viper.IsSet(key + ".block-size") {
+			result.BlockSize = viper.GetInt(key + ".block-size")
+		}
+		return result
+	}
+
+	padding := parse("padding", Padding{
+		Scheme:    PaddingNone,
+		BlockSize: DefaultPaddingBlockSize,
+	})
+
+	templates := make(map[string]Padding)
+	for alias := range viper.GetStringMap("padding.templates") {
+		if _, found := GetTemplates()[alias]; !found {
+			logrus.WithFields(logrus.Fields{
+				"template": alias,
+			}).Warn("Found padding settings for unknown template!")
+		}
+		templates[alias] = parse("padding.templates."+alias, padding)
 	}
+
+	logrus.WithFields(logrus.Fields{
+		"scheme":     padding.Scheme,
+		"block-size": padding.BlockSize,
+		"templates":  len(templates),
+	}).Info("Padding initialized")
+
+	viper.Set("padding", padding)
+	viper.Set("padding-templates", templates)
 }
 
 func initPublicKeysConfig() {
-	keys := make(map[string]*pgp.PublicKey)
-	keysRoot := path.Join(GetRoot(), "keys")
+	keys := make(map[string]*backend.PublicKey)
+	files := make(map[string]string)
+	ageFiles := make(map[string]string)
+	keysRoot := GetPublicKeysRoot()
 
 	if info, err := os.Stat(keysRoot); os.IsNotExist(err) || !info.IsDir() {
 		logrus.WithFields(logrus.Fields{
@@ -306,6 +791,8 @@ func initPublicKeysConfig() {
 		}).Fatal("Failed to access to public keys folder!")
 	}
 
+	// An alias may have both a PGP public key ('.pub') and an age public key
+	// ('.age'), each one used by the matching backend.
 	if err := filepath.Walk(
 		keysRoot,
 		func(path string, info os.FileInfo, err error) error {
@@ -313,7 +800,8 @@ func initPublicKeysConfig() {
 				return err
 			}
 
-			if filepath.Ext(path) == PublicKeyExtension {
+			extension := filepath.Ext(path)
+			if extension == PublicKeyExtension || extension == AgePublicKeyExtension {
 				input, err := os.Open(path)
 				if err != nil {
 					logrus.WithFields(logrus.Fields{
@@ -323,16 +811,43 @@ func initPublicKeysConfig() {
 				}
 				defer input.Close()
 
-				key, err := pgp.LoadASCIIArmoredPublicKey(
-					strings.TrimSuffix(filepath.Base(path), PublicKeyExtension),
-					input)
-				if err != nil {
+				aliasFiles := files
+				if extension == AgePublicKeyExtension {
+					aliasFiles = ageFiles
+				}
+
+				alias := strings.TrimSuffix(filepath.Base(path), extension)
+				if file, found := aliasFiles[alias]; found {
 					logrus.WithFields(logrus.Fields{
-						"file":  path,
-						"error": err,
-					}).Fatal("Failed to load public key!")
+						"key":   alias,
+						"files": strings.Join([]string{file, path}, ", "),
+					}).Fatal("Found duplicated public key alias!")
+				}
+
+				if _, found := keys[alias]; !found {
+					keys[alias] = &backend.PublicKey{Alias: alias}
 				}
-				keys[key.Alias] = &key
+
+				if extension == AgePublicKeyExtension {
+					key, err := age.LoadPublicKey(alias, input)
+					if err != nil {
+						logrus.WithFields(logrus.Fields{
+							"file":  path,
+							"error": err,
+						}).Fatal("Failed to load public key!")
+					}
+					keys[alias].Age = &key
+				} else {
+					key, err := pgp.LoadASCIIArmoredPublicKey(alias, input)
+					if err != nil {
+						logrus.WithFields(logrus.Fields{
+							"file":  path,
+							"error": err,
+						}).Fatal("Failed to load public key!")
+					}
+					keys[alias].PGP = &key
+				}
+				aliasFiles[alias] = path
 			}
 
 			return nil
@@ -353,12 +868,160 @@ func initPublicKeysConfig() {
 		"keys":   strings.Join(keysAliases, ", "),
 	}).Info("Public keys initialized")
 
+	// Check keys according with the key policy.
+	policy := GetKeyPolicy()
+	now := time.Now()
+	issues := make(map[string][]pgp.KeyIssue)
+	for _, alias := range keysAliases {
+		if keys[alias].PGP == nil {
+			continue
+		}
+		issues[alias] = keys[alias].PGP.Check(now, policy.MinRSABits, policy.ExpirationWarning)
+		if len(issues[alias]) > 0 {
+			descriptions := make([]string, 0)
+			for _, issue := range issues[alias] {
+				descriptions = append(descriptions, issue.Description)
+			}
+			logrus.WithFields(logrus.Fields{
+				"key":    alias,
+				"issues": strings.Join(descriptions, ", "),
+			}).Warn("Found public key issues! Run 'pgp-tomb keys check' for details")
+		}
+	}
+
 	viper.Set("keys", keys)
+	viper.Set("keys-files", files)
+	viper.Set("age-keys-files", ageFiles)
+	viper.Set("keys-issues", issues)
 }
 
+// Once some fingerprint is pinned, keys without a pinned fingerprint are
+// considered tampered too (e.g. a key added to the 'keys/' folder by an
+// attacker).
+func initFingerprintsConfig(file string) {
+	fingerprints := make(map[string]string)
+	issues := viper.Get("keys-issues").(map[string][]pgp.KeyIssue)
+	keys := GetPublicKeys()
+
+	// Viper lowercases keys of maps, so aliases are read from the raw YAML.
+	pinned, err := getRawStringMap(file, "fingerprints")
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"file":  file,
+			"error": err,
+		}).Fatal("Failed to load pinned fingerprints!")
+	}
+
+	for keyAlias, fingerprint := range pinned {
+		fingerprint = strings.ToUpper(fingerprint)
+		fingerprints[keyAlias] = fingerprint
+
+		key, found := keys[keyAlias]
+		if !found || key.PGP == nil {
+			logrus.WithFields(logrus.Fields{
+				"key": keyAlias,
+			}).Warn("Found pinned fingerprint for an unknown PGP key!")
+			continue
+		}
+
+		if key.PGP.GetFingerprint() != fingerprint {
+			issues[keyAlias] = append(issues[keyAlias], pgp.KeyIssue{
+				Severe: true,
+				Description: fmt.Sprintf(
+					"fingerprint %s does not match pinned fingerprint %s",
+					key.PGP.GetFingerprint(), fingerprint),
+			})
+			logrus.WithFields(logrus.Fields{
+				"key":         keyAlias,
+				"fingerprint": key.PGP.GetFingerprint(),
+				"pinned":      fingerprint,
+			}).Error("Public key fingerprint does not match pinned fingerprint!")
+		}
+	}
+
+	if len(fingerprints) > 0 {
+		for keyAlias, key := range keys {
+			if _, found := fingerprints[keyAlias]; !found && key.PGP != nil {
+				issues[keyAlias] = append(issues[keyAlias], pgp.KeyIssue{
+					Severe: true,
+					Description: fmt.Sprintf(
+						"fingerprint %s is not pinned", key.PGP.GetFingerprint()),
+				})
+				logrus.WithFields(logrus.Fields{
+					"key":         keyAlias,
+					"fingerprint": key.PGP.GetFingerprint(),
+				}).Error("Public key fingerprint is not pinned!")
+			}
+		}
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"keys": len(fingerprints),
+	}).Info("Pinned fingerprints initialized")
+
+	viper.Set("fingerprints", fingerprints)
+}
+
+// Previous keys are stored in 'keys/.previous/<alias>.json' when rotating keys.
+// Files are ignored when loading public keys, but they are still included in
+// the configuration manifest.
+func initPreviousKeysConfig() {
+	previousKeys := make(map[string][]PreviousKey)
+	root := GetPreviousKeysRoot()
+
+	if files, err := ioutil.ReadDir(root); err == nil {
+		for _, file := range files {
+			if file.IsDir() || filepath.Ext(file.Name()) != PreviousKeysExtension {
+				continue
+			}
+
+			name := path.Join(root, file.Name())
+			content, err := ioutil.ReadFile(name)
+			if err != nil {
+				logrus.WithFields(logrus.Fields{
+					"file":  name,
+					"error": err,
+				}).Fatal("Failed to read previous keys!")
+			}
+
+			var keys []PreviousKey
+			if err := json.Unmarshal(content, &keys); err != nil {
+				logrus.WithFields(logrus.Fields{
+					"file":  name,
+					"error": err,
+				}).Fatal("Failed to parse previous keys!")
+			}
+
+			alias := strings.TrimSuffix(file.Name(), PreviousKeysExtension)
+			for _, key := range keys {
+				if key.Alias != alias {
+					logrus.WithFields(logrus.Fields{
+						"file": name,
+						"key":  key.Alias,
+					}).Fatal("Found previous key not matching file name!")
+				}
+			}
+			previousKeys[alias] = keys
+		}
+	} else if !os.IsNotExist(err) {
+		logrus.WithFields(logrus.Fields{
+			"folder": root,
+			"error":  err,
+		}).Fatal("Failed to access to previous keys folder!")
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"keys": len(previousKeys),
+	}).Info("Previous keys initialized")
+
+	viper.Set("previous-keys", previousKeys)
+}
+
+// Several identities are allowed. The first one is the default identity
+// (e.g. used to sign using GPG).
 func initIdentity() {
-	keyAlias := viper.GetString("identity")
-	if keyAlias != "" {
+	identities := make([]*backend.PublicKey, 0)
+	for _, keyAlias := range getStringList("identity") {
 		keys := GetPublicKeys()
 		key, found := keys[keyAlias]
 		if !found {
@@ -371,12 +1034,161 @@ func initIdentity() {
 			"key": keyAlias,
 		}).Info("Identity initialized")
 
-		viper.Set("identity", key)
+		identities = append(identities, key)
+	}
+
+	viper.Set("identities", identities)
+	if len(identities) > 0 {
+		viper.Set("identity", identities[0])
 	} else {
-		viper.Set("identity", (*pgp.PublicKey)(nil))
+		viper.Set("identity", (*backend.PublicKey)(nil))
 	}
 }
 
+// Private keys are selected using the PGP fingerprints of the identities, so
+// keyrings holding several private keys require the 'identity' option.
+// Identities whose private key is not in the keyring are ignored.
+func initKeyringConfig() {
+	path := viper.GetString("keyring")
+	if path != "" {
+		if len(GetPrivateKeys()) > 0 {
+			logrus.Fatal("Options 'key' and 'keyring' are mutually exclusive!")
+		}
+
+		fingerprints := make([]string, 0)
+		for _, identity := range GetIdentities() {
+			if identity.PGP != nil {
+				fingerprints = append(fingerprints, identity.PGP.GetFingerprint())
+			}
+		}
+		if len(fingerprints) == 0 {
+			fingerprints = append(fingerprints, "")
+		}
+
+		keyring, err := ioutil.ReadFile(path)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"file":  path,
+				"error": err,
+			}).Fatal("Failed to read keyring!")
+		}
+
+		keys := make([]*pgp.PrivateKey, 0)
+		for _, fingerprint := range fingerprints {
+			key, err := pgp.LoadPrivateKeyFromKeyring(bytes.NewReader(keyring), fingerprint)
+			if err != nil {
+				if len(keys) == 0 && fingerprint == fingerprints[len(fingerprints)-1] {
+					logrus.WithFields(logrus.Fields{
+						"file":        path,
+						"fingerprint": fingerprint,
+						"error":       err,
+					}).Fatal("Failed to load private key from keyring!")
+				}
+				logrus.WithFields(logrus.Fields{
+					"file":        path,
+					"fingerprint": fingerprint,
+					"error":       err,
+				}).Info("Ignoring identity without private key in keyring")
+				continue
+			}
+
+			logrus.WithFields(logrus.Fields{
+				"file":        path,
+				"fingerprint": fmt.Sprintf("%X", key.Entity.PrimaryKey.Fingerprint),
+			}).Info("Private key initialized from keyring")
+
+			keys = append(keys, &key)
+		}
+
+		setPrivateKeys(keys)
+	}
+}
+
+// Non-interactive passphrase sources provide a single passphrase, shared by
+// all private keys. When several private keys are passphrase protected, all
+// of them are unlocked up front, so a key expecting a different passphrase
+// is rejected before doing anything else.
+func initSharedPassphraseConfig() {
+	source := getPassphraseSource()
+	if !passphrase.IsNonInteractive(source) {
+		return
+	}
+
+	locked := make([]*pgp.PrivateKey, 0)
+	for _, key := range GetPrivateKeys() {
+		if key.IsLocked() {
+			locked = append(locked, key)
+		}
+	}
+	ageKey := GetAgePrivateKey()
+	if ageKey != nil && !ageKey.IsLocked() {
+		ageKey = nil
+	}
+	if len(locked) < 2 && (len(locked) == 0 || ageKey == nil) {
+		return
+	}
+
+	for _, key := range locked {
+		if err := pgp.UnlockPrivateKey(GetPassphrasePrompt(), key); err != nil {
+			logrus.WithFields(logrus.Fields{
+				"source":      source,
+				"fingerprint": key.GetFingerprint(),
+				"error":       err,
+			}).Fatal("A single passphrase is shared by all private keys when using a non-interactive passphrase source!")
+		}
+	}
+	if ageKey != nil {
+		if err := ageKey.Unlock(GetPassphrasePrompt()); err != nil {
+			logrus.WithFields(logrus.Fields{
+				"source": source,
+				"error":  err,
+			}).Fatal("A single passphrase is shared by all private keys when using a non-interactive passphrase source!")
+		}
+	}
+}
+
+// A running agent is only used if it holds one of the private keys in the
+// 'key' / 'keyring' options or, if none, the PGP key of one of the identities.
+func initAgentConfig() {
+	socket := viper.GetString("agent-socket")
+	if socket == "" {
+		socket = agent.GetDefaultSocket()
+	}
+	viper.Set("agent-socket", socket)
+
+	expected := make([]string, 0)
+	for _, key := range GetPrivateKeys() {
+		expected = append(expected, fmt.Sprintf("%X", key.Entity.PrimaryKey.Fingerprint))
+	}
+	if len(expected) == 0 {
+		for _, identity := range GetIdentities() {
+			if identity.PGP != nil {
+				expected = append(expected, identity.PGP.GetFingerprint())
+			}
+		}
+	}
+
+	client := agent.NewClient(socket)
+	if client.IsAvailable() {
+		if status, err := client.Status(); err == nil {
+			if len(expected) == 0 || containsString(expected, status.Fingerprint) {
+				logrus.WithFields(logrus.Fields{
+					"socket":      socket,
+					"fingerprint": status.Fingerprint,
+				}).Info("Agent initialized")
+				viper.Set("agent", client)
+				return
+			}
+			logrus.WithFields(logrus.Fields{
+				"socket":      socket,
+				"fingerprint": status.Fingerprint,
+			}).Warn("Ignoring agent holding an unexpected private key!")
+		}
+	}
+
+	viper.Set("agent", (*agent.Client)(nil))
+}
+
 func initSecretsConfig() {
 	secretsRoot := path.Join(GetRoot(), "secrets")
 
@@ -394,8 +1206,24 @@ func initSecretsConfig() {
 	viper.Set("secrets", secretsRoot)
 }
 
+func initSecretSignaturesConfig() {
+	sign := viper.GetBool("secret-signatures.sign")
+	policy := viper.GetString("secret-signatures.policy")
+	if policy == "" {
+		policy = SignaturePolicyIgnore
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"sign":   sign,
+		"policy": policy,
+	}).Info("Secret signatures initialized")
+
+	viper.Set("secret-signatures.sign", sign)
+	viper.Set("secret-signatures.policy", policy)
+}
+
 func initKeepersConfig() {
-	keepers := make([]*pgp.PublicKey, 0)
+	keepers := make([]*backend.PublicKey, 0)
 	aliases := make([]string, 0)
 
 	keys := GetPublicKeys()
@@ -417,36 +1245,195 @@ func initKeepersConfig() {
 	viper.Set("keepers", keepers)
 }
 
-func initTeamsConfig() {
-	teams := make(map[string]Team)
+// The break-glass key is an additional recipient of every secret. Its private
+// part is expected to be split among keepers (see 'breakglass split'). Backends
+// in use (i.e. default backend & backend rules) for which the break-glass key
+// has no public key are excluded: secrets encrypted using them don't include
+// the break-glass recipient. Requires backend rules.
+func initBreakglassConfig() {
+	backends := make([]string, 0)
+	keyAlias := viper.GetString("breakglass.key")
+	if keyAlias != "" {
+		key, found := GetPublicKeys()[keyAlias]
+		if !found {
+			logrus.WithFields(logrus.Fields{
+				"key": keyAlias,
+			}).Fatal("Unknown key used as break-glass key!")
+		}
 
-	keys := GetPublicKeys()
+		names := []string{GetBackend()}
+		for _, rule := range GetBackendRules() {
+			if !containsString(names, rule.Backend) {
+				names = append(names, rule.Backend)
+			}
+		}
+		for _, name := range names {
+			if key.HasKeyFor(name) {
+				backends = append(backends, name)
+			} else {
+				logrus.WithFields(logrus.Fields{
+					"key":     keyAlias,
+					"backend": name,
+				}).Warn("Break-glass key has no public key for backend in use! Secrets encrypted using it won't include the break-glass recipient")
+			}
+		}
+
+		logrus.WithFields(logrus.Fields{
+			"key":      keyAlias,
+			"backends": strings.Join(backends, ", "),
+		}).Info("Break-glass key initialized")
+
+		viper.Set("breakglass-key", key)
+	} else {
+		viper.Set("breakglass-key", (*backend.PublicKey)(nil))
+	}
+	viper.Set("breakglass-backends", backends)
+}
+
+func initTeamsConfig() {
+	definitions := make(map[string][]string)
+	queries := make(map[string]string)
 	for teamAlias, teamMapValue := range viper.GetStringMap("teams") {
-		team := Team{
-			Alias: teamAlias,
-			Keys:  make([]*pgp.PublicKey, 0),
-		}
-		if teamMapValue != nil {
-			teamMembers := teamMapValue.([]interface{})
-			for _, keySliceValue := range teamMembers {
-				keyAlias := keySliceValue.(string)
-				key, found := keys[keyAlias]
-				if !found {
-					logrus.WithFields(logrus.Fields{
-						"key":  keyAlias,
-						"team": teamAlias,
-					}).Fatal("Found unknown key in team!")
-				}
-				team.Keys = append(team.Keys, key)
+		switch teamValue := teamMapValue.(type) {
+		case []interface{}:
+			definitions[teamAlias] = make([]string, 0, len(teamValue))
+			for _, memberSliceValue := range teamValue {
+				definitions[teamAlias] = append(definitions[teamAlias], memberSliceValue.(string))
+			}
+		case string:
+			queryParsed, err := query.Parse(teamValue)
+			if err != nil {
+				logrus.WithFields(logrus.Fields{
+					"team":  teamAlias,
+					"query": teamValue,
+					"error": err,
+				}).Fatal("Failed to parse team query!")
+			}
+			if err := query.CheckIdentifiers(queryParsed, isKeyIdentifier); err != nil {
+				logrus.WithFields(logrus.Fields{
+					"team":  teamAlias,
+					"query": teamValue,
+					"error": err,
+				}).Fatal("Invalid team query! Only 'key.*' identifiers are allowed")
+			}
+			definitions[teamAlias] = matchPublicKeys(queryParsed)
+			queries[teamAlias] = teamValue
+		}
+	}
+
+	teams, err := resolveTeams(definitions, GetPublicKeys())
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err,
+		}).Fatal("Failed to resolve teams!")
+	}
+	for alias, queryString := range queries {
+		team := teams[alias]
+		team.Members = nil
+		team.Query = queryString
+		teams[alias] = team
+	}
+
+	res, err := maps.KeysSlice(teams)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	aliases := res.Interface().([]string)
+	sort.Strings(aliases)
+	for _, alias := range aliases {
+		keysAliases := make([]string, 0)
+		for _, key := range teams[alias].Keys {
+			keysAliases = append(keysAliases, key.Alias)
+		}
+		sort.Strings(keysAliases)
+		logrus.WithFields(logrus.Fields{
+			"team": alias,
+			"keys": strings.Join(keysAliases, ", "),
+		}).Info("Team resolved")
+	}
+	logrus.WithFields(logrus.Fields{
+		"teams": strings.Join(aliases, ", "),
+	}).Info("Teams initialized")
+
+	viper.Set("teams", teams)
+}
+
+// Identifiers available when evaluating team queries.
+var keyIdentifiers = []string{
+	"key.alias", "key.folder", "key.fingerprint",
+	"key.uid", "key.name", "key.email", "key.comment",
+}
+
+func isKeyIdentifier(identifier string) bool {
+	return containsString(keyIdentifiers, identifier)
+}
+
+// Identifiers available when evaluating queries over secrets (i.e. rules &
+// the '--query' flag): 'uri' and 'tags.<name>'.
+func IsSecretIdentifier(identifier string) bool {
+	return identifier == "uri" ||
+		(strings.HasPrefix(identifier, "tags.") && len(identifier) > len("tags."))
+}
+
+// Returns aliases of public keys matching a team query. Queries are evaluated
+// once per user ID of each key, using 'key.alias', 'key.folder' (relative to
+// 'keys/'), 'key.fingerprint', 'key.uid', 'key.name', 'key.email' and
+// 'key.comment' identifiers. Keys without a PGP public key have no user IDs.
+func matchPublicKeys(q query.Query) []string {
+	result := make([]string, 0)
+
+	keysRoot := GetPublicKeysRoot()
+	for alias, key := range GetPublicKeys() {
+		file := GetPublicKeyFile(alias)
+		if file == "" {
+			file = GetAgePublicKeyFile(alias)
+		}
+		folder, _ := filepath.Rel(keysRoot, filepath.Dir(file))
+		if folder == "." {
+			folder = ""
+		}
+
+		contexts := make([]query.Map, 0)
+		if key.PGP != nil {
+			for _, identity := range key.PGP.Entity.Identities {
+				contexts = append(contexts, query.Map{
+					"key.uid":     identity.Name,
+					"key.name":    identity.UserId.Name,
+					"key.email":   identity.UserId.Email,
+					"key.comment": identity.UserId.Comment,
+				})
+			}
+		}
+		if len(contexts) == 0 {
+			contexts = append(contexts, query.Map{})
+		}
+
+		for _, context := range contexts {
+			context["key.alias"] = alias
+			context["key.folder"] = filepath.ToSlash(folder)
+			context["key.fingerprint"] = key.GetFingerprint()
+			if q.Eval(context) {
+				result = append(result, alias)
+				break
 			}
-			teams[teamAlias] = team
 		}
 	}
 
-	if _, found := teams["all"]; !found {
+	sort.Strings(result)
+	return result
+}
+
+// Each team definition is an ordered list of members. A member is a key alias
+// or, when prefixed by '@', a reference to another team. Members are added to
+// the team unless prefixed by '-' ('+' is optional). The implicit 'all' team
+// is injected when not explicitly defined.
+func resolveTeams(definitions map[string][]string, keys map[string]*backend.PublicKey) (map[string]Team, error) {
+	teams := make(map[string]Team)
+
+	if _, found := definitions["all"]; !found {
 		team := Team{
 			Alias: "all",
-			Keys:  make([]*pgp.PublicKey, 0),
+			Keys:  make([]*backend.PublicKey, 0),
 		}
 		for _, key := range keys {
 			team.Keys = append(team.Keys, key)
@@ -454,17 +1441,82 @@ func initTeamsConfig() {
 		teams["all"] = team
 	}
 
-	res, err := maps.KeysSlice(teams)
+	var resolve func(alias string, path []string) ([]*backend.PublicKey, error)
+	resolve = func(alias string, path []string) ([]*backend.PublicKey, error) {
+		if team, found := teams[alias]; found {
+			return team.Keys, nil
+		}
+
+		path = append(path, alias)
+		for _, item := range path[:len(path)-1] {
+			if item == alias {
+				return nil, errors.Errorf(
+					"cyclic team reference (%s)", strings.Join(path, " -> "))
+			}
+		}
+
+		definition, found := definitions[alias]
+		if !found {
+			return nil, errors.Errorf(
+				"unknown team '%s' (%s)", alias, strings.Join(path, " -> "))
+		}
+
+		result := make([]*backend.PublicKey, 0)
+		for _, member := range definition {
+			deny := strings.HasPrefix(member, "-")
+			subject := strings.TrimLeft(member, "+-")
+
+			var subjectKeys []*backend.PublicKey
+			if strings.HasPrefix(subject, "@") {
+				var err error
+				subjectKeys, err = resolve(subject[1:], path)
+				if err != nil {
+					return nil, err
+				}
+			} else {
+				key, found := keys[subject]
+				if !found {
+					return nil, errors.Errorf(
+						"unknown key '%s' in team '%s'", subject, alias)
+				}
+				subjectKeys = []*backend.PublicKey{key}
+			}
+
+			var tmp reflect.Value
+			var err error
+			if deny {
+				tmp, err = slices.Difference(result, subjectKeys)
+			} else {
+				tmp, err = slices.Union(result, subjectKeys)
+			}
+			if err != nil {
+				return nil, errors.Wrap(err, "unexpected error")
+			}
+			result = tmp.Interface().([]*backend.PublicKey)
+		}
+
+		teams[alias] = Team{
+			Alias:   alias,
+			Keys:    result,
+			Members: definition,
+		}
+
+		return result, nil
+	}
+
+	res, err := maps.KeysSlice(definitions)
 	if err != nil {
-		logrus.Fatal(err)
+		return nil, err
 	}
 	aliases := res.Interface().([]string)
 	sort.Strings(aliases)
-	logrus.WithFields(logrus.Fields{
-		"teams": strings.Join(aliases, ", "),
-	}).Info("Teams initialized")
+	for _, alias := range aliases {
+		if _, err := resolve(alias, nil); err != nil {
+			return nil, err
+		}
+	}
 
-	viper.Set("teams", teams)
+	return teams, nil
 }
 
 func initTagsConfig() {
@@ -481,9 +1533,38 @@ func initTagsConfig() {
 		}).Fatal("Failed to load tags schema!")
 	}
 
-	logrus.Info("Tags initialized")
+	privateTags := make(map[string]bool)
+	for _, name := range viper.GetStringSlice("private-tags") {
+		privateTags[strings.ToLower(name)] = true
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"private": strings.Join(viper.GetStringSlice("private-tags"), ", "),
+	}).Info("Tags initialized")
 
 	viper.Set("tags", schema)
+	viper.Set("private-tags", privateTags)
+}
+
+// Rules are evaluated over secrets, so only 'uri' and 'tags.*' identifiers
+// are allowed. Private tags are never available when evaluating rules, so
+// referencing them in queries is most likely a mistake.
+func checkRuleQuery(q query.Query, queryString string) {
+	if err := query.CheckIdentifiers(q, IsSecretIdentifier); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"query": queryString,
+			"error": err,
+		}).Fatal("Invalid rule query! Only 'uri' and 'tags.*' identifiers are allowed")
+	}
+
+	for _, identifier := range query.GetIdentifiers(q) {
+		if strings.HasPrefix(identifier, "tags.") && IsPrivateTag(identifier[5:]) {
+			logrus.WithFields(logrus.Fields{
+				"query": queryString,
+				"tag":   identifier[5:],
+			}).Warn("Found reference to a private tag in a rule! Private tags are ignored when evaluating rules")
+		}
+	}
 }
 
 func initPermissionRulesConfig() {
@@ -508,6 +1589,7 @@ func initPermissionRulesConfig() {
 							"error": err,
 						}).Fatal("Failed to parse permissions query!")
 					}
+					checkRuleQuery(queryParsed, queryString)
 					rule.Query = queryParsed
 
 					rule.Expressions = make([]PermissionExpression, 0)
@@ -518,15 +1600,27 @@ func initPermissionRulesConfig() {
 
 						expression.Deny = expressionString[0] == '-'
 
-						expression.Keys = make([]*pgp.PublicKey, 0)
+						expression.Keys = make([]*backend.PublicKey, 0)
 						subject := expressionString[1:]
-						if key, found := keys[subject]; !found {
+						expression.Subject = subject
+						if strings.HasPrefix(subject, "@") {
+							if team, found := teams[subject[1:]]; !found {
+								logrus.WithFields(logrus.Fields{
+									"query":      queryString,
+									"expression": expressionString,
+								}).Fatal("Found unknown team in permissions expression!")
+							} else {
+								expression.Team = team.Alias
+								expression.Keys = team.Keys
+							}
+						} else if key, found := keys[subject]; !found {
 							if team, found := teams[subject]; !found {
 								logrus.WithFields(logrus.Fields{
 									"query":      queryString,
 									"expression": expressionString,
 								}).Fatal("Found unknown key or team in permissions expression!")
 							} else {
+								expression.Team = team.Alias
 								expression.Keys = team.Keys
 							}
 						} else {
@@ -553,7 +1647,7 @@ func initPermissionRulesConfig() {
 
 func initTemplatesConfig() {
 	templates := make(map[string]*Template)
-	templatesRoot := path.Join(GetRoot(), "templates")
+	templatesRoot := GetTemplatesRoot()
 
 	if info, err := os.Stat(templatesRoot); os.IsNotExist(err) || !info.IsDir() {
 		logrus.WithFields(logrus.Fields{
@@ -650,6 +1744,7 @@ func initTemplateRulesConfig() {
 						"error": err,
 					}).Fatal("Failed to parse permissions query!")
 				}
+				checkRuleQuery(queryParsed, queryString)
 				rule.Query = queryParsed
 
 				template, found := templates[templateAlias]
@@ -673,3 +1768,129 @@ func initTemplateRulesConfig() {
 
 	viper.Set("template-rules", rules)
 }
+
+func initEncryptionConfig() {
+	// Cipher & hash are only enforced when explicitly configured. Otherwise
+	// they are negotiated according with preferences of recipients' keys.
+	encryption := Encryption{
+		AEAD: DefaultAEAD,
+	}
+	if viper.IsSet("encryption.cipher") {
+		encryption.Cipher = viper.GetString("encryption.cipher")
+	}
+	if viper.IsSet("encryption.hash") {
+		encryption.Hash = viper.GetString("encryption.hash")
+	}
+	if viper.IsSet("encryption.aead") {
+		encryption.AEAD = viper.GetString("encryption.aead")
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"cipher": encryption.Cipher,
+		"hash":   encryption.Hash,
+		"aead":   encryption.AEAD,
+	}).Info("Encryption initialized")
+
+	viper.Set("encryption", encryption)
+}
+
+func initCompressionRulesConfig() {
+	rules := make([]CompressionRule, 0)
+
+	if _, ok := viper.Get("compression").([]interface{}); ok {
+		for _, itemSliceValue := range viper.Get("compression").([]interface{}) {
+			item := itemSliceValue.(map[interface{}]interface{})
+			for queryStringMapKey, settingsMapValue := range item {
+				queryString := queryStringMapKey.(string)
+
+				var rule CompressionRule
+
+				queryParsed, err := query.Parse(queryString)
+				if err != nil {
+					logrus.WithFields(logrus.Fields{
+						"query": queryString,
+						"error": err,
+					}).Fatal("Failed to parse compression query!")
+				}
+				checkRuleQuery(queryParsed, queryString)
+				rule.Query = queryParsed
+
+				rule.Compression = DefaultCompression
+				if settings, ok := settingsMapValue.(map[interface{}]interface{}); ok {
+					if value, found := settings["container"]; found {
+						rule.Compression.Container = value.(string)
+					}
+					if value, found := settings["container-level"]; found {
+						rule.Compression.ContainerLevel = value.(int)
+					}
+					if value, found := settings["packet"]; found {
+						rule.Compression.Packet = value.(string)
+					}
+					if value, found := settings["packet-level"]; found {
+						rule.Compression.PacketLevel = value.(int)
+					}
+				}
+
+				rules = append(rules, rule)
+				break
+			}
+		}
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"len": len(rules),
+	}).Info("Compression rules initialized")
+
+	viper.Set("compression-rules", rules)
+}
+
+func initBackendRulesConfig() {
+	defaultBackend := DefaultBackend
+	if value := viper.GetString("backend"); value != "" {
+		defaultBackend = value
+	}
+
+	rules := make([]BackendRule, 0)
+
+	if _, ok := viper.Get("backends").([]interface{}); ok {
+		for _, itemSliceValue := range viper.Get("backends").([]interface{}) {
+			item := itemSliceValue.(map[interface{}]interface{})
+			for queryStringMapKey, backendMapValue := range item {
+				queryString := queryStringMapKey.(string)
+
+				var rule BackendRule
+
+				queryParsed, err := query.Parse(queryString)
+				if err != nil {
+					logrus.WithFields(logrus.Fields{
+						"query": queryString,
+						"error": err,
+					}).Fatal("Failed to parse backend query!")
+				}
+				checkRuleQuery(queryParsed, queryString)
+				rule.Query = queryParsed
+				rule.Backend = backendMapValue.(string)
+
+				rules = append(rules, rule)
+				break
+			}
+		}
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"backend": defaultBackend,
+		"len":     len(rules),
+	}).Info("Backend rules initialized")
+
+	viper.Set("backend", defaultBackend)
+	viper.Set("backend-rules", rules)
+}
+
+func containsString(items []string, item string) bool {
+	for _, anItem := range items {
+		if anItem == item {
+			return true
+		}
+	}
+	return false
+}
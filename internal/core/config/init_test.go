@@ -0,0 +1,229 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/carlosabalde/pgp-tomb/internal/helpers/age"
+	"github.com/carlosabalde/pgp-tomb/internal/helpers/backend"
+	"github.com/carlosabalde/pgp-tomb/internal/helpers/pgp"
+)
+
+func TestResolveTeams(t *testing.T) {
+	keys := make(map[string]*backend.PublicKey)
+	for _, alias := range []string{"alice", "bob", "chuck", "dave", "intern"} {
+		keys[alias] = &backend.PublicKey{Alias: alias}
+	}
+
+	aliases := func(team Team) []string {
+		result := make([]string, 0)
+		for _, key := range team.Keys {
+			result = append(result, key.Alias)
+		}
+		return result
+	}
+
+	if teams, err := resolveTeams(map[string][]string{
+		"backend": {"alice", "intern"},
+		"sre":     {"+bob", "chuck"},
+		"ops":     {"@backend", "+@sre", "-intern"},
+		"nobody":  {"@all", "-@all"},
+	}, keys); assert.NoError(t, err) {
+		assert.ElementsMatch(t, aliases(teams["backend"]), []string{"alice", "intern"})
+		assert.ElementsMatch(t, aliases(teams["sre"]), []string{"bob", "chuck"})
+		assert.ElementsMatch(t, aliases(teams["ops"]), []string{"alice", "bob", "chuck"})
+		assert.ElementsMatch(t, aliases(teams["nobody"]), []string{})
+		assert.ElementsMatch(
+			t,
+			aliases(teams["all"]),
+			[]string{"alice", "bob", "chuck", "dave", "intern"})
+	}
+
+	if _, err := resolveTeams(map[string][]string{
+		"foo": {"alice", "@bar"},
+		"bar": {"@baz"},
+		"baz": {"@foo"},
+	}, keys); assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "cyclic team reference")
+	}
+
+	if _, err := resolveTeams(map[string][]string{
+		"foo": {"@bar"},
+	}, keys); assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "unknown team 'bar'")
+	}
+
+	if _, err := resolveTeams(map[string][]string{
+		"foo": {"mallory"},
+	}, keys); assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "unknown key 'mallory'")
+	}
+}
+
+func TestGetStringList(t *testing.T) {
+	defer viper.Reset()
+
+	tests := []struct {
+		value    interface{}
+		expected []string
+	}{
+		{nil, []string{}},
+		{"", []string{}},
+		{"alice", []string{"alice"}},
+		{[]string{"alice", "", "ops"}, []string{"alice", "ops"}},
+		{[]interface{}{"alice", "ops"}, []string{"alice", "ops"}},
+	}
+
+	for _, test := range tests {
+		viper.Set("identity", test.value)
+		assert.Equal(t, test.expected, getStringList("identity"))
+	}
+}
+
+func TestInitPreviousKeysConfig(t *testing.T) {
+	defer viper.Reset()
+
+	root, err := ioutil.TempDir("", "pgp-tomb")
+	assert.NoError(t, err)
+	defer os.RemoveAll(root)
+	viper.Set("root", root)
+
+	// No previous keys folder.
+	initPreviousKeysConfig()
+	assert.Empty(t, GetAllPreviousKeys())
+	assert.Nil(t, GetPreviousKeys("alice"))
+
+	// Previous keys of 'alice'.
+	assert.NoError(t, os.MkdirAll(GetPreviousKeysRoot(), 0755))
+	assert.NoError(t, ioutil.WriteFile(GetPreviousKeysFile("alice"), []byte(`[
+	  {"alias": "alice", "backend": "pgp", "fingerprint": "ABCD", "ids": ["0x1", "0x2"]}
+	]`), 0644))
+	assert.NoError(t, ioutil.WriteFile(path.Join(GetPreviousKeysRoot(), "README"), []byte("-"), 0644))
+	initPreviousKeysConfig()
+	assert.Len(t, GetAllPreviousKeys(), 1)
+	if keys := GetPreviousKeys("alice"); assert.Len(t, keys, 1) {
+		assert.Equal(t, backend.PGP, keys[0].Backend)
+		assert.Equal(t, "ABCD", keys[0].Fingerprint)
+		assert.Equal(t, []string{"0x1", "0x2"}, keys[0].KeyIds)
+	}
+}
+
+func TestInitFingerprintsConfig(t *testing.T) {
+	defer viper.Reset()
+
+	root, err := ioutil.TempDir("", "pgp-tomb")
+	assert.NoError(t, err)
+	defer os.RemoveAll(root)
+	file := path.Join(root, "pgp-tomb.yaml")
+
+	alice, _ := loadTestKeeper(t, "Alice", "alice")
+	bob, _ := loadTestKeeper(t, "bob", "bob")
+	chuck, _ := loadTestKeeper(t, "chuck", "chuck")
+	viper.Set("keys", map[string]*backend.PublicKey{
+		"Alice": alice,
+		"bob":   bob,
+		"chuck": chuck,
+	})
+	viper.Set("keys-issues", make(map[string][]pgp.KeyIssue))
+
+	// Mixed case aliases match, while unpinned keys are flagged.
+	assert.NoError(t, ioutil.WriteFile(file, []byte(
+		"fingerprints:\n"+
+			"  Alice: "+strings.ToLower(alice.PGP.GetFingerprint())+"\n"+
+			"  bob: "+chuck.PGP.GetFingerprint()+"\n"), 0644))
+	initFingerprintsConfig(file)
+	assert.Equal(t, alice.PGP.GetFingerprint(), GetPinnedFingerprint("Alice"))
+	assert.Empty(t, GetPublicKeyIssues("Alice"))
+	if issues := GetPublicKeyIssues("bob"); assert.Len(t, issues, 1) {
+		assert.True(t, issues[0].Severe)
+		assert.Contains(t, issues[0].Description, "does not match pinned fingerprint")
+	}
+	if issues := GetPublicKeyIssues("chuck"); assert.Len(t, issues, 1) {
+		assert.True(t, issues[0].Severe)
+		assert.Contains(t, issues[0].Description, "is not pinned")
+	}
+
+	// Without pinned fingerprints no key is flagged.
+	viper.Set("keys-issues", make(map[string][]pgp.KeyIssue))
+	assert.NoError(t, ioutil.WriteFile(file, []byte("keepers: [Alice]\n"), 0644))
+	initFingerprintsConfig(file)
+	assert.Empty(t, GetPinnedFingerprints())
+	assert.Empty(t, GetPublicKeyIssues("chuck"))
+}
+
+func TestInitSharedPassphraseConfig(t *testing.T) {
+	defer viper.Reset()
+
+	os.Setenv("PGP_TOMB_TEST_PASSPHRASE", "s3cr3t")
+	defer os.Unsetenv("PGP_TOMB_TEST_PASSPHRASE")
+	viper.Set("passphrase.env", "PGP_TOMB_TEST_PASSPHRASE")
+	initPassphraseConfig()
+
+	// Private keys sharing the passphrase are unlocked up front.
+	keys := make([]*pgp.PrivateKey, 0)
+	for _, file := range []string{"alice", "bob"} {
+		keys = append(keys, loadPrivateKey("../../../files/keys/"+file+".pri"))
+	}
+	setPrivateKeys(keys)
+	viper.Set("age-key", (*age.PrivateKey)(nil))
+	initSharedPassphraseConfig()
+	for _, key := range keys {
+		assert.False(t, key.IsLocked())
+	}
+}
+
+func TestInitBreakglassConfig(t *testing.T) {
+	defer viper.Reset()
+
+	emergency, _ := loadTestKeeper(t, "emergency", "chuck")
+	viper.Set("keys", map[string]*backend.PublicKey{"emergency": emergency})
+	viper.Set("breakglass.key", "emergency")
+
+	// PGP only break-glass key is excluded from age secrets.
+	viper.Set("backend", backend.PGP)
+	viper.Set("backend-rules", []BackendRule{{Backend: backend.Age}})
+	initBreakglassConfig()
+	assert.Equal(t, emergency, GetBreakglassKey())
+	assert.Equal(t, emergency, GetBreakglassKeyFor(backend.PGP))
+	assert.Nil(t, GetBreakglassKeyFor(backend.Age))
+
+	// Without break-glass key no backend is covered.
+	viper.Set("breakglass.key", "")
+	initBreakglassConfig()
+	assert.Nil(t, GetBreakglassKey())
+	assert.Nil(t, GetBreakglassKeyFor(backend.PGP))
+}
+
+func TestInitEncryptionConfig(t *testing.T) {
+	defer viper.Reset()
+
+	// Unset cipher & hash are negotiated.
+	initEncryptionConfig()
+	assert.Equal(t, Encryption{AEAD: DefaultAEAD}, GetEncryption())
+
+	viper.Set("encryption.cipher", "aes128")
+	viper.Set("encryption.hash", "sha512")
+	initEncryptionConfig()
+	assert.Equal(t, Encryption{Cipher: "aes128", Hash: "sha512", AEAD: DefaultAEAD}, GetEncryption())
+}
+
+func TestQueryIdentifiers(t *testing.T) {
+	for _, identifier := range []string{"uri", "tags.foo"} {
+		assert.True(t, IsSecretIdentifier(identifier), identifier)
+		assert.False(t, isKeyIdentifier(identifier), identifier)
+	}
+	for _, identifier := range []string{"key.alias", "key.email"} {
+		assert.False(t, IsSecretIdentifier(identifier), identifier)
+		assert.True(t, isKeyIdentifier(identifier), identifier)
+	}
+	for _, identifier := range []string{"tags.", "key.foo", "foo"} {
+		assert.False(t, IsSecretIdentifier(identifier), identifier)
+		assert.False(t, isKeyIdentifier(identifier), identifier)
+	}
+}
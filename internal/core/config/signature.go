@@ -0,0 +1,143 @@
+package config
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+
+	"github.com/carlosabalde/pgp-tomb/internal/helpers/pgp"
+)
+
+// Builds a manifest including SHA-256 digests of the configuration file and of
+// all files in the 'keys/' & 'templates/' folders. This is the message signed
+// by keepers using 'pgp-tomb config sign'.
+func BuildManifest(file string) ([]byte, error) {
+	var manifest bytes.Buffer
+
+	appendFile := func(name, path string) error {
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return errors.Wrapf(err, "failed to read '%s'", path)
+		}
+		fmt.Fprintf(&manifest, "%x  %s\n", sha256.Sum256(content), name)
+		return nil
+	}
+
+	if err := appendFile("config", file); err != nil {
+		return nil, err
+	}
+
+	for _, folder := range []string{"keys", "templates"} {
+		folderRoot := filepath.Join(GetRoot(), folder)
+		if _, err := os.Stat(folderRoot); os.IsNotExist(err) {
+			continue
+		}
+		if err := filepath.Walk(
+			folderRoot,
+			func(path string, info os.FileInfo, err error) error {
+				if err != nil {
+					return err
+				}
+				if info.Mode().IsRegular() {
+					name, err := filepath.Rel(GetRoot(), path)
+					if err != nil {
+						return err
+					}
+					return appendFile(filepath.ToSlash(name), path)
+				}
+				return nil
+			}); err != nil {
+			return nil, errors.Wrapf(err, "failed to walk '%s' folder", folder)
+		}
+	}
+
+	return manifest.Bytes(), nil
+}
+
+func initSignatureConfig(file string) {
+	// Initializations.
+	signatureFile := file + SignatureExtension
+	quorum := 1
+	if viper.IsSet("signature-quorum") {
+		quorum = viper.GetInt("signature-quorum")
+	}
+	if trustedQuorum := viper.GetInt("trusted-quorum"); trustedQuorum > quorum {
+		quorum = trustedQuorum
+	}
+	viper.Set("signature-file", signatureFile)
+	viper.Set("signature-quorum", quorum)
+	viper.Set("signers", []string{})
+
+	// Keepers & quorum are read from the (maybe tampered) configuration file,
+	// so only signatures made by keepers whose fingerprints are trusted out
+	// of band count.
+	trusted := make(map[string]bool)
+	fingerprints := make([]string, 0)
+	for _, fingerprint := range viper.GetStringSlice("trusted-keepers") {
+		fingerprint = strings.ToUpper(strings.Replace(strings.TrimSpace(fingerprint), " ", "", -1))
+		if fingerprint != "" && !trusted[fingerprint] {
+			trusted[fingerprint] = true
+			fingerprints = append(fingerprints, fingerprint)
+		}
+	}
+	viper.Set("trusted-fingerprints", fingerprints)
+	keys := make([]*pgp.PublicKey, 0)
+	for _, key := range GetKeeperPGPKeys() {
+		if trusted[key.GetFingerprint()] {
+			keys = append(keys, key)
+		}
+	}
+	viper.Set("trusted-keepers", keys)
+
+	// Verify signatures.
+	var reason string
+	signers := make([]string, 0)
+	if signatures, err := ioutil.ReadFile(signatureFile); os.IsNotExist(err) {
+		reason = "configuration is not signed"
+	} else if err != nil {
+		reason = err.Error()
+	} else if len(trusted) == 0 {
+		reason = "no trusted keepers (use --trusted-keepers or $PGP_TOMB_TRUSTED_KEEPERS)"
+	} else if manifest, err := BuildManifest(file); err != nil {
+		reason = err.Error()
+	} else if keys, err := pgp.VerifySignatures(signatures, manifest, keys); err != nil {
+		reason = err.Error()
+	} else {
+		for _, key := range keys {
+			signers = append(signers, key.Alias)
+		}
+		if len(signers) < quorum {
+			reason = fmt.Sprintf(
+				"%d valid keeper signatures found, %d required", len(signers), quorum)
+		}
+	}
+	viper.Set("signers", signers)
+
+	// Done!
+	fields := logrus.Fields{
+		"file":    signatureFile,
+		"trusted": len(keys),
+		"signers": strings.Join(signers, ", "),
+		"quorum":  quorum,
+	}
+	if reason == "" {
+		logrus.WithFields(fields).Info("Configuration signature verified")
+		return
+	}
+	fields["reason"] = reason
+	if viper.GetBool("strict") {
+		logrus.WithFields(fields).Fatal("Failed to verify configuration signature!")
+	} else if _, err := os.Stat(signatureFile); err == nil {
+		logrus.WithFields(fields).Warn("Failed to verify configuration signature!")
+	} else {
+		logrus.WithFields(fields).Info("Configuration signature not verified")
+	}
+}
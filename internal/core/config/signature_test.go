@@ -0,0 +1,148 @@
+package config
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/carlosabalde/pgp-tomb/internal/helpers/backend"
+	"github.com/carlosabalde/pgp-tomb/internal/helpers/pgp"
+)
+
+func loadTestKeeper(t *testing.T, alias, file string) (*backend.PublicKey, *pgp.PrivateKey) {
+	input, err := os.Open("../../../files/keys/" + file + ".pub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer input.Close()
+	publicKey, err := pgp.LoadASCIIArmoredPublicKey(alias, input)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	input, err = os.Open("../../../files/keys/" + file + ".pri")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer input.Close()
+	privateKey, err := pgp.LoadASCIIArmoredPrivateKey(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := privateKey.Entity.PrivateKey.Decrypt([]byte("s3cr3t")); err != nil {
+		t.Fatal(err)
+	}
+	for _, subkey := range privateKey.Entity.Subkeys {
+		if err := subkey.PrivateKey.Decrypt([]byte("s3cr3t")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return &backend.PublicKey{Alias: alias, PGP: &publicKey}, &privateKey
+}
+
+func TestInitSignatureConfig(t *testing.T) {
+	defer viper.Reset()
+
+	root, err := ioutil.TempDir("", "pgp-tomb")
+	assert.NoError(t, err)
+	defer os.RemoveAll(root)
+	viper.Set("root", root)
+	file := path.Join(root, "pgp-tomb.yaml")
+
+	alice, alicePrivateKey := loadTestKeeper(t, "alice", "alice")
+	mallory, malloryPrivateKey := loadTestKeeper(t, "mallory", "bob")
+
+	sign := func(key *pgp.PrivateKey) {
+		manifest, err := BuildManifest(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var signature bytes.Buffer
+		if err := pgp.Sign(nil, bytes.NewReader(manifest), &signature, key); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(file+SignatureExtension, signature.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Configuration signed by a trusted keeper.
+	assert.NoError(t, ioutil.WriteFile(file, []byte("keepers: [alice]\n"), 0644))
+	viper.Set("keepers", []*backend.PublicKey{alice})
+	viper.Set("trusted-keepers", []string{alice.PGP.GetFingerprint()})
+	sign(alicePrivateKey)
+	initSignatureConfig(file)
+	assert.Equal(t, []string{"alice"}, GetSigners())
+
+	// Tampered configuration naming an untrusted keeper, lowering the quorum
+	// & signed by that keeper.
+	assert.NoError(t, ioutil.WriteFile(file, []byte("keepers: [alice, mallory]\nsignature-quorum: 1\n"), 0644))
+	viper.Set("keepers", []*backend.PublicKey{alice, mallory})
+	viper.Set("signature-quorum", 1)
+	viper.Set("trusted-keepers", []string{alice.PGP.GetFingerprint()})
+	sign(malloryPrivateKey)
+	initSignatureConfig(file)
+	assert.Empty(t, GetSigners())
+	assert.Equal(t, []*pgp.PublicKey{alice.PGP}, GetTrustedKeeperPGPKeys())
+
+	// Without trusted keepers no signature counts.
+	viper.Set("trusted-keepers", []string{})
+	initSignatureConfig(file)
+	assert.Empty(t, GetSigners())
+
+	// The trusted quorum cannot be lowered by the configuration.
+	assert.NoError(t, ioutil.WriteFile(file, []byte("keepers: [alice]\n"), 0644))
+	viper.Set("keepers", []*backend.PublicKey{alice})
+	viper.Set("trusted-keepers", []string{alice.PGP.GetFingerprint()})
+	viper.Set("trusted-quorum", 2)
+	sign(alicePrivateKey)
+	initSignatureConfig(file)
+	assert.Equal(t, []string{"alice"}, GetSigners())
+	assert.Equal(t, 2, GetSignatureQuorum())
+}
+
+func TestReloadPreservesTrustedKeepers(t *testing.T) {
+	defer viper.Reset()
+
+	// Tomb without hooks folder, which is only needed when handling secrets.
+	root, err := ioutil.TempDir("", "pgp-tomb")
+	assert.NoError(t, err)
+	defer os.RemoveAll(root)
+	for _, folder := range []string{"keys", "secrets", "templates"} {
+		assert.NoError(t, os.Mkdir(path.Join(root, folder), 0700))
+	}
+	data, err := ioutil.ReadFile("../../../files/keys/alice.pub")
+	assert.NoError(t, err)
+	assert.NoError(t, ioutil.WriteFile(path.Join(root, "keys", "alice.pub"), data, 0644))
+	viper.Set("root", root)
+
+	// Alternative configuration signed by a trusted keeper.
+	alice, alicePrivateKey := loadTestKeeper(t, "alice", "alice")
+	file := path.Join(root, "pgp-tomb.yaml")
+	assert.NoError(t, ioutil.WriteFile(file, []byte("keepers: [alice]\n"), 0644))
+	manifest, err := BuildManifest(file)
+	assert.NoError(t, err)
+	var signature bytes.Buffer
+	assert.NoError(t, pgp.Sign(nil, bytes.NewReader(manifest), &signature, alicePrivateKey))
+	assert.NoError(t, ioutil.WriteFile(file+SignatureExtension, signature.Bytes(), 0644))
+
+	// Command line flags (see 'initConfig()' in 'pgp-tomb.go') & current
+	// configuration.
+	viper.Set("trusted-keepers", []string{alice.PGP.GetFingerprint()})
+	viper.Set("trusted-quorum", 1)
+	viper.Set("keepers", []*backend.PublicKey{alice})
+	initSignatureConfig(path.Join(root, "current.yaml"))
+	viper.Set("strict", true)
+
+	Reload(file)
+	assert.True(t, viper.GetBool("strict"))
+	assert.Equal(t, 1, viper.GetInt("trusted-quorum"))
+	assert.Equal(t, []string{"alice"}, GetSigners())
+	assert.Equal(t, root, GetRoot())
+}
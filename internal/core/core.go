@@ -1,16 +1,22 @@
 package core
 
 import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
 	"github.com/ghodss/yaml"
 	"github.com/sirupsen/logrus"
 	"github.com/xeipuuv/gojsonschema"
 
 	"github.com/carlosabalde/pgp-tomb/internal/core/config"
 	"github.com/carlosabalde/pgp-tomb/internal/core/query"
-	"github.com/carlosabalde/pgp-tomb/internal/helpers/pgp"
+	"github.com/carlosabalde/pgp-tomb/internal/helpers/backend"
 )
 
-func findPublicKey(alias string) *pgp.PublicKey {
+func findPublicKey(alias string) *backend.PublicKey {
 	keys := config.GetPublicKeys()
 	if key, found := keys[alias]; found {
 		return key
@@ -18,6 +24,25 @@ func findPublicKey(alias string) *pgp.PublicKey {
 	return nil
 }
 
+// Public keys matching 'aliases' or, if none, the identities. Used to filter
+// secrets readable by any of them.
+func findRecipientKeys(aliases []string) []*backend.PublicKey {
+	if len(aliases) == 0 {
+		return config.GetIdentities()
+	}
+
+	result := make([]*backend.PublicKey, 0, len(aliases))
+	for _, alias := range aliases {
+		key := findPublicKey(alias)
+		if key == nil {
+			fmt.Fprintf(os.Stderr, "Key '%s' does not exist!\n", alias)
+			os.Exit(1)
+		}
+		result = append(result, key)
+	}
+	return result
+}
+
 func parseQuery(queryString string) (result query.Query) {
 	if queryString != "" {
 		var err error
@@ -28,6 +53,12 @@ func parseQuery(queryString string) (result query.Query) {
 				"error": err,
 			}).Fatal("Failed to parse query!")
 		}
+		if err := query.CheckIdentifiers(result, config.IsSecretIdentifier); err != nil {
+			logrus.WithFields(logrus.Fields{
+				"query": queryString,
+				"error": err,
+			}).Fatal("Invalid query! Only 'uri' and 'tags.*' identifiers are allowed")
+		}
 	} else {
 		result = query.True
 	}
@@ -57,3 +88,43 @@ func validateSchema(value string, schema *gojsonschema.Schema) (bool, []string)
 
 	return true, errors
 }
+
+func walkSecrets(folderOrUri string, callback func(uri string)) {
+	// Define walk function.
+	walk := func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && filepath.Ext(path) == config.SecretExtension {
+			uri := strings.TrimPrefix(path, config.GetSecretsRoot())
+			uri = strings.TrimPrefix(uri, string(os.PathSeparator))
+			uri = strings.TrimSuffix(uri, config.SecretExtension)
+			callback(uri)
+		}
+		return nil
+	}
+
+	// Check folder vs. URI & walk file system.
+	root := ""
+	if folderOrUri != "" {
+		item := path.Join(config.GetSecretsRoot(), folderOrUri+config.SecretExtension)
+		if info, err := os.Stat(item); err == nil && !info.IsDir() {
+			walk(item, info, err)
+		} else {
+			root = path.Join(config.GetSecretsRoot(), folderOrUri)
+			if info, err := os.Stat(root); os.IsNotExist(err) || !info.IsDir() {
+				fmt.Fprintln(os.Stderr, "Folder does not exist!")
+				os.Exit(1)
+			}
+		}
+	} else {
+		root = config.GetSecretsRoot()
+	}
+	if root != "" {
+		if err := filepath.Walk(root, walk); err != nil {
+			logrus.WithFields(logrus.Fields{
+				"error": err,
+			}).Fatal("Failed to walk secrets!")
+		}
+	}
+}
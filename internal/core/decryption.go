@@ -0,0 +1,82 @@
+package core
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/carlosabalde/pgp-tomb/internal/core/secret"
+)
+
+// Exit codes of commands unable to decrypt secrets. Any other failure exits
+// with 'exitFailure'.
+const (
+	exitFailure      = 1
+	exitNotRecipient = 2
+	exitNoSecretKey  = 3
+	exitPassphrase   = 4
+	exitCorrupt      = 5
+	exitGPGMissing   = 6
+)
+
+var decryptionFailures = map[string]struct {
+	message  string
+	exitCode int
+}{
+	secret.DecryptionNotRecipient: {
+		"You are not a recipient of this secret. Ask someone allowed to access it to grant you access.",
+		exitNotRecipient,
+	},
+	secret.DecryptionNoSecretKey: {
+		"No private key for any of the recipients is available locally. Check --key, --keyring or your GPG keyring.",
+		exitNoSecretKey,
+	},
+	secret.DecryptionPassphrase: {
+		"Wrong passphrase or cancelled prompt while unlocking your private key.",
+		exitPassphrase,
+	},
+	secret.DecryptionCorrupt: {
+		"The secret file seems to be corrupt or truncated.",
+		exitCorrupt,
+	},
+	secret.DecryptionGPGMissing: {
+		"GPG executable not found. Install it or use --key / --keyring instead.",
+		exitGPGMissing,
+	},
+}
+
+// Returns the reason why decrypting a secret failed (see
+// secret.DecryptionFailed).
+func getDecryptionFailureReason(err error) string {
+	if err, ok := err.(*secret.DecryptionFailed); ok {
+		return err.Reason
+	}
+	return secret.DecryptionUnknown
+}
+
+func getDecryptionFailureExitCode(reason string) int {
+	if failure, found := decryptionFailures[reason]; found {
+		return failure.exitCode
+	}
+	return exitFailure
+}
+
+// Reports a failure to decrypt a secret & exits with the exit code matching
+// the reason.
+func exitOnDecryptionFailure(uri string, err error) {
+	logrus.WithFields(logrus.Fields{
+		"error": err,
+		"uri":   uri,
+	}).Info("Failed to decrypt secret")
+
+	reason := getDecryptionFailureReason(err)
+	if failure, found := decryptionFailures[reason]; found {
+		fmt.Fprintf(os.Stderr, "Unable to decrypt secret! %s\n", failure.message)
+	} else {
+		fmt.Fprintln(
+			os.Stderr,
+			"Unable to decrypt secret! Are you allowed to access it?")
+	}
+	os.Exit(getDecryptionFailureExitCode(reason))
+}
@@ -30,10 +30,17 @@ func Edit(uri string, dropTags bool, tags []secret.Tag, ignoreSchema bool) {
 	switch err := err.(type) {
 	case nil:
 		if err := s.Decrypt(output); err != nil {
-			fmt.Fprintln(
-				os.Stderr,
-				"Unable to decrypt secret! Are you allowed to access it?")
-			os.Exit(1)
+			if err, ok := err.(*secret.SignatureRejected); ok {
+				fmt.Fprintf(os.Stderr, "Secret %s!\n", err)
+				os.Exit(1)
+			}
+			if _, ok := err.(*secret.TagsMismatch); ok {
+				fmt.Fprintln(
+					os.Stderr,
+					"Secret tags do not match encrypted tags digest! Have they been tampered with?")
+				os.Exit(1)
+			}
+			exitOnDecryptionFailure(uri, err)
 		}
 	case *secret.DoesNotExist:
 		s = secret.New(uri)
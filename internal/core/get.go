@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 
 	"github.com/atotto/clipboard"
@@ -12,7 +13,7 @@ import (
 	"github.com/carlosabalde/pgp-tomb/internal/core/secret"
 )
 
-func Get(uri, outputPath string, copyToClipboard bool) {
+func Get(uri, outputPath string, copyToClipboard, showTags bool) {
 	// Load secret.
 	s, err := secret.Load(uri)
 	if err != nil {
@@ -49,12 +50,29 @@ func Get(uri, outputPath string, copyToClipboard bool) {
 		}
 	}
 
-	// Decrypt secret.
-	if err := s.Decrypt(output); err != nil {
-		fmt.Fprintln(
-			os.Stderr,
-			"Unable to decrypt secret! Are you allowed to access it?")
-		os.Exit(1)
+	// Decrypt secret. When showing tags the secret is only decrypted in order
+	// to get access to private tags.
+	secretOutput := output
+	if showTags {
+		secretOutput = ioutil.Discard
+	}
+	if err := s.DecryptIgnoringTags(secretOutput); err != nil {
+		if err, ok := err.(*secret.SignatureRejected); ok {
+			fmt.Fprintf(os.Stderr, "Secret %s!\n", err)
+			os.Exit(1)
+		}
+		exitOnDecryptionFailure(uri, err)
+	}
+
+	// Dump tags?
+	if showTags {
+		for _, tag := range s.GetTags() {
+			if _, err := fmt.Fprintf(output, "%s: %s\n", tag.Name, tag.Value); err != nil {
+				logrus.WithFields(logrus.Fields{
+					"error": err,
+				}).Fatal("Failed to dump tags!")
+			}
+		}
 	}
 
 	// Copy decrypted secret to system clipboard?
@@ -23,6 +23,11 @@ identity:
 
 key:
 
+key-policy:
+  action: warn
+  min-rsa-bits: 2048
+  expiration-warning-days: 30
+
 keepers:
   - alice
 
@@ -0,0 +1,893 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ghodss/yaml"
+	"github.com/sirupsen/logrus"
+
+	"github.com/carlosabalde/pgp-tomb/internal/core/config"
+	"github.com/carlosabalde/pgp-tomb/internal/core/secret"
+	"github.com/carlosabalde/pgp-tomb/internal/helpers/age"
+	"github.com/carlosabalde/pgp-tomb/internal/helpers/backend"
+	"github.com/carlosabalde/pgp-tomb/internal/helpers/maps"
+	"github.com/carlosabalde/pgp-tomb/internal/helpers/pgp"
+)
+
+// PGP related fields are empty for keys without a PGP public key.
+type exportedKey struct {
+	File        string             `json:"file"`
+	Fingerprint string             `json:"fingerprint"`
+	UserIds     []string           `json:"uids"`
+	Algorithms  []string           `json:"algorithms"`
+	Created     string             `json:"created"`
+	Expires     *string            `json:"expires"`
+	Age         *exportedAgeKey    `json:"age"`
+	Teams       []string           `json:"teams"`
+	Keeper      bool               `json:"keeper"`
+	Primary     *exportedKeyInfo   `json:"primary,omitempty"`
+	Subkeys     []*exportedKeyInfo `json:"subkeys,omitempty"`
+	References  []string           `json:"references,omitempty"`
+}
+
+type exportedAgeKey struct {
+	File        string `json:"file"`
+	Type        string `json:"type"`
+	Fingerprint string `json:"fingerprint"`
+}
+
+type exportedKeyInfo struct {
+	KeyId        string   `json:"id"`
+	Algorithm    string   `json:"algorithm"`
+	Created      string   `json:"created"`
+	Expires      *string  `json:"expires"`
+	Revoked      bool     `json:"revoked"`
+	Capabilities []string `json:"capabilities"`
+}
+
+func KeysList(enableJson bool) {
+	// Initializations.
+	keys := config.GetPublicKeys()
+	res, _ := maps.KeysSlice(keys)
+	aliases := res.Interface().([]string)
+	sort.Strings(aliases)
+
+	// Export keys.
+	result := make(map[string]exportedKey)
+	for _, alias := range aliases {
+		result[alias] = exportKey(keys[alias], false)
+	}
+
+	// Render keys.
+	if enableJson {
+		renderJson(result)
+	} else {
+		for _, alias := range aliases {
+			fmt.Printf("- %s\n", alias)
+			renderKeyDetails(result[alias], false)
+		}
+	}
+}
+
+func KeysShow(alias string, enableJson bool) {
+	// Initializations.
+	key := findPublicKey(alias)
+	if key == nil {
+		fmt.Fprintln(os.Stderr, "Key does not exist!")
+		os.Exit(1)
+	}
+
+	// Export & render key.
+	result := exportKey(key, true)
+	if enableJson {
+		renderJson(result)
+	} else {
+		fmt.Printf("- %s\n", alias)
+		renderKeyDetails(result, true)
+	}
+}
+
+// Both PGP public keys (ASCII armored or binary) and age public keys (X25519
+// recipients or SSH Ed25519 public keys) are accepted. An alias may have one
+// key of each kind.
+func KeysAdd(alias, inputPath, folder string, force bool) {
+	// Check alias.
+	if alias == "" || strings.ContainsAny(alias, "/\\") || strings.HasPrefix(alias, ".") {
+		fmt.Fprintln(os.Stderr, "Invalid key alias!")
+		os.Exit(1)
+	}
+
+	// Read, validate & convert to ASCII armor (if needed) PGP keys.
+	key, content, err := loadPublicKey(alias, readPublicKeyInput(inputPath))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid public key: %s!\n", err)
+		os.Exit(1)
+	}
+	var fingerprint, extension, currentFile string
+	if key.Age != nil {
+		fingerprint = key.Age.GetFingerprint()
+		extension = config.AgePublicKeyExtension
+		currentFile = config.GetAgePublicKeyFile(alias)
+	} else {
+		fingerprint = key.PGP.GetFingerprint()
+		extension = config.PublicKeyExtension
+		currentFile = config.GetPublicKeyFile(alias)
+	}
+	if currentFile != "" && !force {
+		fmt.Fprintln(os.Stderr, "Key already exists! Use --force to replace it.")
+		os.Exit(1)
+	}
+
+	// Check folder (i.e. it cannot escape the public keys folder).
+	root := config.GetPublicKeysRoot()
+	file := filepath.Join(root, filepath.Clean(folder), alias+extension)
+	if relative, err := filepath.Rel(root, file); err != nil ||
+		relative == ".." || strings.HasPrefix(relative, ".."+string(filepath.Separator)) {
+		fmt.Fprintln(os.Stderr, "Invalid folder! It must be inside the 'keys/' folder.")
+		os.Exit(1)
+	}
+
+	// Store key.
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err,
+			"file":  file,
+		}).Fatal("Failed to create path to key!")
+	}
+	if err := ioutil.WriteFile(file, content, 0644); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err,
+			"file":  file,
+		}).Fatal("Failed to store key!")
+	}
+
+	// Remove replaced key if stored in a different location.
+	if currentFile != "" && currentFile != file {
+		if err := os.Remove(currentFile); err != nil {
+			logrus.WithFields(logrus.Fields{
+				"error": err,
+				"file":  currentFile,
+			}).Fatal("Failed to remove replaced key!")
+		}
+	}
+
+	// Done!
+	fmt.Printf("Done! Key '%s' (%s) stored in '%s'.\n", alias, fingerprint, file)
+	if pinned := config.GetPinnedFingerprint(alias); extension == config.PublicKeyExtension &&
+		len(config.GetPinnedFingerprints()) > 0 && pinned != fingerprint {
+		fmt.Printf("Remember to run 'pgp-tomb keys pin %s' to update its pinned fingerprint.\n", alias)
+	}
+	if currentFile != "" {
+		fmt.Println("Remember to run 'pgp-tomb rebuild --force' to re-encrypt affected secrets.")
+	}
+}
+
+// Replaces the PGP or age public key of the alias (depending on the kind of
+// the new key) & re-encrypts all secrets still encrypted with the previous
+// one. The previous key is recorded before being replaced, so running again
+// the command with the same new key resumes an interrupted rotation.
+func KeysRotate(configFile, alias, inputPath string, workers int) {
+	// Initializations.
+	key := findPublicKey(alias)
+	if key == nil {
+		fmt.Fprintln(os.Stderr, "Key does not exist!")
+		os.Exit(1)
+	}
+
+	// Read & validate new key.
+	newKey, content, err := loadPublicKey(alias, readPublicKeyInput(inputPath))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid public key: %s!\n", err)
+		os.Exit(1)
+	}
+	var aBackend, file, fingerprint, currentFingerprint string
+	if newKey.Age != nil {
+		if key.Age == nil {
+			fmt.Fprintln(os.Stderr, "Key has no age public key! Use 'pgp-tomb keys add' instead.")
+			os.Exit(1)
+		}
+		aBackend = backend.Age
+		file = config.GetAgePublicKeyFile(alias)
+		fingerprint = newKey.Age.GetFingerprint()
+		currentFingerprint = key.Age.GetFingerprint()
+	} else {
+		if key.PGP == nil {
+			fmt.Fprintln(os.Stderr, "Key has no PGP public key! Use 'pgp-tomb keys add' instead.")
+			os.Exit(1)
+		}
+		aBackend = backend.PGP
+		file = config.GetPublicKeyFile(alias)
+		fingerprint = newKey.PGP.GetFingerprint()
+		currentFingerprint = key.PGP.GetFingerprint()
+	}
+
+	// Record & replace current key, unless resuming an interrupted rotation.
+	previousKeys := config.GetPreviousKeys(alias)
+	if fingerprint != currentFingerprint {
+		previousKeys = storePreviousKey(alias, aBackend, key, previousKeys)
+		if err := ioutil.WriteFile(file, content, 0644); err != nil {
+			logrus.WithFields(logrus.Fields{
+				"error": err,
+				"file":  file,
+			}).Fatal("Failed to store key!")
+		}
+		if newKey.Age != nil {
+			key.Age = newKey.Age
+		} else {
+			key.PGP = newKey.PGP
+			if config.GetPinnedFingerprint(alias) != "" {
+				fingerprints := make(map[string]string)
+				for anAlias, aFingerprint := range config.GetPinnedFingerprints() {
+					fingerprints[anAlias] = aFingerprint
+				}
+				fingerprints[alias] = fingerprint
+				writePinnedFingerprints(configFile, fingerprints)
+				config.GetPinnedFingerprints()[alias] = fingerprint
+			}
+		}
+		fmt.Printf(
+			"- Key '%s' rotated (%s -> %s) & previous key recorded in '%s'.\n",
+			alias, currentFingerprint, fingerprint, config.GetPreviousKeysFile(alias))
+	} else if len(previousKeys) == 0 {
+		fmt.Fprintln(os.Stderr, "Key is already up to date & has no previous keys!")
+		os.Exit(1)
+	} else {
+		fmt.Printf("- Resuming rotation of key '%s' (%s).\n", alias, fingerprint)
+	}
+
+	// Collect identifiers of previous keys, ignoring those still used by some
+	// public key (e.g. when rotating back to a previous key).
+	var keyIds func(*backend.PublicKey) []string
+	if aBackend == backend.Age {
+		keyIds = (&backend.AgeBackend{}).GetKeyIds
+	} else {
+		keyIds = (&backend.PGPBackend{}).GetKeyIds
+	}
+	currentIds := make(map[string]bool)
+	for _, aKey := range config.GetPublicKeys() {
+		for _, id := range keyIds(aKey) {
+			currentIds[id] = true
+		}
+	}
+	ids := make(map[string]bool)
+	for _, previousKey := range previousKeys {
+		if previousKey.Backend == aBackend {
+			for _, id := range previousKey.KeyIds {
+				if !currentIds[id] {
+					ids[id] = true
+				}
+			}
+		}
+	}
+
+	// Look for secrets still encrypted with previous keys.
+	secrets := make([]*secret.Secret, 0)
+	if err := filepath.Walk(
+		config.GetSecretsRoot(),
+		func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() || filepath.Ext(path) != config.SecretExtension {
+				return nil
+			}
+			uri := strings.TrimPrefix(path, config.GetSecretsRoot())
+			uri = strings.TrimPrefix(uri, string(os.PathSeparator))
+			uri = strings.TrimSuffix(uri, config.SecretExtension)
+			s, err := secret.Load(uri)
+			if err != nil {
+				logrus.WithFields(logrus.Fields{
+					"error": err,
+					"uri":   uri,
+				}).Error("Failed to load secret!")
+				return nil
+			}
+			recipients, err := s.GetCurrentRecipientsKeyIds()
+			if err != nil {
+				logrus.WithFields(logrus.Fields{
+					"error": err,
+					"uri":   uri,
+				}).Error("Failed to determine current recipients!")
+				return nil
+			}
+			for _, id := range recipients {
+				if ids[id] {
+					secrets = append(secrets, s)
+					break
+				}
+			}
+			return nil
+		}); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err,
+		}).Fatal("Failed to look for secrets!")
+	}
+
+	// Re-encrypt secrets.
+	var mutex sync.Mutex
+	done, failed := 0, 0
+	failures := &decryptionFailureCounter{reasons: make(map[string]int)}
+	var waitGroup sync.WaitGroup
+	tasksChannel := make(chan func() string, 32)
+	for i := 0; i < workers; i++ {
+		waitGroup.Add(1)
+		go taskDispatcher(tasksChannel, &waitGroup)
+	}
+	for _, s := range secrets {
+		s := s
+		tasksChannel <- func() string {
+			ok, reason := reEncryptSecret(s, failures)
+
+			mutex.Lock()
+			defer mutex.Unlock()
+			done++
+			result := fmt.Sprintf("- [%d/%d] Re-encrypting '%s'...", done, len(secrets), s.GetUri())
+			if ok {
+				result += " ✓"
+			} else {
+				failed++
+				if reason != "" {
+					result += fmt.Sprintf(" ✗ (%s)", reason)
+				} else {
+					result += " ✗"
+				}
+			}
+			return result
+		}
+	}
+	close(tasksChannel)
+	waitGroup.Wait()
+
+	// Done!
+	if failed == 0 {
+		fmt.Printf("Done! %d secrets re-encrypted using the new key of '%s'.\n", len(secrets), alias)
+		return
+	}
+	fmt.Fprintf(
+		os.Stderr, "Unable to re-encrypt %d of %d secrets! Run the command again to resume the rotation.\n",
+		failed, len(secrets))
+	if total, reasons := failures.get(); total == failed && len(reasons) == 1 {
+		os.Exit(getDecryptionFailureExitCode(reasons[0]))
+	}
+	os.Exit(exitFailure)
+}
+
+// Removes all public keys (i.e. PGP & age) of the alias.
+func KeysRemove(alias string, force bool) {
+	// Initializations.
+	key := findPublicKey(alias)
+	if key == nil {
+		fmt.Fprintln(os.Stderr, "Key does not exist!")
+		os.Exit(1)
+	}
+
+	// Check references.
+	references := findKeyReferences(key)
+	if len(references) > 0 && !force {
+		fmt.Fprintln(os.Stderr, "Key is still referenced! Use --force to remove it anyway.")
+		for _, reference := range references {
+			fmt.Fprintf(os.Stderr, "  - %s\n", reference)
+		}
+		os.Exit(1)
+	}
+
+	// Remove key.
+	for _, file := range []string{config.GetPublicKeyFile(alias), config.GetAgePublicKeyFile(alias)} {
+		if file == "" {
+			continue
+		}
+		if err := os.Remove(file); err != nil {
+			logrus.WithFields(logrus.Fields{
+				"error": err,
+				"file":  file,
+			}).Fatal("Failed to remove key!")
+		}
+	}
+
+	// Done!
+	fmt.Printf("Done! Key '%s' removed.\n", alias)
+	if len(references) > 0 {
+		fmt.Println("Remember to remove references to the key in the configuration file.")
+	}
+	fmt.Println("Remember to run 'pgp-tomb rebuild' to re-encrypt affected secrets.")
+}
+
+type exportedKeyIssue struct {
+	Severe      bool   `json:"severe"`
+	Description string `json:"description"`
+}
+
+func KeysCheck(alias string, enableJson bool) {
+	// Initializations.
+	var aliases []string
+	if alias == "" {
+		res, _ := maps.KeysSlice(config.GetPublicKeys())
+		aliases = res.Interface().([]string)
+		sort.Strings(aliases)
+	} else {
+		if findPublicKey(alias) == nil {
+			fmt.Fprintln(os.Stderr, "Key does not exist!")
+			os.Exit(1)
+		}
+		aliases = []string{alias}
+	}
+
+	// Collect issues found when loading keys.
+	failed := 0
+	result := make(map[string][]exportedKeyIssue)
+	for _, alias := range aliases {
+		result[alias] = make([]exportedKeyIssue, 0)
+		severe := false
+		for _, issue := range config.GetPublicKeyIssues(alias) {
+			result[alias] = append(result[alias], exportedKeyIssue{
+				Severe:      issue.Severe,
+				Description: issue.Description,
+			})
+			severe = severe || issue.Severe
+		}
+		if severe {
+			failed++
+		}
+	}
+
+	// Render issues.
+	if enableJson {
+		renderJson(result)
+	} else {
+		for _, alias := range aliases {
+			issues := result[alias]
+			if len(issues) == 0 {
+				fmt.Printf("- %s: ok\n", alias)
+				continue
+			}
+			fmt.Printf("- %s\n", alias)
+			for i, issue := range issues {
+				level := "warning"
+				if issue.Severe {
+					level = "error"
+				}
+				if i < len(issues)-1 {
+					fmt.Printf("  |-- %s: %s\n", level, issue.Description)
+				} else {
+					fmt.Printf("  `-- %s: %s\n", level, issue.Description)
+				}
+			}
+		}
+		fmt.Printf("Done! %d keys checked, %d with severe issues.\n", len(aliases), failed)
+	}
+
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// Updates the 'fingerprints' option in the configuration file. Only the
+// 'fingerprints' block is rewritten so comments & formatting in the rest of the
+// file are preserved. If no aliases are provided all keys are pinned and
+// stale pins are dropped. Only PGP public keys can be pinned.
+func KeysPin(configFile string, aliases []string) {
+	// Initializations.
+	fingerprints := make(map[string]string)
+	if len(aliases) == 0 {
+		for alias, key := range config.GetPublicKeys() {
+			if key.PGP != nil {
+				fingerprints[alias] = key.PGP.GetFingerprint()
+			}
+		}
+	} else {
+		for alias, fingerprint := range config.GetPinnedFingerprints() {
+			fingerprints[alias] = fingerprint
+		}
+		for _, alias := range aliases {
+			key := findPublicKey(alias)
+			if key == nil || key.PGP == nil {
+				fmt.Fprintf(os.Stderr, "PGP key '%s' does not exist!\n", alias)
+				os.Exit(1)
+			}
+			fingerprints[alias] = key.PGP.GetFingerprint()
+		}
+	}
+
+	// Update configuration file.
+	writePinnedFingerprints(configFile, fingerprints)
+
+	// Done!
+	fmt.Printf("Done! %d fingerprints pinned in '%s'.\n", len(fingerprints), configFile)
+}
+
+// Replaces the 'fingerprints' block of the configuration file.
+func writePinnedFingerprints(configFile string, fingerprints map[string]string) {
+	// Render 'fingerprints' block.
+	serializedFingerprints, err := yaml.Marshal(fingerprints)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err,
+		}).Fatal("Failed to serialize fingerprints!")
+	}
+	block := []string{"fingerprints:"}
+	for _, line := range strings.Split(strings.TrimSpace(string(serializedFingerprints)), "\n") {
+		if line != "{}" {
+			block = append(block, "  "+line)
+		}
+	}
+
+	// Update configuration file.
+	content, err := ioutil.ReadFile(configFile)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err,
+			"file":  configFile,
+		}).Fatal("Failed to read configuration file!")
+	}
+	content = replaceConfigBlock(content, "fingerprints", block)
+	if err := ioutil.WriteFile(configFile, content, 0644); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err,
+			"file":  configFile,
+		}).Fatal("Failed to write configuration file!")
+	}
+}
+
+// Replaces the top level option 'name' in a YAML document (i.e. the line
+// starting with 'name:' and all following indented lines) with 'block'. If
+// the option does not exist 'block' is appended to the document.
+func replaceConfigBlock(content []byte, name string, block []string) []byte {
+	lines := strings.Split(strings.TrimRight(string(content), "\n"), "\n")
+
+	start := -1
+	for i, line := range lines {
+		if strings.HasPrefix(line, name+":") {
+			start = i
+			break
+		}
+	}
+
+	if start < 0 {
+		lines = append(append(lines, ""), block...)
+	} else {
+		end := start + 1
+		for i := start + 1; i < len(lines); i++ {
+			if strings.HasPrefix(lines[i], " ") || strings.HasPrefix(lines[i], "\t") {
+				end = i + 1
+			} else if strings.TrimSpace(lines[i]) != "" {
+				break
+			}
+		}
+		lines = append(lines[:start], append(block, lines[end:]...)...)
+	}
+
+	return []byte(strings.Join(lines, "\n") + "\n")
+}
+
+func findTeams(key *backend.PublicKey) []string {
+	result := make([]string, 0)
+	for _, team := range config.GetTeams() {
+		// Implicit or not, 'all' is not useful when describing a key.
+		if team.Alias == "all" {
+			continue
+		}
+		for _, aKey := range team.Keys {
+			if aKey == key {
+				result = append(result, team.Alias)
+				break
+			}
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+// Reads a public key from a file or, if '-', from stdin.
+func readPublicKeyInput(inputPath string) []byte {
+	var input io.Reader
+	if inputPath == "-" {
+		input = os.Stdin
+	} else {
+		file, err := os.Open(inputPath)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"error": err,
+				"file":  inputPath,
+			}).Fatal("Failed to open input file!")
+		}
+		defer file.Close()
+		input = file
+	}
+	data, err := ioutil.ReadAll(input)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err,
+			"file":  inputPath,
+		}).Fatal("Failed to read input file!")
+	}
+	return data
+}
+
+// Returns the key (either PGP or age) & the contents to be stored in the
+// public keys folder (i.e. PGP keys converted to ASCII armor, if needed).
+func loadPublicKey(alias string, data []byte) (*backend.PublicKey, []byte, error) {
+	if key, err := age.LoadPublicKey(alias, bytes.NewReader(data)); err == nil {
+		return &backend.PublicKey{Alias: alias, Age: &key}, []byte(key.String() + "\n"), nil
+	}
+
+	armored, err := pgp.ArmorPublicKey(alias, bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, err
+	}
+	key, err := pgp.LoadASCIIArmoredPublicKey(alias, bytes.NewReader(armored))
+	if err != nil {
+		return nil, nil, err
+	}
+	return &backend.PublicKey{Alias: alias, PGP: &key}, armored, nil
+}
+
+// Appends the current key of the alias for the backend to its previous keys
+// (unless already there) & stores them. Returns the updated previous keys.
+func storePreviousKey(
+	alias, aBackend string, key *backend.PublicKey,
+	previousKeys []config.PreviousKey) []config.PreviousKey {
+	// Build previous key.
+	previousKey := config.PreviousKey{
+		Alias:   alias,
+		Backend: aBackend,
+		Rotated: time.Now().UTC().Format(time.RFC3339),
+	}
+	if aBackend == backend.Age {
+		previousKey.Fingerprint = key.Age.GetFingerprint()
+		previousKey.KeyIds = (&backend.AgeBackend{}).GetKeyIds(key)
+	} else {
+		previousKey.Fingerprint = key.PGP.GetFingerprint()
+		previousKey.KeyIds = (&backend.PGPBackend{}).GetKeyIds(key)
+	}
+
+	// Append previous key.
+	result := make([]config.PreviousKey, 0, len(previousKeys)+1)
+	found := false
+	for _, aPreviousKey := range previousKeys {
+		result = append(result, aPreviousKey)
+		if aPreviousKey.Backend == aBackend && aPreviousKey.Fingerprint == previousKey.Fingerprint {
+			found = true
+		}
+	}
+	if !found {
+		result = append(result, previousKey)
+	}
+
+	// Store previous keys.
+	file := config.GetPreviousKeysFile(alias)
+	content, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err,
+		}).Fatal("Failed to serialize previous keys!")
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err,
+			"file":  file,
+		}).Fatal("Failed to create path to previous keys!")
+	}
+	if err := ioutil.WriteFile(file, append(content, '\n'), 0644); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err,
+			"file":  file,
+		}).Fatal("Failed to store previous keys!")
+	}
+
+	return result
+}
+
+func isKeeper(key *backend.PublicKey) bool {
+	for _, keeper := range config.GetKeepers() {
+		if keeper == key {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns a description of each explicit reference to a key in the
+// configuration. Teams defined using queries are not considered references.
+func findKeyReferences(key *backend.PublicKey) []string {
+	result := make([]string, 0)
+
+	if isKeeper(key) {
+		result = append(result, "keepers")
+	}
+
+	if breakglass := config.GetBreakglassKey(); breakglass != nil && breakglass.Alias == key.Alias {
+		result = append(result, "break-glass key")
+	}
+
+	teams := make([]string, 0)
+	for _, team := range config.GetTeams() {
+		for _, member := range team.Members {
+			if strings.TrimLeft(member, "+-") == key.Alias {
+				teams = append(teams, fmt.Sprintf("team '%s'", team.Alias))
+				break
+			}
+		}
+	}
+	sort.Strings(teams)
+	result = append(result, teams...)
+
+	for _, rule := range config.GetPermissionRules() {
+		for _, expression := range rule.Expressions {
+			if expression.Subject == key.Alias {
+				result = append(result, fmt.Sprintf("permissions rule '%s'", rule.Query))
+				break
+			}
+		}
+	}
+
+	return result
+}
+
+func exportKey(key *backend.PublicKey, details bool) exportedKey {
+	result := exportedKey{
+		File:        config.GetPublicKeyFile(key.Alias),
+		Fingerprint: key.GetFingerprint(),
+		UserIds:     make([]string, 0),
+		Algorithms:  make([]string, 0),
+		Teams:       findTeams(key),
+		Keeper:      isKeeper(key),
+	}
+
+	if key.PGP != nil {
+		primary := key.PGP.GetPrimaryKeyInfo()
+		subkeys := key.PGP.GetSubkeysInfo()
+
+		result.UserIds = key.PGP.GetUserIds()
+		result.Algorithms = append(result.Algorithms, primary.Algorithm)
+		result.Created = formatDate(&primary.Created)
+		if primary.Expires != nil {
+			expires := formatDate(primary.Expires)
+			result.Expires = &expires
+		}
+		for _, subkey := range subkeys {
+			result.Algorithms = append(result.Algorithms, subkey.Algorithm)
+		}
+
+		if details {
+			result.Primary = exportKeyInfo(primary)
+			result.Subkeys = make([]*exportedKeyInfo, 0)
+			for _, subkey := range subkeys {
+				result.Subkeys = append(result.Subkeys, exportKeyInfo(subkey))
+			}
+		}
+	}
+
+	if key.Age != nil {
+		result.Age = &exportedAgeKey{
+			File:        config.GetAgePublicKeyFile(key.Alias),
+			Type:        key.Age.GetType(),
+			Fingerprint: key.Age.GetFingerprint(),
+		}
+	}
+
+	if details {
+		result.References = findKeyReferences(key)
+	}
+
+	return result
+}
+
+func exportKeyInfo(info pgp.KeyInfo) *exportedKeyInfo {
+	result := &exportedKeyInfo{
+		KeyId:        info.KeyId,
+		Algorithm:    info.Algorithm,
+		Created:      formatDate(&info.Created),
+		Revoked:      info.Revoked,
+		Capabilities: make([]string, 0),
+	}
+	if info.Expires != nil {
+		expires := formatDate(info.Expires)
+		result.Expires = &expires
+	}
+	if info.CanSign {
+		result.Capabilities = append(result.Capabilities, "sign")
+	}
+	if info.CanEncrypt {
+		result.Capabilities = append(result.Capabilities, "encrypt")
+	}
+	return result
+}
+
+func formatDate(date *time.Time) string {
+	if date == nil {
+		return "never"
+	}
+	return date.UTC().Format("2006-01-02")
+}
+
+func renderJson(value interface{}) {
+	if serialized, err := json.Marshal(value); err == nil {
+		fmt.Println(string(serialized))
+	} else {
+		logrus.WithFields(logrus.Fields{
+			"error": err,
+		}).Fatal("Failed to serialize output!")
+	}
+}
+
+func renderKeyDetails(ek exportedKey, details bool) {
+	created := "-"
+	if ek.Created != "" {
+		created = ek.Created
+	}
+	expires := "never"
+	if ek.Expires != nil {
+		expires = *ek.Expires
+	} else if ek.Created == "" {
+		expires = "-"
+	}
+	age := "-"
+	if ek.Age != nil {
+		age = fmt.Sprintf("%s %s", ek.Age.Type, ek.Age.Fingerprint)
+	}
+
+	fmt.Printf("  |-- fingerprint: %s\n", ek.Fingerprint)
+	fmt.Printf("  |-- uids: %s\n", joinOrDash(ek.UserIds))
+	fmt.Printf("  |-- algorithms: %s\n", joinOrDash(ek.Algorithms))
+	fmt.Printf("  |-- created: %s\n", created)
+	fmt.Printf("  |-- expires: %s\n", expires)
+	fmt.Printf("  |-- age: %s\n", age)
+	fmt.Printf("  |-- teams: %s\n", joinOrDash(ek.Teams))
+	if !details {
+		fmt.Printf("  `-- keeper: %s\n", formatBool(ek.Keeper))
+	} else {
+		files := make([]string, 0)
+		if ek.File != "" {
+			files = append(files, ek.File)
+		}
+		if ek.Age != nil {
+			files = append(files, ek.Age.File)
+		}
+
+		fmt.Printf("  |-- keeper: %s\n", formatBool(ek.Keeper))
+		fmt.Printf("  |-- file: %s\n", joinOrDash(files))
+		if ek.Primary == nil {
+			fmt.Printf("  `-- references: %s\n", joinOrDash(ek.References))
+		} else {
+			fmt.Printf("  |-- references: %s\n", joinOrDash(ek.References))
+			fmt.Println("  |-- primary")
+			renderKeyInfo(ek.Primary, "  |   ")
+			fmt.Println("  `-- subkeys")
+			for i, subkey := range ek.Subkeys {
+				if i < len(ek.Subkeys)-1 {
+					fmt.Printf("      |-- #%d\n", i+1)
+					renderKeyInfo(subkey, "      |   ")
+				} else {
+					fmt.Printf("      `-- #%d\n", i+1)
+					renderKeyInfo(subkey, "          ")
+				}
+			}
+		}
+	}
+
+	fmt.Println()
+}
+
+func renderKeyInfo(eki *exportedKeyInfo, prefix string) {
+	expires := "never"
+	if eki.Expires != nil {
+		expires = *eki.Expires
+	}
+
+	fmt.Printf("%s|-- id: %s\n", prefix, eki.KeyId)
+	fmt.Printf("%s|-- algorithm: %s\n", prefix, eki.Algorithm)
+	fmt.Printf("%s|-- created: %s\n", prefix, eki.Created)
+	fmt.Printf("%s|-- expires: %s\n", prefix, expires)
+	fmt.Printf("%s|-- revoked: %s\n", prefix, formatBool(eki.Revoked))
+	fmt.Printf("%s`-- capabilities: %s\n", prefix, joinOrDash(eki.Capabilities))
+}
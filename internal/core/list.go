@@ -16,27 +16,19 @@ import (
 	"github.com/carlosabalde/pgp-tomb/internal/core/config"
 	"github.com/carlosabalde/pgp-tomb/internal/core/query"
 	"github.com/carlosabalde/pgp-tomb/internal/core/secret"
+	"github.com/carlosabalde/pgp-tomb/internal/helpers/backend"
 	"github.com/carlosabalde/pgp-tomb/internal/helpers/maps"
 	"github.com/carlosabalde/pgp-tomb/internal/helpers/pgp"
 )
 
 func List(
-	folderOrUri string, long bool, queryString, keyAlias string,
-	ignoreSchema bool, enableJson bool) {
+	folderOrUri string, long bool, queryString string, keyAliases []string,
+	ignoreSchema bool, verify bool, enableJson bool) {
 	// Initializations.
 	queryParsed := parseQuery(queryString)
 
-	// Initialize key.
-	var key *pgp.PublicKey
-	if keyAlias != "" {
-		key = findPublicKey(keyAlias)
-		if key == nil {
-			fmt.Fprintln(os.Stderr, "Key does not exist!")
-			os.Exit(1)
-		}
-	} else {
-		key = config.GetIdentity()
-	}
+	// Initialize keys.
+	keys := findRecipientKeys(keyAliases)
 
 	// Define walk function.
 	listed := 0
@@ -45,7 +37,7 @@ func List(
 			return err
 		}
 		if !info.IsDir() && filepath.Ext(path) == config.SecretExtension {
-			if listSecret(path, long, queryParsed, key, ignoreSchema, enableJson, listed == 0) {
+			if listSecret(path, long, queryParsed, keys, ignoreSchema, verify, enableJson, listed == 0) {
 				listed++
 			}
 		}
@@ -90,8 +82,8 @@ func List(
 }
 
 func listSecret(
-	path string, long bool, q query.Query, key *pgp.PublicKey,
-	ignoreSchema bool, enableJson bool, isFirst bool) bool {
+	path string, long bool, q query.Query, keys []*backend.PublicKey,
+	ignoreSchema bool, verify bool, enableJson bool, isFirst bool) bool {
 	uri := strings.TrimPrefix(path, config.GetSecretsRoot())
 	uri = strings.TrimPrefix(uri, string(os.PathSeparator))
 	uri = strings.TrimSuffix(uri, config.SecretExtension)
@@ -109,8 +101,8 @@ func listSecret(
 		return false
 	}
 
-	if key != nil {
-		if readable, err := s.IsReadableBy(key); err == nil {
+	if len(keys) > 0 {
+		if readable, err := s.IsReadableBy(keys...); err == nil {
 			if !readable {
 				return false
 			}
@@ -128,7 +120,7 @@ func listSecret(
 	}
 
 	if enableJson || long {
-		if es, err := exportSecret(s, ignoreSchema); err == nil {
+		if es, err := exportSecret(s, ignoreSchema, verify); err == nil {
 			if !enableJson {
 				renderSecretDetails(es)
 			} else {
@@ -166,13 +158,22 @@ func listSecret(
 }
 
 type exportedSecret struct {
+	Backend    exportedBackend    `json:"backend"`
 	Recipients exportedRecipients `json:"recipients"`
 	Template   *exportedTemplate  `json:"template"`
+	Signature  exportedSignature  `json:"signature"`
+	Algorithms exportedAlgorithms `json:"algorithms"`
 	Tags       exportedTags       `json:"tags"`
 }
 
+type exportedBackend struct {
+	Current  string `json:"current"`
+	Expected string `json:"expected"`
+}
+
 type exportedRecipients struct {
 	Expected []string `json:"expected"`
+	Teams    []string `json:"teams"`
 	Unknown  []string `json:"unknown"`
 	Rubbish  []string `json:"rubbish"`
 	Missing  []string `json:"missing"`
@@ -183,40 +184,75 @@ type exportedTemplate struct {
 	State string `json:"state"`
 }
 
+type exportedSignature struct {
+	State  string  `json:"state"`
+	Signer *string `json:"signer"`
+	KeyId  *string `json:"id"`
+}
+
+type exportedAlgorithms struct {
+	State       string   `json:"state"`
+	Cipher      *string  `json:"cipher"`
+	AEAD        *string  `json:"aead"`
+	Compression *string  `json:"compression"`
+	Hash        *string  `json:"hash"`
+	Weak        []string `json:"weak"`
+}
+
 type exportedTags struct {
-	State string            `json:"state"`
-	Tags  map[string]string `json:"tags"`
+	State  string            `json:"state"`
+	Digest string            `json:"digest"`
+	Tags   map[string]string `json:"tags"`
+	// Nil if private tags could not be decrypted.
+	Private map[string]string `json:"private"`
 }
 
-func exportSecret(s *secret.Secret, ignoreSchema bool) (exportedSecret, error) {
+// Secrets are only decrypted when needed to validate their templates or when
+// 'verify' is enabled, i.e. to check signatures, tags digests & algorithms.
+func exportSecret(s *secret.Secret, ignoreSchema bool, verify bool) (exportedSecret, error) {
 	// Initializations.
 	result := exportedSecret{}
 
+	// Export backend.
+	result.Backend.Current = s.GetBackend()
+	result.Backend.Expected = s.GetExpectedBackend()
+
 	// Export recipients.
 	expected, unknown, rubbish, missing, err := s.GetRecipients()
 	if err != nil {
 		return exportedSecret{}, errors.Wrap(err, "Failed to determine recipients!")
 	}
 	result.Recipients.Expected = expected
+	result.Recipients.Teams = s.GetExpectedTeams()
 	result.Recipients.Unknown = unknown
 	result.Recipients.Rubbish = rubbish
 	result.Recipients.Missing = missing
 
+	// Decrypt secret (required to check template & signature). Failing to
+	// decrypt is only an error if a template needs to be checked.
+	template := s.GetTemplate()
+	checkTemplate := !ignoreSchema && template != nil && template.Schema != nil
+	var buffer *bytes.Buffer
+	var verification *backend.Verification
+	if checkTemplate || verify {
+		buffer = new(bytes.Buffer)
+		if aVerification, err := s.DecryptAndVerify(buffer); err == nil {
+			verification = &aVerification
+		} else if checkTemplate {
+			return exportedSecret{}, errors.Wrap(err, "Failed to decrypt secret!")
+		}
+	}
+
 	// Export template.
-	if template := s.GetTemplate(); template != nil && template.Schema != nil {
+	if template != nil && template.Schema != nil {
 		result.Template = &exportedTemplate{
 			Alias: template.Alias,
 		}
 		if !ignoreSchema {
-			buffer := new(bytes.Buffer)
-			if err := s.Decrypt(buffer); err != nil {
-				return exportedSecret{}, errors.Wrap(err, "Failed to decrypt secret!")
+			if valid, _ := validateSchema(buffer.String(), template.Schema); valid {
+				result.Template.State = "valid"
 			} else {
-				if valid, _ := validateSchema(buffer.String(), template.Schema); valid {
-					result.Template.State = "valid"
-				} else {
-					result.Template.State = "invalid"
-				}
+				result.Template.State = "invalid"
 			}
 		} else {
 			result.Template.State = "unknown"
@@ -225,9 +261,54 @@ func exportSecret(s *secret.Secret, ignoreSchema bool) (exportedSecret, error) {
 		result.Template = nil
 	}
 
+	// Export signature.
+	if verification != nil {
+		result.Signature.State = verification.State
+		if verification.KeyId != "" {
+			result.Signature.KeyId = &verification.KeyId
+		}
+		if verification.Signer != nil {
+			result.Signature.Signer = &verification.Signer.Alias
+		}
+	} else {
+		result.Signature.State = "unknown"
+	}
+
+	// Export algorithms.
+	if verification != nil {
+		if verification.Cipher != "" {
+			result.Algorithms.Cipher = &verification.Cipher
+		}
+		if verification.AEAD != "" {
+			result.Algorithms.AEAD = &verification.AEAD
+		}
+		if verification.Compression != "" {
+			result.Algorithms.Compression = &verification.Compression
+		}
+		if verification.Hash != "" {
+			result.Algorithms.Hash = &verification.Hash
+		}
+		result.Algorithms.Weak = s.CheckAlgorithms(*verification)
+		if len(result.Algorithms.Weak) > 0 {
+			result.Algorithms.State = "weak"
+		} else {
+			result.Algorithms.State = "valid"
+		}
+	} else {
+		result.Algorithms.State = "unknown"
+	}
+
 	// Export tags.
 	result.Tags = exportedTags{
-		Tags: make(map[string]string),
+		Tags:   make(map[string]string),
+		Digest: "unknown",
+	}
+	if verification != nil {
+		if state, err := s.CheckTags(*verification); err == nil {
+			result.Tags.Digest = state
+		} else {
+			return exportedSecret{}, errors.Wrap(err, "Failed to check tags digest!")
+		}
 	}
 	if !ignoreSchema {
 		if serializedTags, err := s.GetSerializedTags(); err != nil {
@@ -242,8 +323,19 @@ func exportSecret(s *secret.Secret, ignoreSchema bool) (exportedSecret, error) {
 	} else {
 		result.Tags.State = "unknown"
 	}
+	// Private tags are only exported when loaded (i.e. decrypted). Tags
+	// stored in clear before being configured as private are skipped too.
+	if s.HasPrivateTagsLoaded() {
+		result.Tags.Private = make(map[string]string)
+	}
 	for _, tag := range s.GetTags() {
-		result.Tags.Tags[tag.Name] = tag.Value
+		if config.IsPrivateTag(tag.Name) {
+			if result.Tags.Private != nil {
+				result.Tags.Private[tag.Name] = tag.Value
+			}
+		} else {
+			result.Tags.Tags[tag.Name] = tag.Value
+		}
 	}
 
 	// Done!
@@ -251,6 +343,14 @@ func exportSecret(s *secret.Secret, ignoreSchema bool) (exportedSecret, error) {
 }
 
 func renderSecretDetails(es exportedSecret) {
+	// Render backend.
+	fmt.Print("  |-- backend: ")
+	if es.Backend.Current == es.Backend.Expected {
+		fmt.Printf("%s ✓\n", es.Backend.Current)
+	} else {
+		fmt.Printf("%s (expected: %s) ✗\n", es.Backend.Current, es.Backend.Expected)
+	}
+
 	// Render expected recipients.
 	fmt.Print("  |-- recipients: ")
 	if len(es.Recipients.Expected) > 0 {
@@ -259,6 +359,14 @@ func renderSecretDetails(es exportedSecret) {
 		fmt.Println("-")
 	}
 
+	// Render teams granting access.
+	fmt.Print("  |   |-- teams: ")
+	if len(es.Recipients.Teams) > 0 {
+		fmt.Println(strings.Join(es.Recipients.Teams, ", "))
+	} else {
+		fmt.Println("-")
+	}
+
 	// Render unknown recipients.
 	fmt.Print("  |   |-- unknown: ")
 	if len(es.Recipients.Unknown) > 0 {
@@ -300,6 +408,38 @@ func renderSecretDetails(es exportedSecret) {
 		fmt.Println("-")
 	}
 
+	// Render signature.
+	fmt.Print("  |-- signature: ")
+	switch es.Signature.State {
+	case pgp.SignatureValid:
+		fmt.Printf("%s ✓\n", *es.Signature.Signer)
+	case pgp.SignatureInvalid, pgp.SignatureUnknown:
+		fmt.Printf("%s (%s) ✗\n", es.Signature.State, *es.Signature.KeyId)
+	case pgp.SignatureNone:
+		fmt.Println("-")
+	default:
+		fmt.Println("?")
+	}
+
+	// Render algorithms.
+	fmt.Print("  |-- algorithms: ")
+	switch es.Algorithms.State {
+	case "valid", "weak":
+		fmt.Printf(
+			"cipher: %s, aead: %s, hash: %s, compression: %s",
+			stringOrDash(es.Algorithms.Cipher),
+			stringOrDash(es.Algorithms.AEAD),
+			stringOrDash(es.Algorithms.Hash),
+			stringOrDash(es.Algorithms.Compression))
+		if es.Algorithms.State == "weak" {
+			fmt.Printf(" (weak: %s) ✗\n", strings.Join(es.Algorithms.Weak, ", "))
+		} else {
+			fmt.Println(" ✓")
+		}
+	default:
+		fmt.Println("?")
+	}
+
 	// Render tags.
 	var decoration string
 	switch es.Tags.State {
@@ -310,18 +450,42 @@ func renderSecretDetails(es exportedSecret) {
 	case "unknown":
 		decoration = "?"
 	}
+	if es.Tags.Digest == secret.TagsTampered {
+		decoration += " (tampered ✗)"
+	}
 	fmt.Printf("  `-- tags %s\n", decoration)
+	lines := make([]string, 0)
 	res, _ := maps.KeysSlice(es.Tags.Tags)
 	tagsNames := res.Interface().([]string)
 	sort.Strings(tagsNames)
-	for i, tagsName := range tagsNames {
+	for _, tagsName := range tagsNames {
+		lines = append(lines, fmt.Sprintf("%s: %s", tagsName, es.Tags.Tags[tagsName]))
+	}
+	if es.Tags.Private != nil {
+		res, _ := maps.KeysSlice(es.Tags.Private)
+		tagsNames := res.Interface().([]string)
+		sort.Strings(tagsNames)
+		for _, tagsName := range tagsNames {
+			lines = append(lines, fmt.Sprintf("%s: %s (private)", tagsName, es.Tags.Private[tagsName]))
+		}
+	} else {
+		lines = append(lines, "(encrypted private tags)")
+	}
+	for i, line := range lines {
 		decoration := "|"
-		if i == len(tagsNames)-1 {
+		if i == len(lines)-1 {
 			decoration = "`"
 		}
-		fmt.Printf("      %s-- %s: %s\n", decoration, tagsName, es.Tags.Tags[tagsName])
+		fmt.Printf("      %s-- %s\n", decoration, line)
 	}
 
 	// Done!
 	fmt.Println()
 }
+
+func stringOrDash(value *string) string {
+	if value == nil {
+		return "-"
+	}
+	return *value
+}
@@ -0,0 +1,66 @@
+package core
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/carlosabalde/pgp-tomb/internal/core/secret"
+)
+
+func Migrate(folderOrUri string, dryRun bool) {
+	// Initializations.
+	checked := 0
+	migrated := 0
+	failed := 0
+
+	// Walk secrets.
+	walkSecrets(folderOrUri, func(uri string) {
+		s, err := secret.Load(uri)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"error": err,
+				"uri":   uri,
+			}).Error("Failed to load secret!")
+			failed++
+			return
+		}
+		checked++
+
+		if s.GetVersion() == secret.LatestVersion {
+			return
+		}
+
+		message := fmt.Sprintf(
+			"- Migrating '%s': version %d -> %d...",
+			uri, s.GetVersion(), secret.LatestVersion)
+		if !dryRun {
+			if err := s.Migrate(); err != nil {
+				logrus.WithFields(logrus.Fields{
+					"error": err,
+					"uri":   uri,
+				}).Error("Failed to migrate secret!")
+				message += " ✗"
+				failed++
+			} else {
+				message += " ✓"
+				migrated++
+			}
+		} else {
+			message += " ✓"
+			migrated++
+		}
+		fmt.Println(message)
+	})
+
+	// Done!
+	if dryRun {
+		fmt.Printf("Done! %d files checked, %d migrated, %d failed (dry run).\n", checked, migrated, failed)
+	} else {
+		fmt.Printf("Done! %d files checked, %d migrated, %d failed.\n", checked, migrated, failed)
+	}
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
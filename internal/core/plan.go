@@ -0,0 +1,189 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/carlosabalde/pgp-tomb/internal/core/config"
+	"github.com/carlosabalde/pgp-tomb/internal/core/secret"
+	"github.com/carlosabalde/pgp-tomb/internal/helpers/maps"
+	"github.com/carlosabalde/pgp-tomb/internal/helpers/slices"
+)
+
+type exportedPlan struct {
+	Secrets map[string]*exportedSecretPlan `json:"secrets"`
+	Users   map[string]*exportedUserPlan   `json:"users"`
+}
+
+type exportedSecretPlan struct {
+	Before  []string `json:"before"`
+	After   []string `json:"after"`
+	Added   []string `json:"added"`
+	Removed []string `json:"removed"`
+}
+
+type exportedUserPlan struct {
+	Added   []string `json:"added"`
+	Removed []string `json:"removed"`
+}
+
+func Plan(file, folderOrUri, queryString string, enableJson bool) {
+	// Initializations.
+	queryParsed := parseQuery(queryString)
+	secrets := make([]*secret.Secret, 0)
+	before := make(map[string][]string)
+
+	// Determine expected recipients using the current configuration.
+	walkSecrets(folderOrUri, func(uri string) {
+		s, err := secret.Load(uri)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"error": err,
+				"uri":   uri,
+			}).Error("Failed to load secret!")
+			return
+		}
+
+		if !queryParsed.Eval(s) {
+			return
+		}
+
+		aliases, err := getExpectedAliases(s)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"error": err,
+				"uri":   uri,
+			}).Error("Failed to determine expected recipients!")
+			return
+		}
+
+		secrets = append(secrets, s)
+		before[uri] = aliases
+	})
+
+	// Replace current configuration. Secrets are not reloaded: the file system
+	// is the same and neither URIs nor tags depend on configuration.
+	config.Reload(file)
+
+	// Determine expected recipients using the new configuration & compare.
+	plan := exportedPlan{
+		Secrets: make(map[string]*exportedSecretPlan),
+		Users:   make(map[string]*exportedUserPlan),
+	}
+	for _, s := range secrets {
+		after, err := getExpectedAliases(s)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"error": err,
+				"uri":   s.GetUri(),
+			}).Error("Failed to determine expected recipients!")
+			continue
+		}
+
+		tmp, _ := slices.Difference(after, before[s.GetUri()])
+		added := tmp.Interface().([]string)
+		sort.Strings(added)
+
+		tmp, _ = slices.Difference(before[s.GetUri()], after)
+		removed := tmp.Interface().([]string)
+		sort.Strings(removed)
+
+		if len(added) > 0 || len(removed) > 0 {
+			plan.Secrets[s.GetUri()] = &exportedSecretPlan{
+				Before:  before[s.GetUri()],
+				After:   after,
+				Added:   added,
+				Removed: removed,
+			}
+			for _, alias := range added {
+				getUserPlan(plan, alias).Added = append(
+					getUserPlan(plan, alias).Added, s.GetUri())
+			}
+			for _, alias := range removed {
+				getUserPlan(plan, alias).Removed = append(
+					getUserPlan(plan, alias).Removed, s.GetUri())
+			}
+		}
+	}
+
+	// Render plan.
+	if enableJson {
+		if serializedPlan, err := json.Marshal(plan); err == nil {
+			fmt.Println(string(serializedPlan))
+		} else {
+			logrus.WithFields(logrus.Fields{
+				"error": err,
+			}).Fatal("Failed to serialize plan!")
+		}
+	} else {
+		renderPlan(secrets, plan)
+	}
+}
+
+func getExpectedAliases(s *secret.Secret) ([]string, error) {
+	keys, err := s.GetExpectedPublicKeys()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to determine expected recipients")
+	}
+
+	result := make([]string, 0)
+	for _, key := range keys {
+		result = append(result, key.Alias)
+	}
+	sort.Strings(result)
+
+	return result, nil
+}
+
+func getUserPlan(plan exportedPlan, alias string) *exportedUserPlan {
+	if _, found := plan.Users[alias]; !found {
+		plan.Users[alias] = &exportedUserPlan{
+			Added:   make([]string, 0),
+			Removed: make([]string, 0),
+		}
+	}
+	return plan.Users[alias]
+}
+
+func renderPlan(secrets []*secret.Secret, plan exportedPlan) {
+	// Render secrets (walk order).
+	fmt.Println("Secrets:")
+	for _, s := range secrets {
+		if item, found := plan.Secrets[s.GetUri()]; found {
+			fmt.Printf("- %s\n", s.GetUri())
+			fmt.Printf("  |-- added: %s\n", joinOrDash(item.Added))
+			fmt.Printf("  `-- removed: %s\n", joinOrDash(item.Removed))
+		}
+	}
+	fmt.Println()
+
+	// Render users (alphabetical order).
+	fmt.Println("Users:")
+	res, _ := maps.KeysSlice(plan.Users)
+	aliases := res.Interface().([]string)
+	sort.Strings(aliases)
+	for _, alias := range aliases {
+		item := plan.Users[alias]
+		fmt.Printf("- %s\n", alias)
+		fmt.Printf("  |-- added: %s\n", joinOrDash(item.Added))
+		fmt.Printf("  `-- removed: %s\n", joinOrDash(item.Removed))
+	}
+	fmt.Println()
+
+	// Done!
+	fmt.Printf(
+		"Done! %d secrets checked, %d affected.\n",
+		len(secrets), len(plan.Secrets))
+}
+
+func joinOrDash(items []string) string {
+	if len(items) > 0 {
+		return strings.Join(items, ", ")
+	}
+	return "-"
+}
@@ -97,7 +97,8 @@ func (self *parser) parseFactor() error {
 
 	case T_IDENTIFIER:
 		if self.currentToken.Value != "uri" &&
-			!strings.HasPrefix(self.currentToken.Value, "tags.") {
+			!strings.HasPrefix(self.currentToken.Value, "tags.") &&
+			!strings.HasPrefix(self.currentToken.Value, "key.") {
 			return self.formatError("invalid identifier '%s'", self.currentToken.Value)
 		}
 		self.currentNode = newTree()
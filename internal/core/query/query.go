@@ -36,6 +36,44 @@ func Parse(query string) (Query, error) {
 	return visit(tree)
 }
 
+// Returns all identifiers referenced in a query, no matter if they would be
+// evaluated or not.
+func GetIdentifiers(query Query) []string {
+	switch node := query.(type) {
+	case *logicalAnd:
+		return getIdentifiers(node.items)
+	case *logicalOr:
+		return getIdentifiers(node.items)
+	case *logicalNot:
+		return GetIdentifiers(node.item)
+	case *stringEquality:
+		return []string{node.identifier}
+	case *stringMatch:
+		return []string{node.identifier}
+	default:
+		return []string{}
+	}
+}
+
+// Checks all identifiers referenced in a query are accepted by 'valid', given
+// that unknown identifiers would silently evaluate as empty strings.
+func CheckIdentifiers(query Query, valid func(string) bool) error {
+	for _, identifier := range GetIdentifiers(query) {
+		if !valid(identifier) {
+			return errors.Errorf("unknown identifier '%s'", identifier)
+		}
+	}
+	return nil
+}
+
+func getIdentifiers(items []Query) []string {
+	result := make([]string, 0)
+	for _, item := range items {
+		result = append(result, GetIdentifiers(item)...)
+	}
+	return result
+}
+
 func visit(tree parser.Tree) (Query, error) {
 	token := tree.Value()
 
@@ -8,10 +8,11 @@ import (
 
 func TestParse(t *testing.T) {
 	context1 := Map{
-		"uri":      "foo/bar/baz.txt",
-		"tags.foo": "42",
-		"tags.bar": "3.14",
-		"tags.baz": "",
+		"uri":       "foo/bar/baz.txt",
+		"tags.foo":  "42",
+		"tags.bar":  "3.14",
+		"tags.baz":  "",
+		"key.email": "bob@example.com",
 	}
 
 	for _, s := range []string{
@@ -21,6 +22,7 @@ func TestParse(t *testing.T) {
 		`uri !~ "^xxx"`,
 		`uri == "foo/bar/baz.txt" && tags.foo == '42' && tags.bar != "42"`,
 		`tags.foo ~ '^xxx' || tags.bar ~ "14$"`,
+		`key.email ~ '@example\.com$'`,
 	} {
 		query, err := Parse(s)
 		if assert.NoError(t, err) {
@@ -28,3 +30,37 @@ func TestParse(t *testing.T) {
 		}
 	}
 }
+
+func TestCheckIdentifiers(t *testing.T) {
+	valid := func(identifier string) bool {
+		return identifier == "uri"
+	}
+
+	query, err := Parse(`uri == "foo" || !(uri ~ "bar")`)
+	if assert.NoError(t, err) {
+		assert.NoError(t, CheckIdentifiers(query, valid))
+	}
+
+	query, err = Parse(`uri == "foo" && key.alias == "bar"`)
+	if assert.NoError(t, err) {
+		assert.EqualError(t, CheckIdentifiers(query, valid), "unknown identifier 'key.alias'")
+	}
+}
+
+func TestGetIdentifiers(t *testing.T) {
+	tests := []struct {
+		query       string
+		identifiers []string
+	}{
+		{`true`, []string{}},
+		{`uri == "foo"`, []string{"uri"}},
+		{`uri ~ "^foo/" || !(tags.foo == '42' && tags.bar !~ "x")`, []string{"uri", "tags.foo", "tags.bar"}},
+	}
+
+	for _, test := range tests {
+		query, err := Parse(test.query)
+		if assert.NoError(t, err) {
+			assert.Equal(t, test.identifiers, GetIdentifiers(query))
+		}
+	}
+}
@@ -2,65 +2,151 @@ package core
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 
 	"github.com/sirupsen/logrus"
 
 	"github.com/carlosabalde/pgp-tomb/internal/core/config"
 	"github.com/carlosabalde/pgp-tomb/internal/core/query"
 	"github.com/carlosabalde/pgp-tomb/internal/core/secret"
-	"github.com/carlosabalde/pgp-tomb/internal/helpers/pgp"
+	"github.com/carlosabalde/pgp-tomb/internal/helpers/backend"
+	"github.com/carlosabalde/pgp-tomb/internal/helpers/progress"
 )
 
-func Rebuild(folderOrUri, queryString, keyAlias string, workers int, force, dryRun bool) {
-	// Initializations.
-	checked := 0
-	queryParsed := parseQuery(queryString)
+// Reasons to re-encrypt a secret (or to remove a file), in the order they are
+// reported. Tampered tags & failures are reported as reasons too.
+const (
+	rebuildReasonBackend    = "backend"
+	rebuildReasonUnknown    = "unknown"
+	rebuildReasonRubbish    = "rubbish"
+	rebuildReasonMissing    = "missing"
+	rebuildReasonWeak       = "weak"
+	rebuildReasonForced     = "forced"
+	rebuildReasonUnexpected = "unexpected"
+	rebuildReasonTampered   = "tampered"
+	rebuildReasonFailed     = "failed"
+)
 
-	// Initialize key.
-	var key *pgp.PublicKey
-	if keyAlias != "" {
-		key = findPublicKey(keyAlias)
-		if key == nil {
-			fmt.Fprintln(os.Stderr, "Key does not exist!")
-			os.Exit(1)
+var rebuildReasons = []string{
+	rebuildReasonBackend, rebuildReasonUnknown, rebuildReasonRubbish,
+	rebuildReasonMissing, rebuildReasonWeak, rebuildReasonForced,
+	rebuildReasonUnexpected, rebuildReasonTampered, rebuildReasonFailed,
+}
+
+const (
+	rebuildStatusUnchanged   = "unchanged"
+	rebuildStatusReEncrypted = "re-encrypted"
+	rebuildStatusRemoved     = "removed"
+	rebuildStatusTampered    = "tampered"
+	rebuildStatusFailed      = "failed"
+)
+
+// Outcome of checking a secret (or an unexpected file). 'Failure' is the
+// reason of the decryption failure, if any.
+type rebuildResult struct {
+	Uri     string   `json:"uri,omitempty"`
+	File    string   `json:"file,omitempty"`
+	Status  string   `json:"status"`
+	Reason  string   `json:"reason,omitempty"`
+	Details []string `json:"details,omitempty"`
+	Failure string   `json:"failure,omitempty"`
+	message string
+}
+
+// Only results other than unchanged secrets are included.
+type rebuildSummary struct {
+	DryRun      bool            `json:"dry-run"`
+	Checked     int             `json:"checked"`
+	ReEncrypted int             `json:"re-encrypted"`
+	Removed     int             `json:"removed"`
+	Failed      int             `json:"failed"`
+	Skipped     int             `json:"skipped"`
+	Reasons     map[string]int  `json:"reasons"`
+	Results     []rebuildResult `json:"results"`
+}
+
+func (self *rebuildSummary) add(result rebuildResult) {
+	self.Checked++
+	if result.Reason != "" {
+		self.Reasons[result.Reason]++
+	}
+	switch result.Status {
+	case rebuildStatusReEncrypted:
+		self.ReEncrypted++
+	case rebuildStatusRemoved:
+		self.Removed++
+	case rebuildStatusTampered:
+		self.Failed++
+		self.Reasons[rebuildReasonTampered]++
+	case rebuildStatusFailed:
+		self.Failed++
+		self.Reasons[rebuildReasonFailed]++
+	}
+	if result.Status != rebuildStatusUnchanged {
+		self.Results = append(self.Results, result)
+	}
+}
+
+func (self *rebuildSummary) render() {
+	reasons := make([]string, 0)
+	for _, reason := range rebuildReasons {
+		if count := self.Reasons[reason]; count > 0 {
+			reasons = append(reasons, fmt.Sprintf("%s (%d)", reason, count))
 		}
-	} else {
-		key = config.GetIdentity()
 	}
 
-	// Launch workers.
-	var waitGroup sync.WaitGroup
-	tasksChannel := make(chan func() string, 32)
-	for i := 0; i < workers; i++ {
-		waitGroup.Add(1)
-		go taskDispatcher(tasksChannel, &waitGroup)
+	if self.DryRun {
+		fmt.Printf("Done! %d files checked (dry run).\n", self.Checked)
+	} else {
+		fmt.Printf("Done! %d files checked.\n", self.Checked)
 	}
+	fmt.Printf("  |-- re-encrypted: %d\n", self.ReEncrypted)
+	fmt.Printf("  |-- removed: %d\n", self.Removed)
+	fmt.Printf("  |-- failed: %d\n", self.Failed)
+	fmt.Printf("  |-- skipped: %d\n", self.Skipped)
+	fmt.Printf("  `-- reasons: %s\n", joinOrDash(reasons))
+}
 
-	// Define walk function.
-	walk := func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-		if !info.IsDir() {
-			if checkFile(path, info, tasksChannel, queryParsed, key, force, dryRun) {
-				checked++
-			}
-		}
-		return nil
+func Rebuild(
+	folderOrUri, queryString string, keyAliases []string, workers int,
+	force, checkTags, checkAlgorithms, dryRun, resume, enableJson bool) {
+	// Initializations.
+	queryParsed := parseQuery(queryString)
+	failures := &decryptionFailureCounter{reasons: make(map[string]int)}
+	summary := &rebuildSummary{
+		DryRun:  dryRun,
+		Reasons: make(map[string]int),
+		Results: make([]rebuildResult, 0),
 	}
 
-	// Check folder vs. URI & walk file system.
+	// Initialize keys.
+	keys := findRecipientKeys(keyAliases)
+
+	// Initialize checkpoint.
+	checkpoint := openRebuildCheckpoint(rebuildOptions{
+		FolderOrUri:     folderOrUri,
+		Query:           queryString,
+		Recipients:      keyAliases,
+		Force:           force,
+		CheckTags:       checkTags,
+		CheckAlgorithms: checkAlgorithms,
+	}, resume, dryRun)
+
+	// Check folder vs. URI.
 	root := ""
 	if folderOrUri != "" {
 		item := path.Join(config.GetSecretsRoot(), folderOrUri+config.SecretExtension)
 		if info, err := os.Stat(item); err == nil && !info.IsDir() {
-			walk(item, info, err)
+			root = item
 		} else {
 			root = path.Join(config.GetSecretsRoot(), folderOrUri)
 			if info, err := os.Stat(root); os.IsNotExist(err) || !info.IsDir() {
@@ -71,73 +157,329 @@ func Rebuild(folderOrUri, queryString, keyAlias string, workers int, force, dryR
 	} else {
 		root = config.GetSecretsRoot()
 	}
-	if root != "" {
-		if err := filepath.Walk(root, walk); err != nil {
+
+	// Count files, so progress can be reported while files are checked as
+	// soon as they are found.
+	total := 0
+	if err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			total++
+		}
+		return err
+	}); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err,
+		}).Fatal("Failed to rebuild secrets!")
+	}
+
+	// Walk file system feeding workers. Files not requiring a task (e.g.
+	// secrets not matching the query) are only counted.
+	var skipped, ignored int64
+	tasksChannel := make(chan func() rebuildResult, 32)
+	go func() {
+		defer close(tasksChannel)
+		if err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.IsDir() {
+				if task, wasSkipped := checkFile(
+					path, queryParsed, keys, checkpoint, failures,
+					force, checkTags, checkAlgorithms, dryRun); task != nil {
+					tasksChannel <- task
+				} else if wasSkipped {
+					atomic.AddInt64(&skipped, 1)
+				} else {
+					atomic.AddInt64(&ignored, 1)
+				}
+			}
+			return nil
+		}); err != nil {
 			logrus.WithFields(logrus.Fields{
 				"error": err,
 			}).Fatal("Failed to rebuild secrets!")
 		}
+	}()
+
+	// Launch workers.
+	var waitGroup sync.WaitGroup
+	resultsChannel := make(chan rebuildResult, 32)
+	for i := 0; i < workers; i++ {
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+			for task := range tasksChannel {
+				resultsChannel <- task()
+			}
+		}()
 	}
+	go func() {
+		waitGroup.Wait()
+		close(resultsChannel)
+	}()
 
-	// Wait for completion.
-	close(tasksChannel)
-	waitGroup.Wait()
+	// Collect results. Messages are rendered here, so lines of different
+	// workers are never mixed with the progress bar. Secrets with tampered
+	// tags or failing are not checkpointed, so resuming retries them.
+	bar := progress.New(os.Stderr, total)
+	for result := range resultsChannel {
+		summary.add(result)
+		if result.Uri != "" && result.Status != rebuildStatusFailed &&
+			result.Status != rebuildStatusTampered {
+			checkpoint.add(result.Uri)
+		}
+		if !enableJson {
+			if result.message != "" {
+				bar.Clear()
+				fmt.Println(result.message)
+			}
+			done := summary.Checked + int(atomic.LoadInt64(&skipped)+atomic.LoadInt64(&ignored))
+			bar.Update(done, fmt.Sprintf(
+				"%d re-encrypted, %d failed", summary.ReEncrypted, summary.Failed))
+		}
+	}
+	summary.Skipped = int(skipped)
+	bar.Clear()
+	checkpoint.close(summary.Failed > 0)
 
 	// Done!
-	if dryRun {
-		fmt.Printf("Done! %d files checked (dry run).\n", checked)
+	if enableJson {
+		renderJson(summary)
 	} else {
-		fmt.Printf("Done! %d files checked.\n", checked)
+		summary.render()
+	}
+
+	// Exit with the code matching the reason of decryption failures, if all
+	// failures are decryption failures sharing the same reason.
+	if summary.Failed > 0 {
+		total, reasons := failures.get()
+		if total > 0 {
+			fmt.Fprintf(
+				os.Stderr, "Unable to decrypt %d secrets (%s)!\n",
+				total, strings.Join(reasons, ", "))
+		}
+		if !dryRun {
+			fmt.Fprintln(os.Stderr, "Run again using --resume to retry failed secrets.")
+		}
+		if total == summary.Failed && len(reasons) == 1 {
+			os.Exit(getDecryptionFailureExitCode(reasons[0]))
+		}
+		os.Exit(exitFailure)
+	}
+}
+
+// Options of a rebuild. Resuming a rebuild using different options is refused.
+type rebuildOptions struct {
+	FolderOrUri     string   `json:"folder-or-uri"`
+	Query           string   `json:"query"`
+	Recipients      []string `json:"recipients"`
+	Force           bool     `json:"force"`
+	CheckTags       bool     `json:"check-tags"`
+	CheckAlgorithms bool     `json:"check-algorithms"`
+}
+
+// Checkpoint file of a rebuild: a line with the options of the rebuild
+// followed by one line per handled secret URI (all of them JSON encoded).
+// Lines are written as soon as secrets are handled, so an interrupted rebuild
+// can be resumed. The file is removed when the rebuild completes without
+// failures, and it is never written in dry run mode.
+type rebuildCheckpoint struct {
+	file    *os.File
+	handled map[string]bool
+}
+
+func openRebuildCheckpoint(options rebuildOptions, resume, dryRun bool) *rebuildCheckpoint {
+	// Initializations.
+	result := &rebuildCheckpoint{handled: make(map[string]bool)}
+	name := config.GetRebuildCheckpointFile()
+	serializedOptions, err := json.Marshal(options)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err,
+		}).Fatal("Failed to serialize rebuild options!")
+	}
+
+	// Load handled secrets.
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if resume {
+		content, err := ioutil.ReadFile(name)
+		if os.IsNotExist(err) {
+			fmt.Fprintln(os.Stderr, "Nothing to resume! No checkpoint of a previous rebuild found.")
+			os.Exit(1)
+		} else if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"error": err,
+				"file":  name,
+			}).Fatal("Failed to read rebuild checkpoint!")
+		}
+		lines := strings.Split(strings.TrimRight(string(content), "\n"), "\n")
+		if lines[0] != string(serializedOptions) {
+			fmt.Fprintln(os.Stderr, "Checkpoint belongs to a rebuild using different options!")
+			fmt.Fprintf(os.Stderr, "  `-- options: %s\n", lines[0])
+			os.Exit(1)
+		}
+		for _, line := range lines[1:] {
+			var uri string
+			if err := json.Unmarshal([]byte(line), &uri); err != nil {
+				// Last line may be truncated if the rebuild was killed.
+				logrus.WithFields(logrus.Fields{
+					"error": err,
+					"file":  name,
+				}).Warn("Ignoring invalid line in rebuild checkpoint!")
+				continue
+			}
+			result.handled[uri] = true
+		}
+		flags = os.O_WRONLY | os.O_APPEND
+	} else if _, err := os.Stat(name); err == nil && !dryRun {
+		logrus.WithFields(logrus.Fields{
+			"file": name,
+		}).Warn("Discarding checkpoint of a previous rebuild! Use --resume to resume it.")
+	}
+
+	// Open checkpoint.
+	if !dryRun {
+		file, err := os.OpenFile(name, flags, 0600)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"error": err,
+				"file":  name,
+			}).Fatal("Failed to open rebuild checkpoint!")
+		}
+		result.file = file
+		if !resume {
+			result.write(string(serializedOptions))
+		}
+	}
+
+	// Done!
+	return result
+}
+
+func (self *rebuildCheckpoint) isHandled(uri string) bool {
+	return self.handled[uri]
+}
+
+func (self *rebuildCheckpoint) add(uri string) {
+	if serializedUri, err := json.Marshal(uri); err == nil {
+		self.write(string(serializedUri))
+	}
+}
+
+func (self *rebuildCheckpoint) write(line string) {
+	if self.file != nil {
+		if _, err := fmt.Fprintln(self.file, line); err != nil {
+			logrus.WithFields(logrus.Fields{
+				"error": err,
+				"file":  self.file.Name(),
+			}).Error("Failed to write rebuild checkpoint! Resuming will not be possible.")
+			self.file.Close()
+			self.file = nil
+		}
+	}
+}
+
+// Keeps the checkpoint only if it is still needed (e.g. to retry failures).
+func (self *rebuildCheckpoint) close(keep bool) {
+	if self.file != nil {
+		self.file.Close()
+		if !keep {
+			if err := os.Remove(self.file.Name()); err != nil {
+				logrus.WithFields(logrus.Fields{
+					"error": err,
+					"file":  self.file.Name(),
+				}).Error("Failed to remove rebuild checkpoint!")
+			}
+		}
+	}
+}
+
+// Counts failures to decrypt secrets by reason. Shared by all workers.
+type decryptionFailureCounter struct {
+	mutex   sync.Mutex
+	total   int
+	reasons map[string]int
+}
+
+// Returns the reason of the failure. Other errors (e.g. rejected signatures)
+// are ignored.
+func (self *decryptionFailureCounter) add(err error) string {
+	if _, ok := err.(*secret.DecryptionFailed); !ok {
+		return ""
+	}
+
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	reason := getDecryptionFailureReason(err)
+	self.total++
+	self.reasons[reason]++
+	return reason
+}
+
+// Returns the number of failures & their sorted reasons.
+func (self *decryptionFailureCounter) get() (int, []string) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	reasons := make([]string, 0, len(self.reasons))
+	for reason := range self.reasons {
+		reasons = append(reasons, reason)
 	}
+	sort.Strings(reasons)
+	return self.total, reasons
 }
 
+// Returns the task checking the file, if any, and if the file was skipped
+// because it was already handled by a resumed rebuild.
 func checkFile(
-	path string, info os.FileInfo, tasksChannel chan func() string,
-	q query.Query, key *pgp.PublicKey, force, dryRun bool) bool {
+	path string, q query.Query, keys []*backend.PublicKey,
+	checkpoint *rebuildCheckpoint, failures *decryptionFailureCounter,
+	force, checkTags, checkAlgorithms, dryRun bool) (func() rebuildResult, bool) {
 	if filepath.Ext(path) == config.SecretExtension {
 		uri := strings.TrimPrefix(path, config.GetSecretsRoot())
 		uri = strings.TrimPrefix(uri, string(os.PathSeparator))
 		uri = strings.TrimSuffix(uri, config.SecretExtension)
 
+		if checkpoint.isHandled(uri) {
+			return nil, true
+		}
+
 		s, err := secret.Load(uri)
 		if err != nil {
 			logrus.WithFields(logrus.Fields{
 				"error": err,
 				"uri":   uri,
 			}).Error("Failed to load secret!")
-			return false
+			return nil, false
 		}
 
 		if !q.Eval(s) {
-			return false
+			return nil, false
 		}
 
-		if key != nil {
-			if readable, err := s.IsReadableBy(key); err == nil {
+		if len(keys) > 0 {
+			if readable, err := s.IsReadableBy(keys...); err == nil {
 				if !readable {
-					return false
+					return nil, false
 				}
 			} else {
 				logrus.WithFields(logrus.Fields{
 					"error": err,
 					"uri":   s.GetUri(),
 				}).Error("Failed to check if secret is readable!")
-				return false
+				return nil, false
 			}
 		}
 
-		tasksChannel <- func() string {
-			return checkSecret(s, force, dryRun)
-		}
-		return true
-	} else {
-		tasksChannel <- func() string {
-			return checkUnexpectFile(path, dryRun)
-		}
-		return true
+		return func() rebuildResult {
+			return checkSecret(s, failures, force, checkTags, checkAlgorithms, dryRun)
+		}, false
 	}
 
-	return false
+	return func() rebuildResult {
+		return checkUnexpectFile(path, dryRun)
+	}, false
 }
 
 func taskDispatcher(tasksChannel <-chan func() string, waitGroup *sync.WaitGroup) {
@@ -150,7 +492,57 @@ func taskDispatcher(tasksChannel <-chan func() string, waitGroup *sync.WaitGroup
 	}
 }
 
-func checkSecret(s *secret.Secret, force, dryRun bool) string {
+func checkSecret(
+	s *secret.Secret, failures *decryptionFailureCounter,
+	force, checkTags, checkAlgorithms, dryRun bool) rebuildResult {
+	// Initializations.
+	result := rebuildResult{
+		Uri:    s.GetUri(),
+		Status: rebuildStatusUnchanged,
+	}
+	fail := func(message string) rebuildResult {
+		result.Status = rebuildStatusFailed
+		result.message = message
+		return result
+	}
+
+	// Decrypt secret if tags or algorithms need to be checked.
+	var verification backend.Verification
+	if checkTags || checkAlgorithms {
+		var err error
+		verification, err = s.DecryptAndVerify(ioutil.Discard)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"error": err,
+				"uri":   s.GetUri(),
+			}).Error("Failed to decrypt file for checks!")
+			suffix := ""
+			if reason := failures.add(err); reason != "" {
+				result.Failure = reason
+				suffix = fmt.Sprintf(" (%s)", reason)
+			}
+			if checkTags {
+				return fail(fmt.Sprintf("! Failed to check tags for '%s'%s", s.GetUri(), suffix))
+			}
+			return fail(fmt.Sprintf("! Failed to check algorithms for '%s'%s", s.GetUri(), suffix))
+		}
+	}
+
+	// Check tags?
+	if checkTags {
+		if state, err := s.CheckTags(verification); err != nil {
+			logrus.WithFields(logrus.Fields{
+				"error": err,
+				"uri":   s.GetUri(),
+			}).Error("Failed to check tags!")
+			return fail(fmt.Sprintf("! Failed to check tags for '%s'", s.GetUri()))
+		} else if state == secret.TagsTampered {
+			result.Status = rebuildStatusTampered
+			result.message = fmt.Sprintf("! Tags of '%s' do not match encrypted tags digest", s.GetUri())
+			return result
+		}
+	}
+
 	// Determine recipients.
 	_, unknown, rubbish, missing, err := s.GetRecipients()
 	if err != nil {
@@ -158,37 +550,60 @@ func checkSecret(s *secret.Secret, force, dryRun bool) string {
 			"error": err,
 			"uri":   s.GetUri(),
 		}).Error("Failed to determine recipients!")
-		return fmt.Sprintf("! Failed to determine recipients for '%s'", s.GetUri())
+		return fail(fmt.Sprintf("! Failed to determine recipients for '%s'", s.GetUri()))
 	}
 
-	// Check recipients.
-	result := ""
-	if len(unknown) > 0 {
-		result = fmt.Sprintf(
-			"- Re-encrypting '%s': unknown recipients (%s)...",
-			s.GetUri(), strings.Join(unknown, ", "))
+	// Check algorithms?
+	var weak []string
+	if checkAlgorithms {
+		weak = s.CheckAlgorithms(verification)
+	}
+
+	// Check backend & recipients.
+	description := ""
+	if current, expected := s.GetBackend(), s.GetExpectedBackend(); current != expected {
+		result.Reason = rebuildReasonBackend
+		result.Details = []string{current, expected}
+		description = fmt.Sprintf("backend mismatch (%s -> %s)", current, expected)
+	} else if len(unknown) > 0 {
+		result.Reason = rebuildReasonUnknown
+		result.Details = unknown
+		description = fmt.Sprintf("unknown recipients (%s)", strings.Join(unknown, ", "))
 	} else if len(rubbish) > 0 {
-		result = fmt.Sprintf(
-			"- Re-encrypting '%s': rubbish recipients (%s)...",
-			s.GetUri(), strings.Join(rubbish, ", "))
+		result.Reason = rebuildReasonRubbish
+		result.Details = rubbish
+		description = fmt.Sprintf("rubbish recipients (%s)", strings.Join(rubbish, ", "))
 	} else if len(missing) > 0 {
-		result = fmt.Sprintf(
-			"- Re-encrypting '%s': missing recipients (%s)...",
-			s.GetUri(), strings.Join(missing, ", "))
+		result.Reason = rebuildReasonMissing
+		result.Details = missing
+		description = fmt.Sprintf("missing recipients (%s)", strings.Join(missing, ", "))
+	} else if len(weak) > 0 {
+		result.Reason = rebuildReasonWeak
+		result.Details = weak
+		description = fmt.Sprintf("weak algorithms (%s)", strings.Join(weak, ", "))
 	} else if force {
-		result = fmt.Sprintf("- Re-encrypting '%s': forced...", s.GetUri())
+		result.Reason = rebuildReasonForced
+		description = "forced"
 	}
 
 	// Re-encrypt?
-	if result != "" {
+	if result.Reason != "" {
+		result.message = fmt.Sprintf("- Re-encrypting '%s': %s...", s.GetUri(), description)
 		if !dryRun {
-			if reEncryptSecret(s) {
-				result += " ✓"
+			if ok, reason := reEncryptSecret(s, failures); ok {
+				result.Status = rebuildStatusReEncrypted
+				result.message += " ✓"
+			} else if reason != "" {
+				result.Status = rebuildStatusFailed
+				result.Failure = reason
+				result.message += fmt.Sprintf(" ✗ (%s)", reason)
 			} else {
-				result += " ✗"
+				result.Status = rebuildStatusFailed
+				result.message += " ✗"
 			}
 		} else {
-			result += " ✓"
+			result.Status = rebuildStatusReEncrypted
+			result.message += " ✓"
 		}
 	}
 
@@ -196,17 +611,24 @@ func checkSecret(s *secret.Secret, force, dryRun bool) string {
 	return result
 }
 
-func reEncryptSecret(s *secret.Secret) bool {
+// Returns the reason of the failure if the secret cannot be decrypted.
+func reEncryptSecret(s *secret.Secret, failures *decryptionFailureCounter) (bool, string) {
 	// Initialize output buffer.
 	buffer := new(bytes.Buffer)
 
 	// Decrypt secret.
 	if err := s.Decrypt(buffer); err != nil {
+		if _, ok := err.(*secret.TagsMismatch); ok {
+			logrus.WithFields(logrus.Fields{
+				"uri": s.GetUri(),
+			}).Error("Secret tags do not match encrypted tags digest! Refusing to re-encrypt it.")
+			return false, ""
+		}
 		logrus.WithFields(logrus.Fields{
 			"error": err,
 			"uri":   s.GetUri(),
-		}).Error("Failed to decrypt file for re-encryption! Are you allowed to access it?")
-		return false
+		}).Error("Failed to decrypt file for re-encryption!")
+		return false, failures.add(err)
 	}
 
 	// Encrypt secret.
@@ -215,15 +637,20 @@ func reEncryptSecret(s *secret.Secret) bool {
 			"error": err,
 			"uri":   s.GetUri(),
 		}).Error("Failed to re-encrypt file!")
-		return false
+		return false, ""
 	}
 
 	// Done!
-	return true
+	return true, ""
 }
 
-func checkUnexpectFile(path string, dryRun bool) string {
-	result := fmt.Sprintf("- Removing unexpected file '%s'...", path)
+func checkUnexpectFile(path string, dryRun bool) rebuildResult {
+	result := rebuildResult{
+		File:    path,
+		Status:  rebuildStatusRemoved,
+		Reason:  rebuildReasonUnexpected,
+		message: fmt.Sprintf("- Removing unexpected file '%s'...", path),
+	}
 
 	if !dryRun {
 		if err := os.Remove(path); err != nil {
@@ -231,12 +658,13 @@ func checkUnexpectFile(path string, dryRun bool) string {
 				"error": err,
 				"file":  path,
 			}).Error("Failed to remove unexpected file!")
-			result += " ✗"
+			result.Status = rebuildStatusFailed
+			result.message += " ✗"
 		} else {
-			result += " ✓"
+			result.message += " ✓"
 		}
 	} else {
-		result += " ✓"
+		result.message += " ✓"
 	}
 
 	return result
@@ -0,0 +1,251 @@
+package core
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"html"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/carlosabalde/pgp-tomb/internal/core/config"
+	"github.com/carlosabalde/pgp-tomb/internal/core/secret"
+	"github.com/carlosabalde/pgp-tomb/internal/helpers/maps"
+)
+
+var ReportFormats = []string{"csv", "json", "markdown", "html"}
+
+type exportedAccessReport struct {
+	Keys    map[string]*exportedAccessReportKey    `json:"keys"`
+	Secrets map[string]*exportedAccessReportSecret `json:"secrets"`
+}
+
+type exportedAccessReportKey struct {
+	Teams    []string `json:"teams"`
+	Keeper   bool     `json:"keeper"`
+	Expected int      `json:"expected"`
+	Current  int      `json:"current"`
+	Missing  int      `json:"missing"`
+	Rubbish  int      `json:"rubbish"`
+}
+
+type exportedAccessReportSecret struct {
+	UpToDate bool              `json:"up-to-date"`
+	Unknown  []string          `json:"unknown"`
+	Access   map[string]string `json:"access"`
+}
+
+func ReportAccess(folderOrUri, queryString, format string) {
+	// Initializations.
+	queryParsed := parseQuery(queryString)
+	uris := make([]string, 0)
+	report := exportedAccessReport{
+		Keys:    make(map[string]*exportedAccessReportKey),
+		Secrets: make(map[string]*exportedAccessReportSecret),
+	}
+
+	// Describe keys.
+	for alias, key := range config.GetPublicKeys() {
+		report.Keys[alias] = &exportedAccessReportKey{
+			Teams:  findTeams(key),
+			Keeper: isKeeper(key),
+		}
+	}
+
+	// Describe secrets.
+	walkSecrets(folderOrUri, func(uri string) {
+		s, err := secret.Load(uri)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"error": err,
+				"uri":   uri,
+			}).Error("Failed to load secret!")
+			return
+		}
+
+		if !queryParsed.Eval(s) {
+			return
+		}
+
+		expected, unknown, rubbish, missing, err := s.GetRecipients()
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"error": err,
+				"uri":   uri,
+			}).Error("Failed to determine recipients!")
+			return
+		}
+
+		item := &exportedAccessReportSecret{
+			UpToDate: len(unknown) == 0 && len(rubbish) == 0 && len(missing) == 0 &&
+				s.GetBackend() == s.GetExpectedBackend(),
+			Unknown: unknown,
+			Access:  make(map[string]string),
+		}
+		for _, alias := range expected {
+			item.Access[alias] = "yes"
+			report.Keys[alias].Expected++
+			report.Keys[alias].Current++
+		}
+		for _, alias := range missing {
+			item.Access[alias] = "missing"
+			report.Keys[alias].Current--
+			report.Keys[alias].Missing++
+		}
+		for _, alias := range rubbish {
+			item.Access[alias] = "rubbish"
+			report.Keys[alias].Current++
+			report.Keys[alias].Rubbish++
+		}
+
+		uris = append(uris, uri)
+		report.Secrets[uri] = item
+	})
+
+	// Render report.
+	if format == "json" {
+		if serializedReport, err := json.Marshal(report); err == nil {
+			fmt.Println(string(serializedReport))
+		} else {
+			logrus.WithFields(logrus.Fields{
+				"error": err,
+			}).Fatal("Failed to serialize report!")
+		}
+	} else {
+		tables := buildAccessReportTables(uris, report)
+		switch format {
+		case "csv":
+			renderCSVTables(tables)
+		case "markdown":
+			renderMarkdownTables(tables)
+		case "html":
+			renderHTMLTables("PGP Tomb access report", tables)
+		}
+	}
+}
+
+type reportTable struct {
+	Title  string
+	Header []string
+	Rows   [][]string
+}
+
+func buildAccessReportTables(uris []string, report exportedAccessReport) []reportTable {
+	res, _ := maps.KeysSlice(report.Keys)
+	aliases := res.Interface().([]string)
+	sort.Strings(aliases)
+
+	matrix := reportTable{
+		Title:  "Access",
+		Header: append([]string{"secret", "up-to-date", "unknown"}, aliases...),
+		Rows:   make([][]string, 0),
+	}
+	for _, uri := range uris {
+		item := report.Secrets[uri]
+		row := []string{uri, formatBool(item.UpToDate), strings.Join(item.Unknown, " ")}
+		for _, alias := range aliases {
+			row = append(row, item.Access[alias])
+		}
+		matrix.Rows = append(matrix.Rows, row)
+	}
+
+	totals := reportTable{
+		Title: "Keys",
+		Header: []string{
+			"key", "teams", "keeper", "expected", "current", "missing", "rubbish"},
+		Rows: make([][]string, 0),
+	}
+	for _, alias := range aliases {
+		item := report.Keys[alias]
+		totals.Rows = append(totals.Rows, []string{
+			alias,
+			strings.Join(item.Teams, " "),
+			formatBool(item.Keeper),
+			strconv.Itoa(item.Expected),
+			strconv.Itoa(item.Current),
+			strconv.Itoa(item.Missing),
+			strconv.Itoa(item.Rubbish),
+		})
+	}
+
+	return []reportTable{matrix, totals}
+}
+
+func formatBool(value bool) string {
+	if value {
+		return "yes"
+	}
+	return "no"
+}
+
+func renderCSVTables(tables []reportTable) {
+	writer := csv.NewWriter(os.Stdout)
+	for i, table := range tables {
+		if i > 0 {
+			writer.Write([]string{})
+		}
+		writer.Write(table.Header)
+		writer.WriteAll(table.Rows)
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err,
+		}).Fatal("Failed to render CSV!")
+	}
+}
+
+func renderMarkdownTables(tables []reportTable) {
+	escape := func(value string) string {
+		return strings.ReplaceAll(value, "|", "\\|")
+	}
+
+	for i, table := range tables {
+		if i > 0 {
+			fmt.Println()
+		}
+		fmt.Printf("## %s\n\n", table.Title)
+		separators := make([]string, len(table.Header))
+		for j := range separators {
+			separators[j] = "---"
+		}
+		for _, row := range append([][]string{table.Header, separators}, table.Rows...) {
+			cells := make([]string, len(row))
+			for j, cell := range row {
+				cells[j] = escape(cell)
+			}
+			fmt.Printf("| %s |\n", strings.Join(cells, " | "))
+		}
+	}
+}
+
+func renderHTMLTables(title string, tables []reportTable) {
+	fmt.Println("<!DOCTYPE html>")
+	fmt.Println("<html>")
+	fmt.Printf("<head><meta charset=\"utf-8\"><title>%s</title></head>\n", html.EscapeString(title))
+	fmt.Println("<body>")
+	fmt.Printf("<h1>%s</h1>\n", html.EscapeString(title))
+	for _, table := range tables {
+		fmt.Printf("<h2>%s</h2>\n", html.EscapeString(table.Title))
+		fmt.Println("<table border=\"1\">")
+		fmt.Print("<tr>")
+		for _, cell := range table.Header {
+			fmt.Printf("<th>%s</th>", html.EscapeString(cell))
+		}
+		fmt.Println("</tr>")
+		for _, row := range table.Rows {
+			fmt.Print("<tr>")
+			for _, cell := range row {
+				fmt.Printf("<td>%s</td>", html.EscapeString(cell))
+			}
+			fmt.Println("</tr>")
+		}
+		fmt.Println("</table>")
+	}
+	fmt.Println("</body>")
+	fmt.Println("</html>")
+}
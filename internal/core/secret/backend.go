@@ -0,0 +1,69 @@
+package secret
+
+import (
+	"github.com/carlosabalde/pgp-tomb/internal/core/config"
+	"github.com/carlosabalde/pgp-tomb/internal/helpers/backend"
+	"github.com/carlosabalde/pgp-tomb/internal/helpers/pgp"
+)
+
+// Backend used to encrypt the secret. Secrets not written yet use the expected
+// backend.
+func (self *Secret) GetBackend() string {
+	if self.backend == "" {
+		return self.GetExpectedBackend()
+	}
+	return self.backend
+}
+
+// Backend according with the first matching backend rule, falling back to the
+// tomb-wide backend.
+func (self *Secret) GetExpectedBackend() string {
+	for _, rule := range config.GetBackendRules() {
+		if rule.Query.Eval(self) {
+			return rule.Backend
+		}
+	}
+
+	return config.GetBackend()
+}
+
+// When signing using the 'key' option, the private key must be already
+// unlocked.
+func (self *Secret) newBackend(name string, sign bool) backend.Backend {
+	if name == backend.Age {
+		return &backend.AgeBackend{
+			PrivateKey: config.GetAgePrivateKey(),
+			Prompt:     config.GetPassphrasePrompt(),
+		}
+	}
+
+	keys := make([]*pgp.PublicKey, 0)
+	for _, key := range config.GetPublicKeys() {
+		if key.PGP != nil {
+			keys = append(keys, key.PGP)
+		}
+	}
+
+	compression := self.GetCompression()
+	result := &backend.PGPBackend{
+		GPG:         config.GetGPG(),
+		Prompt:      config.GetPassphrasePrompt(),
+		PrivateKeys: config.GetPrivateKeys(),
+		Agent:       config.GetAgent(),
+		Keys:        keys,
+		Sign:        sign,
+		KeyFile:     config.GetPublicKeyFile,
+		Settings: pgp.Settings{
+			Cipher:           config.GetEncryption().Cipher,
+			Hash:             config.GetEncryption().Hash,
+			AEAD:             config.GetEncryption().AEAD,
+			Compression:      compression.Packet,
+			CompressionLevel: compression.PacketLevel,
+		},
+	}
+	if identity := config.GetIdentity(); identity != nil && identity.PGP != nil {
+		result.SignerFingerprint = identity.PGP.GetFingerprint()
+	}
+
+	return result
+}
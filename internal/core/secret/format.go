@@ -0,0 +1,164 @@
+package secret
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+
+	"github.com/carlosabalde/pgp-tomb/internal/core/config"
+)
+
+// Supported versions of the '.secret' file format:
+//   - Version 1: gzip stream of the encrypted payload, with tags stored as JSON
+//     in the 'Extra' field of the gzip header (i.e. limited to 65535 bytes).
+//   - Version 2: container including a magic string, the format version, the
+//     length of the metadata block (uint32, big endian), the metadata block
+//     (JSON) and, finally, the encrypted payload, compressed as specified in
+//     the metadata block (gzip if not specified).
+const (
+	Version1      = 1
+	Version2      = 2
+	LatestVersion = Version2
+)
+
+const magic = "PGP-TOMB"
+
+// Max. size of the 'Extra' field of the gzip header.
+const maxVersion1HeaderSize = 65535
+
+// Max. size of the metadata block, so corrupted or malicious lengths don't
+// trigger huge allocations.
+const maxMetadataSize = 16 * 1024 * 1024
+
+type metadata struct {
+	Generator   string            `json:"generator"`
+	Tags        map[string]string `json:"tags"`
+	PrivateTags []byte            `json:"private-tags,omitempty"`
+	Compression string            `json:"compression,omitempty"`
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+func newDecompressor(input io.Reader, compression string) (io.ReadCloser, error) {
+	switch compression {
+	case "", config.CompressionGzip:
+		return gzip.NewReader(input)
+	case config.CompressionZstd:
+		decoder, err := zstd.NewReader(input)
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	case config.CompressionNone:
+		return ioutil.NopCloser(input), nil
+	}
+	return nil, errors.Errorf("unsupported compression '%s'", compression)
+}
+
+func newCompressor(output io.Writer, compression string, level int) (io.WriteCloser, error) {
+	switch compression {
+	case config.CompressionGzip:
+		if level == config.DefaultCompressionLevel {
+			level = gzip.DefaultCompression
+		}
+		return gzip.NewWriterLevel(output, level)
+	case config.CompressionZstd:
+		if level == config.DefaultCompressionLevel {
+			return zstd.NewWriter(output)
+		}
+		return zstd.NewWriter(output, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+	case config.CompressionNone:
+		return nopWriteCloser{output}, nil
+	}
+	return nil, errors.Errorf("unsupported compression '%s'", compression)
+}
+
+// Detects the format version without consuming any input.
+func detectVersion(input *bufio.Reader) (int, error) {
+	prefix, err := input.Peek(len(magic) + 1)
+	if err != nil && err != io.EOF {
+		return 0, err
+	}
+
+	if bytes.HasPrefix(prefix, []byte(magic)) {
+		if len(prefix) <= len(magic) {
+			return 0, errors.New("truncated container header")
+		}
+		version := int(prefix[len(magic)])
+		if version < Version2 || version > LatestVersion {
+			return 0, errors.Errorf("unsupported format version %d", version)
+		}
+		return version, nil
+	}
+
+	return Version1, nil
+}
+
+func readContainerHeader(input io.Reader) (*metadata, error) {
+	prefix := make([]byte, len(magic)+1)
+	if _, err := io.ReadFull(input, prefix); err != nil {
+		return nil, errors.Wrap(err, "failed to read container header")
+	}
+
+	var length uint32
+	if err := binary.Read(input, binary.BigEndian, &length); err != nil {
+		return nil, errors.Wrap(err, "failed to read metadata length")
+	}
+
+	if length > maxMetadataSize {
+		return nil, errors.Errorf("metadata length %d exceeds limit of %d bytes", length, maxMetadataSize)
+	}
+
+	data := make([]byte, length)
+	if _, err := io.ReadFull(input, data); err != nil {
+		return nil, errors.Wrap(err, "failed to read metadata")
+	}
+
+	result := &metadata{}
+	if err := json.Unmarshal(data, result); err != nil {
+		return nil, errors.Wrap(err, "failed to unserialize metadata")
+	}
+
+	return result, nil
+}
+
+func writeContainerHeader(output io.Writer, version int, value *metadata) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return errors.Wrap(err, "failed to serialize metadata")
+	}
+	if len(data) > maxMetadataSize {
+		return errors.Errorf("metadata length %d exceeds limit of %d bytes", len(data), maxMetadataSize)
+	}
+
+	if _, err := io.WriteString(output, magic); err != nil {
+		return err
+	}
+
+	if _, err := output.Write([]byte{byte(version)}); err != nil {
+		return err
+	}
+
+	if err := binary.Write(output, binary.BigEndian, uint32(len(data))); err != nil {
+		return err
+	}
+
+	if _, err := output.Write(data); err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,91 @@
+package secret
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestContainerHeader(t *testing.T) {
+	header := &metadata{
+		Generator:   "Generated by PGP Tomb",
+		Tags:        map[string]string{"type": "ACME"},
+		PrivateTags: []byte{0x01, 0x02, 0x03},
+	}
+
+	var buffer bytes.Buffer
+	if err := writeContainerHeader(&buffer, Version2, header); err != nil {
+		t.Fatal(err)
+	}
+	buffer.WriteString("payload")
+
+	input := bufio.NewReader(&buffer)
+	version, err := detectVersion(input)
+	assert.NoError(t, err)
+	assert.Equal(t, Version2, version)
+
+	result, err := readContainerHeader(input)
+	assert.NoError(t, err)
+	assert.Equal(t, header, result)
+
+	payload, _ := input.ReadString(0)
+	assert.Equal(t, "payload", payload)
+}
+
+func TestContainerHeaderLimit(t *testing.T) {
+	var buffer bytes.Buffer
+	buffer.WriteString(magic)
+	buffer.WriteByte(Version2)
+	buffer.Write([]byte{0xff, 0xff, 0xff, 0xff})
+
+	input := bufio.NewReader(&buffer)
+	if _, err := detectVersion(input); err != nil {
+		t.Fatal(err)
+	}
+	_, err := readContainerHeader(input)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "exceeds limit")
+	}
+}
+
+func TestDetectVersion(t *testing.T) {
+	tests := []struct {
+		input   string
+		version int
+		fails   bool
+	}{
+		{"\x1f\x8b\x08\x00", Version1, false},
+		{"", Version1, false},
+		{"PGP-TOMB\x02", Version2, false},
+		{"PGP-TOMB\x09", 0, true},
+		{"PGP-TOMB", 0, true},
+	}
+
+	for _, test := range tests {
+		version, err := detectVersion(bufio.NewReader(bytes.NewReader([]byte(test.input))))
+		if test.fails {
+			assert.Error(t, err, test.input)
+		} else {
+			assert.NoError(t, err, test.input)
+			assert.Equal(t, test.version, version, test.input)
+		}
+	}
+}
+
+func TestVersion1Header(t *testing.T) {
+	header := &metadata{
+		Tags:        map[string]string{"type": "ACME"},
+		PrivateTags: []byte{0x01, 0x02, 0x03},
+	}
+
+	data, err := serializeVersion1Header(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := unserializeVersion1Header(data)
+	assert.NoError(t, err)
+	assert.Equal(t, header, result)
+}
@@ -0,0 +1,56 @@
+package secret
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/carlosabalde/pgp-tomb/internal/core/config"
+)
+
+const paddingPrefix = "pgp-tomb-padding:"
+
+// Separator of fields in the file name of the literal data packet.
+const fileNameSeparator = ";"
+
+// Returns the number of bytes to be appended to a plaintext of 'size' bytes.
+func getPaddingLength(padding config.Padding, size int) int {
+	switch padding.Scheme {
+	case config.PaddingPowerOfTwo:
+		bucket := 1
+		for bucket < size {
+			bucket <<= 1
+		}
+		return bucket - size
+	case config.PaddingBlock:
+		if padding.BlockSize <= 0 {
+			return 0
+		}
+		if size == 0 {
+			return padding.BlockSize
+		}
+		return (padding.BlockSize - size%padding.BlockSize) % padding.BlockSize
+	}
+	return 0
+}
+
+// The file name of the literal data packet includes the tags digest and,
+// optionally, the length of the padding.
+func buildFileName(digest string, padding int) string {
+	if padding == 0 {
+		return digest
+	}
+	return digest + fileNameSeparator + paddingPrefix + strconv.Itoa(padding)
+}
+
+func parseFileName(name string) (digest string, padding int) {
+	for _, field := range strings.Split(name, fileNameSeparator) {
+		if strings.HasPrefix(field, paddingPrefix) {
+			if value, err := strconv.Atoi(field[len(paddingPrefix):]); err == nil && value > 0 {
+				padding = value
+			}
+		} else if digest == "" {
+			digest = field
+		}
+	}
+	return
+}
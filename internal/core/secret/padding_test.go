@@ -0,0 +1,52 @@
+package secret
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/carlosabalde/pgp-tomb/internal/core/config"
+)
+
+func TestGetPaddingLength(t *testing.T) {
+	tests := []struct {
+		padding config.Padding
+		size    int
+		length  int
+	}{
+		{config.Padding{Scheme: config.PaddingNone}, 10, 0},
+		{config.Padding{Scheme: config.PaddingPowerOfTwo}, 0, 1},
+		{config.Padding{Scheme: config.PaddingPowerOfTwo}, 1, 0},
+		{config.Padding{Scheme: config.PaddingPowerOfTwo}, 5, 3},
+		{config.Padding{Scheme: config.PaddingPowerOfTwo}, 1024, 0},
+		{config.Padding{Scheme: config.PaddingPowerOfTwo}, 1025, 1023},
+		{config.Padding{Scheme: config.PaddingBlock, BlockSize: 100}, 0, 100},
+		{config.Padding{Scheme: config.PaddingBlock, BlockSize: 100}, 1, 99},
+		{config.Padding{Scheme: config.PaddingBlock, BlockSize: 100}, 100, 0},
+		{config.Padding{Scheme: config.PaddingBlock, BlockSize: 100}, 250, 50},
+	}
+
+	for _, test := range tests {
+		assert.Equal(
+			t, test.length, getPaddingLength(test.padding, test.size),
+			"%s (%d) / %d", test.padding.Scheme, test.padding.BlockSize, test.size)
+	}
+}
+
+func TestFileName(t *testing.T) {
+	digest := tagsDigestPrefix + "0123456789abcdef"
+
+	name := buildFileName(digest, 0)
+	assert.Equal(t, digest, name)
+	aDigest, padding := parseFileName(name)
+	assert.Equal(t, digest, aDigest)
+	assert.Equal(t, 0, padding)
+
+	aDigest, padding = parseFileName(buildFileName(digest, 42))
+	assert.Equal(t, digest, aDigest)
+	assert.Equal(t, 42, padding)
+
+	aDigest, padding = parseFileName("bar")
+	assert.Equal(t, "bar", aDigest)
+	assert.Equal(t, 0, padding)
+}
@@ -0,0 +1,49 @@
+package secret
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Unlike the file name of the literal data packet, the plaintext is covered by
+// signatures. Therefore metadata binding the secret (i.e. the tags digest) and
+// needed to read it (i.e. the length of the padding) is stored in a
+// fixed-length header prepended to the plaintext, which is stripped when
+// decrypting the secret.
+const payloadMagic = "\x00PGPTOMB1"
+
+const payloadHeaderSize = len(payloadMagic) + sha256.Size + 8
+
+func buildPayloadHeader(digest string, padding int) ([]byte, error) {
+	if !strings.HasPrefix(digest, tagsDigestPrefix) {
+		return nil, errors.New("invalid tags digest")
+	}
+	value, err := hex.DecodeString(digest[len(tagsDigestPrefix):])
+	if err != nil || len(value) != sha256.Size {
+		return nil, errors.New("invalid tags digest")
+	}
+
+	result := make([]byte, 0, payloadHeaderSize)
+	result = append(result, payloadMagic...)
+	result = append(result, value...)
+	var length [8]byte
+	binary.BigEndian.PutUint64(length[:], uint64(padding))
+	return append(result, length[:]...), nil
+}
+
+// Returns the size of the header (i.e. 0 if the plaintext has no header, as
+// in secrets written by older versions), the tags digest and the length of
+// the padding.
+func parsePayloadHeader(plaintext []byte) (size int, digest string, padding uint64) {
+	if len(plaintext) < payloadHeaderSize || !bytes.HasPrefix(plaintext, []byte(payloadMagic)) {
+		return 0, "", 0
+	}
+	value := plaintext[len(payloadMagic) : len(payloadMagic)+sha256.Size]
+	padding = binary.BigEndian.Uint64(plaintext[len(payloadMagic)+sha256.Size : payloadHeaderSize])
+	return payloadHeaderSize, tagsDigestPrefix + hex.EncodeToString(value), padding
+}
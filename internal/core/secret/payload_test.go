@@ -0,0 +1,26 @@
+package secret
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPayloadHeader(t *testing.T) {
+	digest := tagsDigestPrefix + "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+	header, err := buildPayloadHeader(digest, 42)
+	if assert.NoError(t, err) {
+		size, aDigest, padding := parsePayloadHeader(append(header, "foo"...))
+		assert.Equal(t, payloadHeaderSize, size)
+		assert.Equal(t, digest, aDigest)
+		assert.Equal(t, uint64(42), padding)
+	}
+
+	// Plaintexts written by older versions have no header.
+	size, _, _ := parsePayloadHeader([]byte("foo"))
+	assert.Equal(t, 0, size)
+
+	_, err = buildPayloadHeader("bar", 0)
+	assert.Error(t, err)
+}
@@ -1,26 +1,34 @@
 package secret
 
 import (
+	"bufio"
+	"bytes"
 	"compress/gzip"
+	"encoding/base64"
 	"encoding/json"
+	"io"
 	"os"
 
 	"github.com/pkg/errors"
+
+	"github.com/carlosabalde/pgp-tomb/internal/core/config"
+	"github.com/carlosabalde/pgp-tomb/internal/helpers/backend"
 )
 
 type Reader struct {
-	file *os.File
-	gzip *gzip.Reader
+	file         *os.File
+	decompressor io.ReadCloser
+	payload      *bufio.Reader
 }
 
 func (self *Reader) Read(p []byte) (int, error) {
-	return self.gzip.Read(p)
+	return self.payload.Read(p)
 }
 
 func (self *Reader) Close() error {
-	if err := self.gzip.Close(); err != nil {
+	if err := self.decompressor.Close(); err != nil {
 		self.file.Close()
-		return errors.Wrap(err, "failed to close gzip reader")
+		return errors.Wrap(err, "failed to close decompressor")
 	}
 
 	if err := self.file.Close(); err != nil {
@@ -36,38 +44,96 @@ func (self *Secret) NewReader() (*Reader, error) {
 		return nil, errors.Wrap(err, "failed to open file")
 	}
 
-	gzipReader, err := gzip.NewReader(fileReader)
+	bufferedReader := bufio.NewReader(fileReader)
+	version, err := detectVersion(bufferedReader)
 	if err != nil {
 		fileReader.Close()
-		return nil, errors.Wrap(err, "failed to gunzip secret")
+		return nil, errors.Wrap(err, "failed to detect format version")
 	}
 
-	if err := self.unserializeTags(gzipReader.Extra); err != nil {
-		gzipReader.Close()
-		fileReader.Close()
-		return nil, errors.Wrap(err, "failed to unserialize tags")
+	var header *metadata
+	var decompressor io.ReadCloser
+	if version == Version1 {
+		gzipReader, err := gzip.NewReader(bufferedReader)
+		if err != nil {
+			fileReader.Close()
+			return nil, errors.Wrap(err, "failed to gunzip secret")
+		}
+
+		header, err = unserializeVersion1Header(gzipReader.Extra)
+		if err != nil {
+			gzipReader.Close()
+			fileReader.Close()
+			return nil, errors.Wrap(err, "failed to unserialize tags")
+		}
+		header.Compression = config.CompressionGzip
+		decompressor = gzipReader
+	} else {
+		header, err = readContainerHeader(bufferedReader)
+		if err != nil {
+			fileReader.Close()
+			return nil, errors.Wrap(err, "failed to read container header")
+		}
+
+		decompressor, err = newDecompressor(bufferedReader, header.Compression)
+		if err != nil {
+			fileReader.Close()
+			return nil, errors.Wrap(err, "failed to decompress secret")
+		}
 	}
 
+	// Backend is detected looking at the encrypted payload.
+	payload := bufio.NewReader(decompressor)
+
+	self.version = version
+	self.backend = backend.Detect(payload)
+	self.setHeader(header)
+
 	return &Reader{
-		file: fileReader,
-		gzip: gzipReader,
+		file:         fileReader,
+		decompressor: decompressor,
+		payload:      payload,
 	}, nil
 }
 
-func (self *Secret) unserializeTags(data []byte) error {
-	tagsMap := make(map[string]string)
-	if err := json.Unmarshal(data, &tagsMap); err != nil {
-		return err
+func unserializeVersion1Header(data []byte) (*metadata, error) {
+	result := &metadata{
+		Tags: make(map[string]string),
+	}
+	if err := json.Unmarshal(data, &result.Tags); err != nil {
+		return nil, err
 	}
 
+	if value, found := result.Tags[privateTagsKey]; found {
+		var err error
+		if result.PrivateTags, err = base64.StdEncoding.DecodeString(value); err != nil {
+			return nil, err
+		}
+		delete(result.Tags, privateTagsKey)
+	}
+
+	return result, nil
+}
+
+func (self *Secret) setHeader(header *metadata) {
 	var tags []Tag
-	for name, value := range tagsMap {
+	for name, value := range header.Tags {
 		tags = append(tags, Tag{
 			Name:  name,
 			Value: value,
 		})
 	}
-	self.SetTags(tags)
 
-	return nil
+	// Keep private tags if already decrypted.
+	if self.privateTagsLoaded && bytes.Equal(header.PrivateTags, self.encryptedPrivateTags) {
+		for _, tag := range self.tags {
+			if config.IsPrivateTag(tag.Name) {
+				tags = append(tags, tag)
+			}
+		}
+	} else {
+		self.privateTagsLoaded = len(header.PrivateTags) == 0
+	}
+	self.encryptedPrivateTags = header.PrivateTags
+	self.setTags(tags)
 }
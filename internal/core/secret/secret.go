@@ -1,8 +1,14 @@
 package secret
 
 import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"path"
 	"reflect"
@@ -10,16 +16,48 @@ import (
 	"strings"
 
 	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
 
 	"github.com/carlosabalde/pgp-tomb/internal/core/config"
+	"github.com/carlosabalde/pgp-tomb/internal/helpers/backend"
 	"github.com/carlosabalde/pgp-tomb/internal/helpers/pgp"
 	"github.com/carlosabalde/pgp-tomb/internal/helpers/slices"
 )
 
+const tagsDigestPrefix = "pgp-tomb-tags-sha256:"
+
+// Key used to store encrypted private tags in the header. Using ':' ensures
+// it cannot collide with the name of a tag (see 'parseTags()').
+const privateTagsKey = "pgp-tomb:private-tags"
+
+// Reasons why a secret cannot be decrypted. Others are reported by backends
+// (see pgp.DecryptionError).
+const (
+	DecryptionNotRecipient = "not-recipient"
+	DecryptionNoSecretKey  = pgp.FailureNoSecretKey
+	DecryptionPassphrase   = pgp.FailurePassphrase
+	DecryptionCorrupt      = pgp.FailureCorrupt
+	DecryptionGPGMissing   = pgp.FailureGPGMissing
+	DecryptionUnknown      = "unknown"
+)
+
+const (
+	TagsBound    = "bound"
+	TagsUnbound  = "unbound"
+	TagsTampered = "tampered"
+)
+
 type Secret struct {
-	uri  string
-	tags []Tag
-	path string
+	uri     string
+	tags    []Tag
+	path    string
+	version int
+	backend string
+
+	// Private tags are stored encrypted in the header, and only become part
+	// of 'tags' once decrypted.
+	encryptedPrivateTags []byte
+	privateTagsLoaded    bool
 }
 
 type Tag struct {
@@ -33,11 +71,36 @@ func (self *DoesNotExist) Error() string {
 	return "secret does not exist"
 }
 
+type TagsMismatch struct{}
+
+func (self *TagsMismatch) Error() string {
+	return "tags do not match encrypted tags digest"
+}
+
+type SignatureRejected struct {
+	Reason string
+}
+
+func (self *SignatureRejected) Error() string {
+	return fmt.Sprintf("signature rejected: %s", self.Reason)
+}
+
+type DecryptionFailed struct {
+	Reason string
+	Err    error
+}
+
+func (self *DecryptionFailed) Error() string {
+	return fmt.Sprintf("%s (%s)", self.Err.Error(), self.Reason)
+}
+
 func New(uri string) *Secret {
 	return &Secret{
-		uri:  uri,
-		tags: make([]Tag, 0),
-		path: path.Join(config.GetSecretsRoot(), uri+config.SecretExtension),
+		uri:               uri,
+		tags:              make([]Tag, 0),
+		path:              path.Join(config.GetSecretsRoot(), uri+config.SecretExtension),
+		version:           LatestVersion,
+		privateTagsLoaded: true,
 	}
 }
 
@@ -62,12 +125,27 @@ func (self *Secret) GetUri() string {
 	return self.uri
 }
 
+// Format version of the '.secret' file.
+func (self *Secret) GetVersion() int {
+	return self.version
+}
+
 func (self *Secret) GetTags() []Tag {
 	return self.tags
 }
 
+// Returns false if the secret includes private tags not decrypted yet.
+func (self *Secret) HasPrivateTagsLoaded() bool {
+	return self.privateTagsLoaded
+}
+
+// Includes both public & private tags (if already decrypted).
 func (self *Secret) GetSerializedTags() (string, error) {
-	data, err := self.serializeTags()
+	tagsMap := make(map[string]string)
+	for _, tag := range self.tags {
+		tagsMap[tag.Name] = tag.Value
+	}
+	data, err := json.Marshal(tagsMap)
 	if err != nil {
 		return "", errors.Wrap(err, "failed to serialize tags")
 	}
@@ -75,6 +153,11 @@ func (self *Secret) GetSerializedTags() (string, error) {
 }
 
 func (self *Secret) SetTags(tags []Tag) {
+	self.setTags(tags)
+	self.privateTagsLoaded = true
+}
+
+func (self *Secret) setTags(tags []Tag) {
 	self.tags = make([]Tag, 0)
 
 	names := make(map[string]bool)
@@ -96,47 +179,342 @@ func (self *Secret) GetPath() string {
 }
 
 func (self *Secret) Encrypt(input io.Reader) error {
+	keys, err := self.GetExpectedPublicKeys()
+	if err != nil {
+		return errors.Wrap(err, "failed to get expected public keys")
+	}
+
+	// Only the PGP backend signs secrets.
+	name := self.GetExpectedBackend()
+	sign := config.GetSignSecrets() && name == backend.PGP
+
+	// All recipients need a public key usable by the backend.
+	if aliases := backend.GetUnsupportedKeys(self.newBackend(name, false), keys); len(aliases) > 0 {
+		return errors.Errorf(
+			"refusing to encrypt using the %s backend: no %s public key for %s",
+			name, name, strings.Join(aliases, ", "))
+	}
+
+	if name == backend.PGP {
+		// Pinned fingerprints are always enforced, no matter the key policy.
+		// Once some fingerprint is pinned, unpinned keys are refused too.
+		pinning := len(config.GetPinnedFingerprints()) > 0
+		for _, key := range keys {
+			pinned := config.GetPinnedFingerprint(key.Alias)
+			if pinned == "" && pinning {
+				return errors.Errorf(
+					"refusing to encrypt to key '%s': fingerprint %s is not pinned",
+					key.Alias, key.PGP.GetFingerprint())
+			} else if pinned != "" && pinned != key.PGP.GetFingerprint() {
+				return errors.Errorf(
+					"refusing to encrypt to key '%s': fingerprint does not match pinned fingerprint %s",
+					key.Alias, pinned)
+			}
+		}
+
+		if config.GetKeyPolicy().Refuse {
+			for _, key := range keys {
+				for _, issue := range config.GetPublicKeyIssues(key.Alias) {
+					if issue.Severe {
+						return errors.Errorf(
+							"refusing to encrypt to key '%s': %s", key.Alias, issue.Description)
+					}
+				}
+			}
+		}
+	}
+
+	// Signing key needs to be unlocked before truncating the secret.
+	if sign {
+		if key := config.GetPrivateKey(); key != nil {
+			if err := pgp.UnlockPrivateKey(config.GetPassphrasePrompt(), key); err != nil {
+				return errors.Wrap(err, "failed to unlock signing key")
+			}
+		} else {
+			if identity := config.GetIdentity(); identity == nil || identity.PGP == nil {
+				return errors.New("a PGP identity is required to sign secrets using GPG")
+			}
+			if config.GetEncryption().AEAD != pgp.AEADNone {
+				return errors.New("AEAD encryption is not supported when signing secrets using GPG")
+			}
+		}
+	}
+
+	// Encrypt private tags.
+	if !self.privateTagsLoaded {
+		return errors.New("private tags must be decrypted before encrypting the secret")
+	}
+	if privateTags, err := self.serializeTags(true); err != nil {
+		return errors.Wrap(err, "failed to serialize private tags")
+	} else if privateTags == nil {
+		self.encryptedPrivateTags = nil
+	} else {
+		buffer := new(bytes.Buffer)
+		if err := self.newBackend(name, false).Encrypt(bytes.NewReader(privateTags), buffer, keys, ""); err != nil {
+			return errors.Wrap(err, "failed to encrypt private tags")
+		}
+		self.encryptedPrivateTags = buffer.Bytes()
+	}
+
+	// Tags are bound to the encrypted (and optionally signed) payload storing
+	// their digest in the header prepended to the plaintext.
+	digest, err := self.getTagsDigest()
+	if err != nil {
+		return errors.Wrap(err, "failed to compute tags digest")
+	}
+
+	// Random padding is appended to the plaintext in order to hide its
+	// length. Its length is stored in the header, next to the tags digest.
+	padding := 0
+	if settings := config.GetPadding(self.GetTemplate()); settings.Scheme != config.PaddingNone {
+		plaintext, err := ioutil.ReadAll(input)
+		if err != nil {
+			return errors.Wrap(err, "failed to read secret")
+		}
+		padding = getPaddingLength(settings, len(plaintext))
+		input = io.MultiReader(
+			bytes.NewReader(plaintext),
+			io.LimitReader(rand.Reader, int64(padding)))
+	}
+	header, err := buildPayloadHeader(digest, padding)
+	if err != nil {
+		return errors.Wrap(err, "failed to build payload header")
+	}
+	input = io.MultiReader(bytes.NewReader(header), input)
+
+	// The secret is only replaced once completely written, so failures
+	// (e.g. encryption settings refused by recipients) never destroy it.
 	output, err := self.NewWriter()
 	if err != nil {
 		return errors.Wrap(err, "failed to open secret")
 	}
-	defer output.Close()
 
-	keys, err := self.GetExpectedPublicKeys()
+	current := self.backend
+	self.backend = name
+	if err := self.newBackend(name, sign).Encrypt(input, output, keys, ""); err != nil {
+		self.backend = current
+		output.Discard()
+		return errors.Wrap(err, "failed to encrypt secret")
+	}
+	if err := output.Close(); err != nil {
+		self.backend = current
+		return errors.Wrap(err, "failed to close secret")
+	}
+
+	return nil
+}
+
+// Rewrites the secret using the latest format version. The encrypted payload
+// is kept as is, so no decryption is needed.
+func (self *Secret) Migrate() error {
+	input, err := self.NewReader()
 	if err != nil {
-		return errors.Wrap(err, "failed to get expected public keys")
+		return errors.Wrap(err, "failed to open secret")
+	}
+	payload, err := ioutil.ReadAll(input)
+	input.Close()
+	if err != nil {
+		return errors.Wrap(err, "failed to read secret")
 	}
 
-	if err := pgp.Encrypt(input, output, keys); err != nil {
-		return errors.Wrap(err, "failed to encrypt secret")
+	// The new version replaces the secret only once completely written.
+	version := self.version
+	self.version = LatestVersion
+	output, err := self.NewWriter()
+	if err != nil {
+		self.version = version
+		return errors.Wrap(err, "failed to open secret")
+	}
+	if _, err := output.Write(payload); err != nil {
+		self.version = version
+		output.Discard()
+		return errors.Wrap(err, "failed to write secret")
+	}
+	if err := output.Close(); err != nil {
+		self.version = version
+		return errors.Wrap(err, "failed to close secret")
 	}
 
 	return nil
 }
 
+// Decrypts the secret checking its signature according with the configured
+// policy, and checking its tags have not been tampered with. Both checks are
+// executed before writing anything to 'output'.
 func (self *Secret) Decrypt(output io.Writer) error {
+	return self.decrypt(output, false)
+}
+
+// Like Decrypt(), but tampered tags are just reported as a warning. Useful
+// to allow recovery of secrets whose tags have been tampered with.
+func (self *Secret) DecryptIgnoringTags(output io.Writer) error {
+	return self.decrypt(output, true)
+}
+
+func (self *Secret) decrypt(output io.Writer, ignoreTags bool) error {
+	buffer := new(bytes.Buffer)
+	verification, err := self.DecryptAndVerify(buffer)
+	if err != nil {
+		return err
+	}
+
+	if state, err := self.CheckTags(verification); err != nil {
+		return errors.Wrap(err, "failed to check tags")
+	} else if state == TagsTampered {
+		if !ignoreTags {
+			return &TagsMismatch{}
+		}
+		logrus.WithFields(logrus.Fields{
+			"uri": self.uri,
+		}).Warn("Secret tags do not match encrypted tags digest!")
+	}
+
+	policy := config.GetSecretSignaturePolicy()
+	switch verification.State {
+	case pgp.SignatureInvalid:
+		return &SignatureRejected{"invalid signature"}
+	case pgp.SignatureNone, pgp.SignatureUnknown:
+		reason := "unsigned secret"
+		if verification.State == pgp.SignatureUnknown {
+			reason = fmt.Sprintf("signed by unknown key %s", verification.KeyId)
+		}
+		if policy == config.SignaturePolicyRequire {
+			return &SignatureRejected{reason}
+		} else if policy == config.SignaturePolicyWarn {
+			logrus.WithFields(logrus.Fields{
+				"uri":    self.uri,
+				"reason": reason,
+			}).Warn("Secret signature not verified!")
+		}
+	}
+
+	if _, err := io.Copy(output, buffer); err != nil {
+		return errors.Wrap(err, "failed to copy decrypted secret")
+	}
+
+	return nil
+}
+
+// Decrypts the secret returning the result of checking its signature, no
+// matter the configured policy.
+func (self *Secret) DecryptAndVerify(output io.Writer) (backend.Verification, error) {
 	input, err := self.NewReader()
 	if err != nil {
-		return errors.Wrap(err, "failed to open secret")
+		return backend.Verification{}, errors.Wrap(err, "failed to open secret")
 	}
 	defer input.Close()
 
-	key := config.GetPrivateKey()
-	if key == nil {
-		if err := pgp.DecryptWithGPG(config.GetGPG(), input, output); err != nil {
-			return errors.Wrap(err, "failed to decrypt secret")
+	plaintext := new(bytes.Buffer)
+	verification, err := self.newBackend(self.backend, false).Decrypt(input, plaintext)
+	if err != nil {
+		return backend.Verification{}, self.newDecryptionFailed(
+			errors.Wrap(err, "failed to decrypt secret"))
+	}
+
+	// Strip header & padding. Secrets written by older versions have no
+	// header, and store the tags digest & the length of the padding in the
+	// file name of the literal data packet. The file name in the verification
+	// is replaced by the values read from the header, so callers (e.g.
+	// CheckTags()) never trust the unsigned one.
+	size, digest, padding := parsePayloadHeader(plaintext.Bytes())
+	if size > 0 {
+		plaintext.Next(size)
+		if padding > uint64(plaintext.Len()) {
+			return backend.Verification{}, errors.New("padding exceeds length of decrypted secret")
 		}
-	} else {
-		if err := pgp.Decrypt(config.GetGPGConnectAgent(), input, output, key); err != nil {
-			return errors.Wrap(err, "failed to decrypt secret")
+		verification.FileName = buildFileName(digest, int(padding))
+	} else if _, legacyPadding := parseFileName(verification.FileName); legacyPadding > 0 {
+		if legacyPadding > plaintext.Len() {
+			return backend.Verification{}, errors.New("padding exceeds length of decrypted secret")
 		}
+		padding = uint64(legacyPadding)
+	}
+	plaintext.Truncate(plaintext.Len() - int(padding))
+	if _, err := io.Copy(output, plaintext); err != nil {
+		return backend.Verification{}, errors.Wrap(err, "failed to copy decrypted secret")
 	}
 
-	return nil
+	if !self.privateTagsLoaded {
+		buffer := new(bytes.Buffer)
+		encrypted := bufio.NewReader(bytes.NewReader(self.encryptedPrivateTags))
+		if _, err := self.newBackend(backend.Detect(encrypted), false).Decrypt(encrypted, buffer); err != nil {
+			return backend.Verification{}, self.newDecryptionFailed(
+				errors.Wrap(err, "failed to decrypt private tags"))
+		}
+		tagsMap := make(map[string]string)
+		if err := json.Unmarshal(buffer.Bytes(), &tagsMap); err != nil {
+			return backend.Verification{}, errors.Wrap(err, "failed to unserialize private tags")
+		}
+		tags := self.tags
+		for name, value := range tagsMap {
+			tags = append(tags, Tag{Name: name, Value: value})
+		}
+		self.setTags(tags)
+		self.privateTagsLoaded = true
+	}
+
+	return verification, nil
+}
+
+// Compares current tags with the digest stored in the encrypted payload.
+// Secrets written by older versions don't include a digest.
+func (self *Secret) CheckTags(verification backend.Verification) (string, error) {
+	expected, _ := parseFileName(verification.FileName)
+	if !strings.HasPrefix(expected, tagsDigestPrefix) {
+		return TagsUnbound, nil
+	}
+
+	digest, err := self.getTagsDigest()
+	if err != nil {
+		return "", err
+	}
+
+	if digest == expected {
+		return TagsBound, nil
+	}
+	return TagsTampered, nil
 }
 
-func (self *Secret) GetExpectedPublicKeys() ([]*pgp.PublicKey, error) {
-	result := make([]*pgp.PublicKey, 0)
+// Returns algorithms used to encrypt or sign the secret which are weaker than
+// the configured ones. Unknown algorithms are ignored. Secrets not encrypted
+// using AEAD are reported ('mdc') if AEAD is enabled. Only secrets encrypted
+// using the PGP backend are checked.
+func (self *Secret) CheckAlgorithms(verification backend.Verification) []string {
+	result := make([]string, 0)
+	if self.GetBackend() != backend.PGP {
+		return result
+	}
+	encryption := config.GetEncryption()
+	if verification.Cipher != "" && pgp.IsWeakerAlgorithm(verification.Cipher, encryption.Cipher) {
+		result = append(result, verification.Cipher)
+	}
+	if verification.Hash != "" && pgp.IsWeakerAlgorithm(verification.Hash, encryption.Hash) {
+		result = append(result, verification.Hash)
+	}
+	if verification.Cipher != "" && verification.AEAD == "" && encryption.AEAD != pgp.AEADNone {
+		result = append(result, "mdc")
+	}
+	return result
+}
+
+// Digest of public tags followed, if any, by private tags.
+func (self *Secret) getTagsDigest() (string, error) {
+	data, err := self.serializeTags(false)
+	if err != nil {
+		return "", err
+	}
+	privateData, err := self.serializeTags(true)
+	if err != nil {
+		return "", err
+	}
+	if privateData != nil {
+		data = append(append(data, '\n'), privateData...)
+	}
+	return fmt.Sprintf("%s%x", tagsDigestPrefix, sha256.Sum256(data)), nil
+}
+
+func (self *Secret) GetExpectedPublicKeys() ([]*backend.PublicKey, error) {
+	result := make([]*backend.PublicKey, 0)
 
 	for _, rule := range config.GetPermissionRules() {
 		if rule.Query.Eval(self) {
@@ -151,7 +529,7 @@ func (self *Secret) GetExpectedPublicKeys() ([]*pgp.PublicKey, error) {
 				if err != nil {
 					return nil, errors.Wrap(err, "unexpected error")
 				}
-				result = tmp.Interface().([]*pgp.PublicKey)
+				result = tmp.Interface().([]*backend.PublicKey)
 			}
 		}
 	}
@@ -160,19 +538,53 @@ func (self *Secret) GetExpectedPublicKeys() ([]*pgp.PublicKey, error) {
 	if err != nil {
 		return nil, errors.Wrap(err, "unexpected error")
 	}
-	result = tmp.Interface().([]*pgp.PublicKey)
+	result = tmp.Interface().([]*backend.PublicKey)
+
+	if key := config.GetBreakglassKeyFor(self.GetExpectedBackend()); key != nil {
+		tmp, err := slices.Union(result, []*backend.PublicKey{key})
+		if err != nil {
+			return nil, errors.Wrap(err, "unexpected error")
+		}
+		result = tmp.Interface().([]*backend.PublicKey)
+	}
 
 	return result, nil
 }
 
-func (self *Secret) GetCurrentRecipientsKeyIds() ([]uint64, error) {
+// Teams referenced by permission rules matching the secret (prefixed by '-'
+// when denied), together with the aliases of their resolved members.
+func (self *Secret) GetExpectedTeams() []string {
+	result := make([]string, 0)
+	for _, rule := range config.GetPermissionRules() {
+		if rule.Query.Eval(self) {
+			for _, expression := range rule.Expressions {
+				if expression.Team != "" {
+					aliases := make([]string, 0, len(expression.Keys))
+					for _, key := range expression.Keys {
+						aliases = append(aliases, key.Alias)
+					}
+					sort.Strings(aliases)
+					team := expression.Team
+					if expression.Deny {
+						team = "-" + team
+					}
+					result = append(result, fmt.Sprintf("%s (%s)", team, strings.Join(aliases, ", ")))
+				}
+			}
+		}
+	}
+	return result
+}
+
+// Identifiers are backend specific (e.g. PGP key IDs).
+func (self *Secret) GetCurrentRecipientsKeyIds() ([]string, error) {
 	input, err := self.NewReader()
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to open secret")
 	}
 	defer input.Close()
 
-	ids, err := pgp.GetRecipientKeyIdsForEncryptedMessage(input)
+	ids, err := self.newBackend(self.backend, false).GetRecipientIds(input)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to get current recipients")
 	}
@@ -180,6 +592,66 @@ func (self *Secret) GetCurrentRecipientsKeyIds() ([]uint64, error) {
 	return ids, nil
 }
 
+// Classifies a backend decryption failure. Not being a recipient is detected
+// comparing current recipients with the local private key (or identity), as
+// backends can only tell that no secret key is available.
+func (self *Secret) newDecryptionFailed(err error) *DecryptionFailed {
+	reason := DecryptionUnknown
+	if anError, ok := errors.Cause(err).(*pgp.DecryptionError); ok {
+		reason = anError.Reason
+	}
+
+	if reason == DecryptionUnknown || reason == DecryptionNoSecretKey {
+		ids := self.getLocalKeyIds()
+		if recipients, err := self.GetCurrentRecipientsKeyIds(); err == nil &&
+			len(ids) > 0 && len(recipients) > 0 {
+			found := false
+			for _, id := range ids {
+				for _, recipient := range recipients {
+					if id == recipient {
+						found = true
+					}
+				}
+			}
+			if !found {
+				reason = DecryptionNotRecipient
+			}
+		}
+	}
+
+	return &DecryptionFailed{reason, err}
+}
+
+// Identifiers of the private keys used to decrypt the secret: the native
+// private keys, if any, or the identities.
+func (self *Secret) getLocalKeyIds() []string {
+	result := make([]string, 0)
+	aBackend := self.newBackend(self.backend, false)
+	if keys := config.GetPrivateKeys(); len(keys) > 0 && self.backend != backend.Age {
+		for _, key := range keys {
+			result = append(result, aBackend.GetKeyIds(&backend.PublicKey{
+				PGP: &pgp.PublicKey{Entity: key.Entity},
+			})...)
+		}
+	} else {
+		for _, identity := range config.GetIdentities() {
+			result = append(result, aBackend.GetKeyIds(identity)...)
+		}
+	}
+	return result
+}
+
+// Compression settings according with the first matching compression rule.
+func (self *Secret) GetCompression() config.Compression {
+	for _, rule := range config.GetCompressionRules() {
+		if rule.Query.Eval(self) {
+			return rule.Compression
+		}
+	}
+
+	return config.DefaultCompression
+}
+
 func (self *Secret) GetTemplate() *config.Template {
 	for _, rule := range config.GetTemplateRules() {
 		if rule.Query.Eval(self) {
@@ -208,13 +680,13 @@ func (self *Secret) GetRecipients() (expected, unknown, rubbish, missing []strin
 
 	// Determine current & unknown recipients.
 	for _, keyId := range currentRecipientKeyIds {
-		key := findPublicKeyByKeyId(keyId)
+		key := self.findPublicKeyByKeyId(keyId)
 		if key != nil {
 			current = append(current, key.Alias)
+		} else if previous := self.findPreviousKeyByKeyId(keyId); previous != nil {
+			unknown = append(unknown, fmt.Sprintf("%s (previous '%s')", keyId, previous.Alias))
 		} else {
-			unknown = append(
-				unknown,
-				fmt.Sprintf("0x%x", keyId))
+			unknown = append(unknown, keyId)
 		}
 	}
 	sort.Strings(unknown)
@@ -252,11 +724,22 @@ func (self *Secret) GetRecipients() (expected, unknown, rubbish, missing []strin
 	return
 }
 
-func (self *Secret) IsReadableBy(key *pgp.PublicKey) (bool, error) {
-	// Try expected recipients.
-	if keys, err := self.GetExpectedPublicKeys(); err == nil {
-		for _, aKey := range keys {
+// Checks if any of 'keys' is an expected or a current recipient. Previous keys
+// of an alias (see 'pgp-tomb keys rotate') count as the alias.
+func (self *Secret) IsReadableBy(keys ...*backend.PublicKey) (bool, error) {
+	isOneOf := func(aKey *backend.PublicKey) bool {
+		for _, key := range keys {
 			if aKey == key {
+				return true
+			}
+		}
+		return false
+	}
+
+	// Try expected recipients.
+	if expectedKeys, err := self.GetExpectedPublicKeys(); err == nil {
+		for _, aKey := range expectedKeys {
+			if isOneOf(aKey) {
 				return true, nil
 			}
 		}
@@ -267,10 +750,15 @@ func (self *Secret) IsReadableBy(key *pgp.PublicKey) (bool, error) {
 	// Try current recipients.
 	if currentRecipientKeyIds, err := self.GetCurrentRecipientsKeyIds(); err == nil {
 		for _, keyId := range currentRecipientKeyIds {
-			aKey := findPublicKeyByKeyId(keyId)
-			if aKey != nil && aKey == key {
+			aKey := self.findPublicKeyByKeyId(keyId)
+			if aKey != nil && isOneOf(aKey) {
 				return true, nil
 			}
+			if previous := self.findPreviousKeyByKeyId(keyId); previous != nil {
+				if aKey := config.GetPublicKeys()[previous.Alias]; aKey != nil && isOneOf(aKey) {
+					return true, nil
+				}
+			}
 		}
 	} else {
 		return false, errors.Wrap(err, "failed to determine current recipients")
@@ -284,7 +772,7 @@ func (self *Secret) IsReadableBy(key *pgp.PublicKey) (bool, error) {
 func (self *Secret) GetIdentifier(key string) string {
 	if key == "uri" {
 		return self.uri
-	} else if strings.HasPrefix(key, "tags.") {
+	} else if strings.HasPrefix(key, "tags.") && !config.IsPrivateTag(key[5:]) {
 		name := strings.ToLower(key[5:])
 		for _, tag := range self.tags {
 			if strings.ToLower(tag.Name) == name {
@@ -295,16 +783,34 @@ func (self *Secret) GetIdentifier(key string) string {
 	return ""
 }
 
-func findPublicKeyByKeyId(id uint64) *pgp.PublicKey {
+// Looks for the public key matching a recipient identifier of the current
+// backend.
+func (self *Secret) findPublicKeyByKeyId(id string) *backend.PublicKey {
+	aBackend := self.newBackend(self.GetBackend(), false)
 	for _, key := range config.GetPublicKeys() {
-		if key.Entity.PrimaryKey.KeyId == id {
-			return key
-		}
-		for _, subkey := range key.Entity.Subkeys {
-			if subkey.PublicKey.KeyId == id {
+		for _, anId := range aBackend.GetKeyIds(key) {
+			if anId == id {
 				return key
 			}
 		}
 	}
 	return nil
 }
+
+// Looks for the previous key (i.e. replaced using 'pgp-tomb keys rotate')
+// matching a recipient identifier of the current backend.
+func (self *Secret) findPreviousKeyByKeyId(id string) *config.PreviousKey {
+	for _, keys := range config.GetAllPreviousKeys() {
+		for i := range keys {
+			if keys[i].Backend != self.GetBackend() {
+				continue
+			}
+			for _, anId := range keys[i].KeyIds {
+				if anId == id {
+					return &keys[i]
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,253 @@
+package secret
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/carlosabalde/pgp-tomb/internal/core/config"
+	"github.com/carlosabalde/pgp-tomb/internal/helpers/pgp"
+)
+
+// Initializes the configuration of a new tomb in a temporary folder, holding
+// public keys of alice & bob, and using the private key of alice. Returns the
+// root folder of the tomb.
+func initTestTomb(t *testing.T, options string) string {
+	root, err := ioutil.TempDir("", "pgp-tomb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, folder := range []string{"hooks", "keys", "secrets", "templates"} {
+		if err := os.Mkdir(path.Join(root, folder), 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, alias := range []string{"alice", "bob"} {
+		data, err := ioutil.ReadFile("../../../files/keys/" + alias + ".pub")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path.Join(root, "keys", alias+".pub"), data, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	key, err := filepath.Abs("../../../files/keys/alice.pri")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	os.Setenv("PGP_TOMB_TEST_PASSPHRASE", "s3cr3t")
+	file := path.Join(root, "pgp-tomb.yaml")
+	if err := ioutil.WriteFile(file, []byte(
+		"root: "+root+"\n"+
+			"identity: alice\n"+
+			"key: "+key+"\n"+
+			"passphrase:\n"+
+			"  env: PGP_TOMB_TEST_PASSPHRASE\n"+
+			"keepers: [alice, bob]\n"+
+			options), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	logrus.SetLevel(logrus.ErrorLevel)
+	viper.Reset()
+	viper.SetConfigType("yaml")
+	viper.SetConfigFile(file)
+	if err := viper.ReadInConfig(); err != nil {
+		t.Fatal(err)
+	}
+	config.Init(file)
+
+	return root
+}
+
+func TestEncryptFailureKeepsSecret(t *testing.T) {
+	defer viper.Reset()
+	defer os.Unsetenv("PGP_TOMB_TEST_PASSPHRASE")
+
+	root := initTestTomb(t, "")
+	defer os.RemoveAll(root)
+
+	s := New("foo")
+	if err := s.Encrypt(bytes.NewReader([]byte("bar"))); err != nil {
+		t.Fatal(err)
+	}
+	original, err := ioutil.ReadFile(s.GetPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Re-encryption using settings not accepted by recipients.
+	viper.Set("encryption", config.Encryption{
+		Cipher: config.GetEncryption().Cipher,
+		Hash:   config.GetEncryption().Hash,
+		AEAD:   "ocb",
+	})
+	s, err = Load("foo")
+	if assert.NoError(t, err) {
+		assert.Error(t, s.Encrypt(bytes.NewReader([]byte("baz"))))
+	}
+
+	current, err := ioutil.ReadFile(s.GetPath())
+	assert.NoError(t, err)
+	assert.Equal(t, original, current)
+	files, err := ioutil.ReadDir(path.Join(root, "secrets"))
+	if assert.NoError(t, err) {
+		assert.Len(t, files, 1)
+	}
+}
+
+func TestEncryptRefusesUnpinnedKeys(t *testing.T) {
+	defer viper.Reset()
+	defer os.Unsetenv("PGP_TOMB_TEST_PASSPHRASE")
+
+	// Default key policy (i.e. warn): 'bob' is not pinned.
+	root := initTestTomb(t,
+		"fingerprints:\n"+
+			"  alice: FA47DEB05289E92E4504962BE94F302B75D78168\n")
+	defer os.RemoveAll(root)
+
+	err := New("foo").Encrypt(bytes.NewReader([]byte("bar")))
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "refusing to encrypt to key 'bob'")
+		assert.Contains(t, err.Error(), "is not pinned")
+	}
+	_, err = Load("foo")
+	assert.IsType(t, &DoesNotExist{}, err)
+}
+
+func TestDecryptRefusesTamperedTagsWithValidSignature(t *testing.T) {
+	defer viper.Reset()
+	defer os.Unsetenv("PGP_TOMB_TEST_PASSPHRASE")
+
+	root := initTestTomb(t,
+		"secret-signatures:\n"+
+			"  sign: true\n"+
+			"  policy: require\n")
+	defer os.RemoveAll(root)
+
+	s := New("foo")
+	s.SetTags([]Tag{{Name: "type", Value: "ACME"}})
+	if err := s.Encrypt(bytes.NewReader([]byte("bar"))); err != nil {
+		t.Fatal(err)
+	}
+
+	// Signed plaintext (i.e. including the header) is re-wrapped by a
+	// recipient using different tags & a matching file name in the literal
+	// data packet, which is not covered by the signature.
+	s, err := Load("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	input, err := s.NewReader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext := new(bytes.Buffer)
+	_, err = s.newBackend(s.GetBackend(), false).Decrypt(input, plaintext)
+	input.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SetTags([]Tag{{Name: "type", Value: "Hooli"}})
+	digest, err := s.getTagsDigest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := s.GetExpectedPublicKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	output, err := s.NewWriter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.newBackend(s.GetBackend(), true).Encrypt(
+		plaintext, output, keys, buildFileName(digest, 0)); err != nil {
+		t.Fatal(err)
+	}
+	if err := output.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Signature is valid, but tags are tampered.
+	s, err = Load("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	verification, err := s.DecryptAndVerify(ioutil.Discard)
+	if assert.NoError(t, err) {
+		assert.Equal(t, pgp.SignatureValid, verification.State)
+		state, err := s.CheckTags(verification)
+		assert.NoError(t, err)
+		assert.Equal(t, TagsTampered, state)
+	}
+	assert.IsType(t, &TagsMismatch{}, s.Decrypt(ioutil.Discard))
+}
+
+func TestDecryptIgnoresTamperedPaddingWithValidSignature(t *testing.T) {
+	defer viper.Reset()
+	defer os.Unsetenv("PGP_TOMB_TEST_PASSPHRASE")
+
+	root := initTestTomb(t,
+		"secret-signatures:\n"+
+			"  sign: true\n"+
+			"  policy: require\n")
+	defer os.RemoveAll(root)
+
+	if err := New("foo").Encrypt(bytes.NewReader([]byte("bar"))); err != nil {
+		t.Fatal(err)
+	}
+
+	// Signed plaintext is re-wrapped by a recipient using a file name in the
+	// literal data packet, which is not covered by the signature, claiming
+	// part of the secret is padding.
+	s, err := Load("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	input, err := s.NewReader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext := new(bytes.Buffer)
+	_, err = s.newBackend(s.GetBackend(), false).Decrypt(input, plaintext)
+	input.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := s.GetExpectedPublicKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	output, err := s.NewWriter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.newBackend(s.GetBackend(), true).Encrypt(
+		plaintext, output, keys, buildFileName("", 2)); err != nil {
+		t.Fatal(err)
+	}
+	if err := output.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Secret is not truncated.
+	s, err = Load("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret := new(bytes.Buffer)
+	verification, err := s.DecryptAndVerify(secret)
+	if assert.NoError(t, err) {
+		assert.Equal(t, pgp.SignatureValid, verification.State)
+		assert.Equal(t, "bar", secret.String())
+	}
+}
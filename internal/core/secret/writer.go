@@ -2,8 +2,11 @@ package secret
 
 import (
 	"compress/gzip"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 
@@ -12,59 +15,164 @@ import (
 	"github.com/carlosabalde/pgp-tomb/internal/core/config"
 )
 
+// Secrets are written to a temporary file in the same folder, replacing the
+// secret only once it has been completely written (i.e. when closing the
+// writer). Use Discard() to give up without touching the secret.
 type Writer struct {
-	file *os.File
-	gzip *gzip.Writer
+	file       *os.File
+	compressor io.WriteCloser
+	target     string
 }
 
 func (self *Writer) Write(p []byte) (int, error) {
-	return self.gzip.Write(p)
+	return self.compressor.Write(p)
 }
 
 func (self *Writer) Close() error {
-	if err := self.gzip.Close(); err != nil {
-		self.file.Close()
-		return errors.Wrap(err, "failed to close gzip writer")
+	if err := self.compressor.Close(); err != nil {
+		self.Discard()
+		return errors.Wrap(err, "failed to close compressor")
+	}
+
+	if err := self.file.Sync(); err != nil {
+		self.Discard()
+		return errors.Wrap(err, "failed to sync file writer")
 	}
 
 	if err := self.file.Close(); err != nil {
+		os.Remove(self.file.Name())
 		return errors.Wrap(err, "failed to close file writer")
 	}
 
+	if err := os.Rename(self.file.Name(), self.target); err != nil {
+		os.Remove(self.file.Name())
+		return errors.Wrap(err, "failed to replace secret")
+	}
+
 	return nil
 }
 
+// Removes the temporary file, leaving the secret untouched.
+func (self *Writer) Discard() {
+	self.file.Close()
+	os.Remove(self.file.Name())
+}
+
 func (self *Secret) NewWriter() (*Writer, error) {
 	if err := os.MkdirAll(filepath.Dir(self.path), os.ModePerm); err != nil {
 		return nil, errors.Wrap(err, "failed to create path to secret")
 	}
 
-	fileWriter, err := os.Create(self.path)
+	fileWriter, err := ioutil.TempFile(
+		filepath.Dir(self.path), "."+filepath.Base(self.path)+".")
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to open file")
+		return nil, errors.Wrap(err, "failed to create temporary file")
 	}
 
-	gzipWriter := gzip.NewWriter(fileWriter)
-
-	gzipWriter.Comment = fmt.Sprintf("Generated by PGP Tomb %s", config.GetVersion())
+	// Permissions of existing secrets are preserved.
+	if info, err := os.Stat(self.path); err == nil {
+		if err := fileWriter.Chmod(info.Mode().Perm()); err != nil {
+			fileWriter.Close()
+			os.Remove(fileWriter.Name())
+			return nil, errors.Wrap(err, "failed to set permissions of temporary file")
+		}
+	}
 
-	gzipWriter.Extra, err = self.serializeTags()
+	result, err := self.newWriter(fileWriter)
 	if err != nil {
-		gzipWriter.Close()
-		fileWriter.Close()
-		return nil, errors.Wrap(err, "failed to serialize tags")
+		os.Remove(fileWriter.Name())
+		return nil, err
+	}
+	result.target = self.path
+	return result, nil
+}
+
+func (self *Secret) newWriter(fileWriter *os.File) (*Writer, error) {
+	var err error
+
+	// Older format is kept when updating existing secrets, unless the
+	// header does not fit in the gzip header or the container is not
+	// supposed to be gzipped.
+	compression := self.GetCompression()
+	header := self.getHeader()
+	header.Compression = compression.Container
+	var extra []byte
+	if self.version == Version1 {
+		extra, err = serializeVersion1Header(header)
+		if err != nil {
+			fileWriter.Close()
+			return nil, errors.Wrap(err, "failed to serialize tags")
+		}
+		if len(extra) > maxVersion1HeaderSize || compression.Container != config.CompressionGzip {
+			self.version = LatestVersion
+		}
+	}
+
+	var compressor io.WriteCloser
+	if self.version == Version1 {
+		gzipWriter, err := gzip.NewWriterLevel(fileWriter, compression.ContainerLevel)
+		if err != nil {
+			fileWriter.Close()
+			return nil, errors.Wrap(err, "failed to initialize compressor")
+		}
+		gzipWriter.Comment = header.Generator
+		gzipWriter.Extra = extra
+		compressor = gzipWriter
+	} else {
+		if err := writeContainerHeader(fileWriter, self.version, header); err != nil {
+			fileWriter.Close()
+			return nil, errors.Wrap(err, "failed to write container header")
+		}
+
+		compressor, err = newCompressor(fileWriter, compression.Container, compression.ContainerLevel)
+		if err != nil {
+			fileWriter.Close()
+			return nil, errors.Wrap(err, "failed to initialize compressor")
+		}
 	}
 
 	return &Writer{
-		file: fileWriter,
-		gzip: gzipWriter,
+		file:       fileWriter,
+		compressor: compressor,
 	}, nil
 }
 
-func (self *Secret) serializeTags() ([]byte, error) {
+// Public tags plus, if any, encrypted private tags.
+func (self *Secret) getHeader() *metadata {
+	result := &metadata{
+		Generator:   fmt.Sprintf("Generated by PGP Tomb %s", config.GetVersion()),
+		Tags:        make(map[string]string),
+		PrivateTags: self.encryptedPrivateTags,
+	}
+	for _, tag := range self.tags {
+		if !config.IsPrivateTag(tag.Name) {
+			result.Tags[tag.Name] = tag.Value
+		}
+	}
+	return result
+}
+
+func serializeVersion1Header(header *metadata) ([]byte, error) {
 	tagsMap := make(map[string]string)
-	for _, tag := range self.GetTags() {
-		tagsMap[tag.Name] = tag.Value
+	for name, value := range header.Tags {
+		tagsMap[name] = value
+	}
+	if len(header.PrivateTags) > 0 {
+		tagsMap[privateTagsKey] = base64.StdEncoding.EncodeToString(header.PrivateTags)
+	}
+	return json.Marshal(tagsMap)
+}
+
+// Returns nil when serializing private tags and there are none.
+func (self *Secret) serializeTags(private bool) ([]byte, error) {
+	tagsMap := make(map[string]string)
+	for _, tag := range self.tags {
+		if config.IsPrivateTag(tag.Name) == private {
+			tagsMap[tag.Name] = tag.Value
+		}
+	}
+	if private && len(tagsMap) == 0 {
+		return nil, nil
 	}
 	return json.Marshal(tagsMap)
 }
@@ -0,0 +1,104 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/carlosabalde/pgp-tomb/internal/core/config"
+	"github.com/carlosabalde/pgp-tomb/internal/helpers/backend"
+	"github.com/carlosabalde/pgp-tomb/internal/helpers/pgp"
+)
+
+func ConfigSign(file string) {
+	// Determine signer.
+	var signer *backend.PublicKey
+	if privateKey := config.GetPrivateKey(); privateKey != nil {
+		fingerprint := fmt.Sprintf("%X", privateKey.Entity.PrimaryKey.Fingerprint)
+		for _, key := range config.GetPublicKeys() {
+			if key.PGP != nil && key.PGP.GetFingerprint() == fingerprint {
+				signer = key
+				break
+			}
+		}
+	} else {
+		signer = config.GetIdentity()
+	}
+	if signer == nil || signer.PGP == nil {
+		fmt.Fprintln(os.Stderr, "Unable to determine signer! Use --identity or --key.")
+		os.Exit(1)
+	}
+	if !isKeeper(signer) {
+		fmt.Fprintln(os.Stderr, "Only keepers can sign the configuration!")
+		os.Exit(1)
+	}
+
+	// Build manifest.
+	manifest, err := config.BuildManifest(file)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err,
+		}).Fatal("Failed to build manifest!")
+	}
+
+	// Sign manifest.
+	var signature bytes.Buffer
+	if privateKey := config.GetPrivateKey(); privateKey != nil {
+		err = pgp.Sign(config.GetPassphrasePrompt(), bytes.NewReader(manifest), &signature, privateKey)
+	} else {
+		err = pgp.SignWithGPG(config.GetGPG(), signer.PGP.GetFingerprint(), bytes.NewReader(manifest), &signature)
+	}
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err,
+		}).Fatal("Failed to sign manifest!")
+	}
+
+	// Keep still valid signatures made by other keepers. Signatures over a
+	// previous manifest are discarded.
+	signatures := make([]byte, 0)
+	signers := []string{signer.Alias}
+	if current, err := ioutil.ReadFile(config.GetSignatureFile()); err == nil {
+		for _, aSignature := range pgp.SplitSignatures(current) {
+			keys, err := pgp.VerifySignatures(aSignature, manifest, config.GetKeeperPGPKeys())
+			if err == nil && len(keys) == 1 && keys[0] != signer.PGP {
+				signatures = append(signatures, aSignature...)
+				signers = append(signers, keys[0].Alias)
+			}
+		}
+	}
+	signatures = append(signatures, signature.Bytes()...)
+
+	// Store signatures.
+	if err := ioutil.WriteFile(config.GetSignatureFile(), signatures, 0644); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err,
+			"file":  config.GetSignatureFile(),
+		}).Fatal("Failed to store signature!")
+	}
+
+	// Done!
+	fmt.Printf(
+		"Done! Configuration signed by %s (%d of %d required signatures).\n",
+		strings.Join(signers, ", "), len(signers), config.GetSignatureQuorum())
+}
+
+func ConfigVerify() {
+	trusted := make([]string, 0)
+	for _, key := range config.GetTrustedKeeperPGPKeys() {
+		trusted = append(trusted, key.Alias)
+	}
+	signers := config.GetSigners()
+	fmt.Printf("Trusted keepers: %s\n", joinOrDash(trusted))
+	fmt.Printf("Signers: %s\n", joinOrDash(signers))
+	fmt.Printf("Quorum: %d\n", config.GetSignatureQuorum())
+	if len(signers) < config.GetSignatureQuorum() {
+		fmt.Fprintln(os.Stderr, "Configuration signature not verified!")
+		os.Exit(1)
+	}
+	fmt.Println("Done! Configuration signature verified.")
+}
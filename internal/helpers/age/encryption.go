@@ -0,0 +1,159 @@
+package age
+
+import (
+	"bufio"
+	"io"
+	"strings"
+
+	"filippo.io/age"
+	"github.com/pkg/errors"
+
+	"github.com/carlosabalde/pgp-tomb/internal/helpers/passphrase"
+)
+
+const intro = "age-encryption.org/v1"
+
+// Type of the header stanzas listing recipients of a message. X25519 stanzas
+// are anonymous, so an additional stanza including the fingerprint of each
+// recipient is added. Stanzas of unknown types are ignored by age identities,
+// and all stanzas are authenticated by the header MAC.
+const recipientStanzaType = "pgp-tomb-recipient"
+
+// Max. length of lines encoding the body of a stanza.
+const stanzaColumnsPerLine = 64
+
+type labeledRecipient struct {
+	recipient   age.Recipient
+	fingerprint string
+}
+
+func (self labeledRecipient) Wrap(fileKey []byte) ([]*age.Stanza, error) {
+	stanzas, err := self.recipient.Wrap(fileKey)
+	if err != nil {
+		return nil, err
+	}
+	return append(stanzas, &age.Stanza{
+		Type: recipientStanzaType,
+		Args: []string{self.fingerprint},
+	}), nil
+}
+
+// 'fileName' is stored in the first line of the encrypted payload, so it
+// cannot include line breaks.
+func Encrypt(input io.Reader, output io.Writer, keys []*PublicKey, fileName string) error {
+	if strings.ContainsAny(fileName, "\r\n") {
+		return errors.New("invalid file name")
+	}
+
+	recipients := make([]age.Recipient, 0, len(keys))
+	for _, key := range keys {
+		recipients = append(recipients, labeledRecipient{key.Recipient, key.GetFingerprint()})
+	}
+
+	plain, err := age.Encrypt(output, recipients...)
+	if err != nil {
+		return errors.Wrap(err, "age encryption failed")
+	}
+
+	if _, err := io.WriteString(plain, fileName+"\n"); err != nil {
+		plain.Close()
+		return errors.Wrap(err, "age encryption failed")
+	}
+
+	if _, err := io.Copy(plain, input); err != nil {
+		plain.Close()
+		return errors.Wrap(err, "age encryption failed")
+	}
+
+	if err := plain.Close(); err != nil {
+		return errors.Wrap(err, "age encryption failed")
+	}
+
+	return nil
+}
+
+// Returns the file name stored in the encrypted payload. 'prompt' is only
+// used if the private key needs to be unlocked.
+func Decrypt(input io.Reader, output io.Writer, key *PrivateKey, prompt passphrase.Prompt) (string, error) {
+	identities, err := key.unlock(prompt)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to unlock private key")
+	}
+
+	plain, err := age.Decrypt(input, identities...)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to read message")
+	}
+
+	reader := bufio.NewReader(plain)
+	fileName, err := reader.ReadString('\n')
+	if err != nil {
+		return "", errors.Wrap(err, "failed to read file name")
+	}
+
+	if _, err := io.Copy(output, reader); err != nil {
+		return "", errors.Wrap(err, "failed to copy decrypted message")
+	}
+
+	return strings.TrimSuffix(fileName, "\n"), nil
+}
+
+// Checks if a Decrypt() failure means the private key is not a recipient of
+// the message.
+func IsNotRecipient(err error) bool {
+	_, ok := errors.Cause(err).(*age.NoIdentityMatchError)
+	return ok
+}
+
+// Extracts fingerprints of recipients from the header of an encrypted message.
+// Messages not written by Encrypt() don't include them.
+func GetRecipientFingerprints(input io.Reader) ([]string, error) {
+	result := make([]string, 0)
+
+	reader := bufio.NewReader(input)
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read header")
+	}
+	if strings.TrimSuffix(line, "\n") != intro {
+		return nil, errors.New("invalid header")
+	}
+
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read header")
+		}
+
+		if strings.HasPrefix(line, "---") {
+			return result, nil
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[0] != "->" {
+			return nil, errors.New("malformed stanza")
+		}
+		if fields[1] == recipientStanzaType && len(fields) == 3 {
+			result = append(result, fields[2])
+		}
+
+		// Skip body: full lines are followed by a final short (possibly
+		// empty) line.
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to read header")
+			}
+			if len(strings.TrimSuffix(line, "\n")) < stanzaColumnsPerLine {
+				break
+			}
+		}
+	}
+}
+
+// Checks if 'input' starts with the header of an age encrypted message. No
+// input is consumed.
+func IsEncryptedMessage(input *bufio.Reader) bool {
+	prefix, err := input.Peek(len(intro) + 1)
+	return err == nil && string(prefix) == intro+"\n"
+}
@@ -0,0 +1,85 @@
+package age
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncrypt(t *testing.T) {
+	keys := []*PublicKey{loadTestPublicKey(t, "ivan"), loadTestPublicKey(t, "judy")}
+
+	var encrypted bytes.Buffer
+	if err := Encrypt(strings.NewReader("foo\nbar"), &encrypted, keys, "baz"); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.True(t, IsEncryptedMessage(bufio.NewReader(bytes.NewReader(encrypted.Bytes()))))
+	if fingerprints, err := GetRecipientFingerprints(bytes.NewReader(encrypted.Bytes())); assert.NoError(t, err) {
+		assert.Equal(t, []string{keys[0].GetFingerprint(), keys[1].GetFingerprint()}, fingerprints)
+	}
+
+	// Native X25519 identity.
+	var decrypted bytes.Buffer
+	fileName, err := Decrypt(bytes.NewReader(encrypted.Bytes()), &decrypted, loadTestPrivateKey(t, "judy"), nil)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "baz", fileName)
+		assert.Equal(t, "foo\nbar", decrypted.String())
+	}
+
+	// Passphrase protected SSH key, unlocked after a wrong passphrase.
+	retries := 0
+	prompt := func(key string, retry bool) ([]byte, error) {
+		assert.True(t, strings.HasPrefix(key, "SHA256:"))
+		if retry {
+			retries++
+			return []byte("s3cr3t"), nil
+		}
+		return []byte("foo"), nil
+	}
+	decrypted.Reset()
+	fileName, err = Decrypt(bytes.NewReader(encrypted.Bytes()), &decrypted, loadTestPrivateKey(t, "ivan"), prompt)
+	if assert.NoError(t, err) {
+		assert.Equal(t, 1, retries)
+		assert.Equal(t, "baz", fileName)
+		assert.Equal(t, "foo\nbar", decrypted.String())
+	}
+
+	// Cancelled prompt.
+	_, err = Decrypt(bytes.NewReader(encrypted.Bytes()), &decrypted, loadTestPrivateKey(t, "ivan"),
+		func(key string, retry bool) ([]byte, error) { return nil, nil })
+	assert.Error(t, err)
+
+	// Not a recipient.
+	var other bytes.Buffer
+	if err := Encrypt(strings.NewReader("foo"), &other, keys[:1], ""); err != nil {
+		t.Fatal(err)
+	}
+	_, err = Decrypt(bytes.NewReader(other.Bytes()), &decrypted, loadTestPrivateKey(t, "judy"), nil)
+	assert.Error(t, err)
+
+	// File names cannot include line breaks.
+	assert.Error(t, Encrypt(strings.NewReader("foo"), &other, keys, "foo\nbar"))
+}
+
+func TestGetRecipientFingerprints(t *testing.T) {
+	// Messages not written by Encrypt() (e.g. using the age CLI) don't include
+	// fingerprints. Bodies longer than a line must be skipped.
+	header := "age-encryption.org/v1\n" +
+		"-> X25519 c3RhbnphCg\n" +
+		strings.Repeat("A", 64) + "\n" +
+		"AAAA\n" +
+		"-> pgp-tomb-recipient foo\n" +
+		"\n" +
+		"--- bWFjCg\n"
+	if fingerprints, err := GetRecipientFingerprints(strings.NewReader(header)); assert.NoError(t, err) {
+		assert.Equal(t, []string{"foo"}, fingerprints)
+	}
+
+	_, err := GetRecipientFingerprints(strings.NewReader("foo\n"))
+	assert.Error(t, err)
+	assert.False(t, IsEncryptedMessage(bufio.NewReader(strings.NewReader("foo"))))
+}
@@ -0,0 +1,199 @@
+package age
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+
+	"filippo.io/age"
+	"filippo.io/age/agessh"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/carlosabalde/pgp-tomb/internal/helpers/passphrase"
+)
+
+const (
+	TypeX25519     = "x25519"
+	TypeSSHEd25519 = "ssh-ed25519"
+)
+
+// Public key of a recipient: either a native X25519 recipient ('age1...') or
+// an SSH Ed25519 public key ('ssh-ed25519 ...').
+type PublicKey struct {
+	Alias     string
+	Recipient age.Recipient
+	value     string
+	keyType   string
+}
+
+// Identities used to decrypt messages: native X25519 identities or an SSH
+// Ed25519 private key. Passphrase protected SSH keys are unlocked the first
+// time they are needed.
+type PrivateKey struct {
+	identities  []age.Identity
+	encrypted   []byte
+	fingerprint string
+	mutex       *sync.Mutex
+}
+
+// Loads a public key file containing a single recipient. Empty lines and
+// comments (i.e. lines starting with '#') are ignored.
+func LoadPublicKey(alias string, input io.Reader) (PublicKey, error) {
+	values := make([]string, 0)
+	scanner := bufio.NewScanner(input)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			values = append(values, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return PublicKey{}, errors.Wrapf(err, "failed to read key '%s'", alias)
+	}
+	if len(values) != 1 {
+		return PublicKey{}, errors.Errorf("expected a single recipient in key '%s'", alias)
+	}
+
+	value := values[0]
+	switch {
+	case strings.HasPrefix(value, "age1"):
+		recipient, err := age.ParseX25519Recipient(value)
+		if err != nil {
+			return PublicKey{}, errors.Wrapf(err, "invalid public key '%s'", alias)
+		}
+		return PublicKey{alias, recipient, recipient.String(), TypeX25519}, nil
+	case strings.HasPrefix(value, TypeSSHEd25519+" "):
+		recipient, err := agessh.ParseRecipient(value)
+		if err != nil {
+			return PublicKey{}, errors.Wrapf(err, "invalid public key '%s'", alias)
+		}
+		return PublicKey{alias, recipient, value, TypeSSHEd25519}, nil
+	}
+
+	return PublicKey{}, errors.Errorf("unsupported public key '%s'", alias)
+}
+
+// X25519 recipients are identified by themselves, while SSH keys are
+// identified by their SHA-256 fingerprint (as reported by 'ssh-keygen -l').
+func (self *PublicKey) GetFingerprint() string {
+	if self.keyType == TypeSSHEd25519 {
+		if key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(self.value)); err == nil {
+			return ssh.FingerprintSHA256(key)
+		}
+	}
+	return self.value
+}
+
+func (self *PublicKey) GetType() string {
+	return self.keyType
+}
+
+// Returns the recipient as found in the public key file.
+func (self *PublicKey) String() string {
+	return self.value
+}
+
+// Loads an age identity file (one or more 'AGE-SECRET-KEY-1...' lines) or an
+// SSH Ed25519 private key (OpenSSH format).
+func LoadPrivateKey(input io.Reader) (PrivateKey, error) {
+	data, err := ioutil.ReadAll(input)
+	if err != nil {
+		return PrivateKey{}, errors.Wrap(err, "failed to read private key")
+	}
+
+	result := PrivateKey{
+		identities: make([]age.Identity, 0),
+		mutex:      &sync.Mutex{},
+	}
+
+	if !bytes.Contains(data, []byte("-----BEGIN")) {
+		identities, err := age.ParseIdentities(bytes.NewReader(data))
+		if err != nil {
+			return PrivateKey{}, errors.Wrap(err, "failed to parse age identities")
+		}
+		result.identities = identities
+		return result, nil
+	}
+
+	identity, err := agessh.ParseIdentity(data)
+	if missing, ok := err.(*ssh.PassphraseMissingError); ok {
+		result.encrypted = data
+		if missing.PublicKey != nil {
+			result.fingerprint = ssh.FingerprintSHA256(missing.PublicKey)
+		}
+	} else if err != nil {
+		return PrivateKey{}, errors.Wrap(err, "failed to parse SSH private key")
+	} else if _, ok := identity.(*agessh.Ed25519Identity); !ok {
+		return PrivateKey{}, errors.New("unsupported SSH private key")
+	} else {
+		result.identities = append(result.identities, identity)
+	}
+
+	return result, nil
+}
+
+// Returns true if the SSH private key is still passphrase protected.
+func (self *PrivateKey) IsLocked() bool {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	return self.encrypted != nil
+}
+
+// Decrypts (if needed) the SSH private key, so failures to unlock it can be
+// told apart from failures to decrypt messages.
+func (self *PrivateKey) Unlock(prompt passphrase.Prompt) error {
+	_, err := self.unlock(prompt)
+	return err
+}
+
+// Decrypts (if needed) the SSH private key.
+func (self *PrivateKey) unlock(prompt passphrase.Prompt) ([]age.Identity, error) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	retry := false
+	for i := 0; i < 3 && self.encrypted != nil; i++ {
+		if prompt == nil {
+			return nil, errors.New("no passphrase for private key")
+		}
+		value, err := prompt(self.fingerprint, retry)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to get passphrase for private key")
+		}
+		if value == nil {
+			return nil, errors.New("no passphrase for private key")
+		}
+		key, err := ssh.ParseRawPrivateKeyWithPassphrase(self.encrypted, value)
+		if err != nil {
+			retry = true
+			continue
+		}
+		identity, err := newSSHIdentity(key)
+		if err != nil {
+			return nil, err
+		}
+		self.identities = append(self.identities, identity)
+		self.encrypted = nil
+	}
+
+	if self.encrypted != nil {
+		return nil, errors.New("failed to decrypt private key")
+	}
+
+	return self.identities, nil
+}
+
+func newSSHIdentity(key interface{}) (age.Identity, error) {
+	switch key := key.(type) {
+	case *ed25519.PrivateKey:
+		return agessh.NewEd25519Identity(*key)
+	case ed25519.PrivateKey:
+		return agessh.NewEd25519Identity(key)
+	}
+	return nil, errors.New("unsupported SSH private key")
+}
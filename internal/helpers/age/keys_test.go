@@ -0,0 +1,71 @@
+package age
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func loadTestPublicKey(t *testing.T, alias string) *PublicKey {
+	input, err := os.Open("../../../files/keys/" + alias + ".age")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer input.Close()
+
+	key, err := LoadPublicKey(alias, input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &key
+}
+
+func loadTestPrivateKey(t *testing.T, alias string) *PrivateKey {
+	input, err := os.Open("../../../files/keys/" + alias + ".pri")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer input.Close()
+
+	key, err := LoadPrivateKey(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &key
+}
+
+func TestLoadPublicKey(t *testing.T) {
+	judy := loadTestPublicKey(t, "judy")
+	assert.Equal(t, TypeX25519, judy.GetType())
+	assert.Equal(t, "age14df0e0hm9psa5fm2vvmqc6j3lh8adjl6m0thdslk9e3t3n7qzvaq9c8w6w", judy.GetFingerprint())
+
+	ivan := loadTestPublicKey(t, "ivan")
+	assert.Equal(t, TypeSSHEd25519, ivan.GetType())
+	assert.Equal(t, "SHA256:6xdDXYZQ+5QRyVErq0gkbA22ro1ZZ6ZRYIJr9iiuESQ", ivan.GetFingerprint())
+
+	for _, value := range []string{
+		"",
+		"foo",
+		"age1foo",
+		"ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAAAgQC7",
+		judy.String() + "\n" + judy.String(),
+	} {
+		_, err := LoadPublicKey("mallory", strings.NewReader(value))
+		assert.Error(t, err, value)
+	}
+}
+
+func TestLoadPrivateKey(t *testing.T) {
+	if key := loadTestPrivateKey(t, "judy"); assert.Nil(t, key.encrypted) {
+		assert.Len(t, key.identities, 1)
+	}
+
+	if key := loadTestPrivateKey(t, "ivan"); assert.NotNil(t, key.encrypted) {
+		assert.Empty(t, key.identities)
+	}
+
+	_, err := LoadPrivateKey(strings.NewReader("AGE-SECRET-KEY-1FOO"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,70 @@
+package agent
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	commandStatus = "status"
+	commandLock   = "lock"
+	commandUnwrap = "unwrap"
+)
+
+// A single request is sent per connection, followed by a single response.
+type request struct {
+	Command string `json:"command"`
+	Message []byte `json:"message,omitempty"`
+}
+
+type response struct {
+	Error       string    `json:"error,omitempty"`
+	Reason      string    `json:"reason,omitempty"`
+	Fingerprint string    `json:"fingerprint,omitempty"`
+	Expires     time.Time `json:"expires,omitempty"`
+	Unwrapped   []byte    `json:"unwrapped,omitempty"`
+	Cipher      string    `json:"cipher,omitempty"`
+	AEAD        string    `json:"aead,omitempty"`
+}
+
+type Status struct {
+	Fingerprint string
+	Expires     time.Time
+}
+
+// Default location of the agent socket: a private folder in the user's
+// runtime directory (or in the temporary directory if not available).
+func GetDefaultSocket() string {
+	folder := os.Getenv("XDG_RUNTIME_DIR")
+	if folder == "" {
+		folder = os.TempDir()
+	}
+	return filepath.Join(folder, fmt.Sprintf("pgp-tomb-%d", os.Getuid()), "agent.sock")
+}
+
+// The folder containing the socket must be owned by the current user and not
+// accessible by anyone else.
+func checkFolder(path string) error {
+	if errUnsupported != nil {
+		return errUnsupported
+	}
+
+	info, err := os.Stat(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+
+	if !info.IsDir() {
+		return errors.Errorf("'%s' is not a folder", filepath.Dir(path))
+	}
+
+	if !isPrivate(info) {
+		return errors.Errorf("insecure owner or permissions in folder '%s'", filepath.Dir(path))
+	}
+
+	return nil
+}
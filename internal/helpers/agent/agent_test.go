@@ -0,0 +1,139 @@
+package agent
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/carlosabalde/pgp-tomb/internal/helpers/pgp"
+)
+
+func loadTestKeys(t *testing.T) (*pgp.PrivateKey, []*pgp.PublicKey) {
+	input, err := os.Open("../../../files/keys/alice.pri")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer input.Close()
+	privateKey, err := pgp.LoadASCIIArmoredPrivateKey(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := privateKey.Entity.PrivateKey.Decrypt([]byte("s3cr3t")); err != nil {
+		t.Fatal(err)
+	}
+	for _, subkey := range privateKey.Entity.Subkeys {
+		if err := subkey.PrivateKey.Decrypt([]byte("s3cr3t")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	keys := make([]*pgp.PublicKey, 0)
+	for _, alias := range []string{"alice", "bob"} {
+		input, err := os.Open("../../../files/keys/" + alias + ".pub")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer input.Close()
+		key, err := pgp.LoadASCIIArmoredPublicKey(alias, input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, &key)
+	}
+
+	return &privateKey, keys
+}
+
+func TestAgent(t *testing.T) {
+	privateKey, keys := loadTestKeys(t)
+	folder, err := ioutil.TempDir("", "pgp-tomb-agent-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(folder)
+	path := filepath.Join(folder, "agent", "agent.sock")
+
+	server, err := Listen(path, privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error)
+	go func() {
+		served <- server.Serve(time.Minute)
+	}()
+
+	// Running agents are never replaced.
+	_, err = Listen(path, privateKey)
+	assert.Error(t, err)
+
+	client := NewClient(path)
+	assert.True(t, client.IsAvailable())
+	if status, err := client.Status(); assert.NoError(t, err) {
+		assert.Equal(t, keys[0].GetFingerprint(), status.Fingerprint)
+		assert.True(t, status.Expires.After(time.Now()))
+	}
+
+	// Messages are unwrapped by the agent, while signatures are checked by
+	// the client.
+	var encrypted bytes.Buffer
+	if err := pgp.Encrypt(bytes.NewReader([]byte("foo")), &encrypted, keys, privateKey, "bar", pgp.Settings{}); err != nil {
+		t.Fatal(err)
+	}
+	var unwrapped bytes.Buffer
+	if cipher, _, err := client.Unwrap(&encrypted, &unwrapped); assert.NoError(t, err) {
+		assert.NotEmpty(t, cipher)
+		var decrypted bytes.Buffer
+		if verification, err := pgp.DecryptUnwrapped(&unwrapped, &decrypted, keys); assert.NoError(t, err) {
+			assert.Equal(t, "foo", decrypted.String())
+			assert.Equal(t, pgp.SignatureValid, verification.State)
+		}
+	}
+
+	// Errors are reported to clients.
+	_, _, err = client.Unwrap(bytes.NewReader([]byte("foo")), ioutil.Discard)
+	assert.Error(t, err)
+
+	// Locking stops the agent & removes the socket.
+	assert.NoError(t, client.Lock())
+	assert.NoError(t, <-served)
+	assert.False(t, client.IsAvailable())
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestAgentTTL(t *testing.T) {
+	privateKey, _ := loadTestKeys(t)
+	folder, err := ioutil.TempDir("", "pgp-tomb-agent-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(folder)
+	path := filepath.Join(folder, "agent", "agent.sock")
+
+	server, err := Listen(path, privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, server.Serve(100*time.Millisecond))
+	assert.False(t, NewClient(path).IsAvailable())
+}
+
+func TestInsecureFolder(t *testing.T) {
+	privateKey, _ := loadTestKeys(t)
+	folder, err := ioutil.TempDir("", "pgp-tomb-agent-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(folder)
+	if err := os.Chmod(folder, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = Listen(filepath.Join(folder, "agent.sock"), privateKey)
+	assert.Error(t, err)
+}
@@ -0,0 +1,98 @@
+package agent
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/carlosabalde/pgp-tomb/internal/helpers/pgp"
+)
+
+const dialTimeout = 1 * time.Second
+
+type Client struct {
+	path string
+}
+
+func NewClient(path string) *Client {
+	return &Client{path}
+}
+
+// Checks if an agent is listening. Sockets in insecure folders are ignored.
+func (self *Client) IsAvailable() bool {
+	if _, err := os.Stat(self.path); err != nil {
+		return false
+	}
+	if err := checkFolder(self.path); err != nil {
+		return false
+	}
+	_, err := self.Status()
+	return err == nil
+}
+
+func (self *Client) Status() (Status, error) {
+	res, err := self.send(request{Command: commandStatus})
+	if err != nil {
+		return Status{}, err
+	}
+	return Status{
+		Fingerprint: res.Fingerprint,
+		Expires:     res.Expires,
+	}, nil
+}
+
+func (self *Client) Lock() error {
+	_, err := self.send(request{Command: commandLock})
+	return err
+}
+
+// See pgp.Unwrap().
+func (self *Client) Unwrap(input io.Reader, output io.Writer) (cipher, aead string, e error) {
+	message, err := ioutil.ReadAll(input)
+	if err != nil {
+		return "", "", errors.Wrap(err, "failed to read message")
+	}
+
+	res, err := self.send(request{Command: commandUnwrap, Message: message})
+	if err != nil {
+		return "", "", err
+	}
+
+	if _, err := output.Write(res.Unwrapped); err != nil {
+		return "", "", errors.Wrap(err, "failed to write unwrapped message")
+	}
+
+	return res.Cipher, res.AEAD, nil
+}
+
+func (self *Client) send(req request) (response, error) {
+	conn, err := net.DialTimeout("unix", self.path, dialTimeout)
+	if err != nil {
+		return response{}, errors.Wrap(err, "failed to connect to agent")
+	}
+	defer conn.Close()
+
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return response{}, errors.Wrap(err, "failed to send agent request")
+	}
+
+	var res response
+	if err := json.NewDecoder(conn).Decode(&res); err != nil {
+		return response{}, errors.Wrap(err, "failed to read agent response")
+	}
+
+	if res.Error != "" {
+		err := errors.Errorf("agent error: %s", res.Error)
+		if res.Reason != "" {
+			return response{}, &pgp.DecryptionError{Reason: res.Reason, Err: err}
+		}
+		return response{}, err
+	}
+
+	return res, nil
+}
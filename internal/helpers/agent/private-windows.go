@@ -0,0 +1,17 @@
+// +build windows
+
+package agent
+
+import (
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// Ownership & permissions of the socket folder (i.e. ACLs) are not checked on
+// Windows, so the agent is refused instead of claiming it is protected.
+var errUnsupported = errors.New("agent is not supported on Windows")
+
+func isPrivate(info os.FileInfo) bool {
+	return false
+}
@@ -0,0 +1,23 @@
+// +build !windows
+
+package agent
+
+import (
+	"os"
+	"syscall"
+)
+
+// The agent is supported on every platform but Windows.
+var errUnsupported error = nil
+
+// Checks the file is owned by the current user and not accessible by anyone
+// else.
+func isPrivate(info os.FileInfo) bool {
+	if info.Mode().Perm()&0077 != 0 {
+		return false
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return int(stat.Uid) == os.Getuid()
+	}
+	return false
+}
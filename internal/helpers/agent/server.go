@@ -0,0 +1,155 @@
+package agent
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/carlosabalde/pgp-tomb/internal/helpers/pgp"
+)
+
+// Holds an unlocked private key in memory, unwrapping messages on behalf of
+// clients (see pgp.Unwrap()) until the TTL expires or a client locks it. The
+// key never leaves the agent, and signatures are checked by clients.
+type Server struct {
+	listener net.Listener
+	done     chan struct{}
+
+	mutex   sync.Mutex
+	key     *pgp.PrivateKey
+	expires time.Time
+}
+
+// Creates the socket (and its private folder, if needed). Stale sockets left
+// behind by dead agents are replaced, but running agents are never hijacked.
+// 'key' must be already unlocked (see pgp.UnlockPrivateKey()).
+func Listen(path string, key *pgp.PrivateKey) (*Server, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, errors.Wrap(err, "failed to create socket folder")
+	}
+
+	if err := checkFolder(path); err != nil {
+		return nil, err
+	}
+
+	if _, err := os.Stat(path); err == nil {
+		if _, err := NewClient(path).Status(); err == nil {
+			return nil, errors.New("agent already running")
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, errors.Wrap(err, "failed to remove stale socket")
+		}
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create socket")
+	}
+
+	if err := os.Chmod(path, 0600); err != nil {
+		listener.Close()
+		return nil, errors.Wrap(err, "failed to set socket permissions")
+	}
+
+	return &Server{
+		listener: listener,
+		done:     make(chan struct{}),
+		key:      key,
+	}, nil
+}
+
+// Blocks until 'ttl' expires or the agent is locked. The socket is removed on
+// return.
+func (self *Server) Serve(ttl time.Duration) error {
+	self.mutex.Lock()
+	self.expires = time.Now().Add(ttl)
+	self.mutex.Unlock()
+
+	timer := time.AfterFunc(ttl, func() {
+		logrus.Info("Agent TTL expired")
+		self.Lock()
+	})
+	defer timer.Stop()
+
+	for {
+		conn, err := self.listener.Accept()
+		if err != nil {
+			select {
+			case <-self.done:
+				return nil
+			default:
+				return errors.Wrap(err, "failed to accept connection")
+			}
+		}
+		go self.handle(conn)
+	}
+}
+
+// Stops serving & forgets the private key.
+func (self *Server) Lock() {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	if self.key != nil {
+		self.key = nil
+		close(self.done)
+		self.listener.Close()
+	}
+}
+
+func (self *Server) handle(conn net.Conn) {
+	defer conn.Close()
+
+	var req request
+	if err := json.NewDecoder(conn).Decode(&req); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err,
+		}).Warn("Failed to read agent request!")
+		return
+	}
+
+	self.mutex.Lock()
+	key := self.key
+	res := response{Expires: self.expires}
+	self.mutex.Unlock()
+
+	if key != nil {
+		res.Fingerprint = fmt.Sprintf("%X", key.Entity.PrimaryKey.Fingerprint)
+		switch req.Command {
+		case commandStatus:
+		case commandLock:
+			defer self.Lock()
+		case commandUnwrap:
+			var unwrapped bytes.Buffer
+			cipher, aead, err := pgp.Unwrap(nil, bytes.NewReader(req.Message), &unwrapped, key)
+			if err != nil {
+				res.Error = err.Error()
+				if err, ok := err.(*pgp.DecryptionError); ok {
+					res.Reason = err.Reason
+				}
+			} else {
+				res.Unwrapped = unwrapped.Bytes()
+				res.Cipher = cipher
+				res.AEAD = aead
+			}
+		default:
+			res.Error = fmt.Sprintf("unknown command '%s'", req.Command)
+		}
+	} else {
+		res.Error = "agent is locked"
+	}
+
+	if err := json.NewEncoder(conn).Encode(res); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err,
+		}).Warn("Failed to write agent response!")
+	}
+}
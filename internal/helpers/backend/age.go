@@ -0,0 +1,76 @@
+package backend
+
+import (
+	"io"
+
+	"github.com/pkg/errors"
+
+	"github.com/carlosabalde/pgp-tomb/internal/helpers/age"
+	"github.com/carlosabalde/pgp-tomb/internal/helpers/passphrase"
+	"github.com/carlosabalde/pgp-tomb/internal/helpers/pgp"
+)
+
+// Name of the algorithm used by age to encrypt payloads.
+const ageCipher = "chacha20-poly1305"
+
+// age backend. Messages are not signed, and they can only be decrypted
+// natively using 'PrivateKey'. 'Prompt' is used to unlock passphrase
+// protected SSH keys.
+type AgeBackend struct {
+	PrivateKey *age.PrivateKey
+	Prompt     passphrase.Prompt
+}
+
+func (self *AgeBackend) GetName() string {
+	return Age
+}
+
+func (self *AgeBackend) Encrypt(input io.Reader, output io.Writer, keys []*PublicKey, fileName string) error {
+	ageKeys := make([]*age.PublicKey, 0, len(keys))
+	for _, key := range keys {
+		if key.Age == nil {
+			return errors.Errorf("key '%s' is not an age key", key.Alias)
+		}
+		ageKeys = append(ageKeys, key.Age)
+	}
+
+	return age.Encrypt(input, output, ageKeys, fileName)
+}
+
+func (self *AgeBackend) Decrypt(input io.Reader, output io.Writer) (Verification, error) {
+	if self.PrivateKey == nil {
+		return Verification{}, &pgp.DecryptionError{
+			Reason: pgp.FailureNoSecretKey, Err: errors.New("an age private key is required")}
+	}
+
+	if err := self.PrivateKey.Unlock(self.Prompt); err != nil {
+		return Verification{}, &pgp.DecryptionError{
+			Reason: pgp.FailurePassphrase, Err: errors.Wrap(err, "failed to unlock private key")}
+	}
+
+	fileName, err := age.Decrypt(input, output, self.PrivateKey, self.Prompt)
+	if err != nil {
+		reason := pgp.FailureCorrupt
+		if age.IsNotRecipient(err) {
+			reason = pgp.FailureNoSecretKey
+		}
+		return Verification{}, &pgp.DecryptionError{Reason: reason, Err: err}
+	}
+
+	return Verification{
+		State:    pgp.SignatureNone,
+		FileName: fileName,
+		Cipher:   ageCipher,
+	}, nil
+}
+
+func (self *AgeBackend) GetRecipientIds(input io.Reader) ([]string, error) {
+	return age.GetRecipientFingerprints(input)
+}
+
+func (self *AgeBackend) GetKeyIds(key *PublicKey) []string {
+	if key.Age != nil {
+		return []string{key.Age.GetFingerprint()}
+	}
+	return []string{}
+}
@@ -0,0 +1,86 @@
+package backend
+
+import (
+	"bufio"
+	"io"
+
+	"github.com/carlosabalde/pgp-tomb/internal/helpers/age"
+	"github.com/carlosabalde/pgp-tomb/internal/helpers/pgp"
+)
+
+const (
+	PGP = "pgp"
+	Age = "age"
+)
+
+// Public keys of a recipient. A recipient may have a PGP public key, an age
+// public key, or both. Only the key matching the backend used to encrypt a
+// secret is used.
+type PublicKey struct {
+	Alias string
+	PGP   *pgp.PublicKey
+	Age   *age.PublicKey
+}
+
+// Fingerprint of the PGP public key if available; otherwise fingerprint of
+// the age public key.
+func (self *PublicKey) GetFingerprint() string {
+	if self.PGP != nil {
+		return self.PGP.GetFingerprint()
+	}
+	if self.Age != nil {
+		return self.Age.GetFingerprint()
+	}
+	return ""
+}
+
+// Checks if the recipient has a public key usable by the backend 'name'.
+func (self *PublicKey) HasKeyFor(name string) bool {
+	switch name {
+	case PGP:
+		return self.PGP != nil
+	case Age:
+		return self.Age != nil
+	}
+	return false
+}
+
+// Result of decrypting a message. Only the PGP backend signs messages, so
+// other backends always return 'pgp.SignatureNone'.
+type Verification = pgp.Verification
+
+type Backend interface {
+	GetName() string
+
+	// 'fileName' is stored in the encrypted payload, and returned in the
+	// verification when decrypting the message.
+	Encrypt(input io.Reader, output io.Writer, keys []*PublicKey, fileName string) error
+
+	Decrypt(input io.Reader, output io.Writer) (Verification, error)
+
+	// Identifiers of recipients of an encrypted message.
+	GetRecipientIds(input io.Reader) ([]string, error)
+
+	// Identifiers of 'key' that may be returned by GetRecipientIds(). Empty if
+	// the key cannot be used by the backend.
+	GetKeyIds(key *PublicKey) []string
+}
+
+// Detects the backend used to encrypt a message without consuming any input.
+func Detect(input *bufio.Reader) string {
+	if age.IsEncryptedMessage(input) {
+		return Age
+	}
+	return PGP
+}
+
+// Returns aliases of keys that cannot be used by 'backend'.
+func GetUnsupportedKeys(backend Backend, keys []*PublicKey) []string {
+	result := make([]string, 0)
+	for _, key := range keys {
+		if len(backend.GetKeyIds(key)) == 0 {
+			result = append(result, key.Alias)
+		}
+	}
+	return result
+}
@@ -0,0 +1,141 @@
+package backend
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/carlosabalde/pgp-tomb/internal/helpers/age"
+	"github.com/carlosabalde/pgp-tomb/internal/helpers/pgp"
+)
+
+func loadTestPublicKey(t *testing.T, alias string) *PublicKey {
+	result := &PublicKey{Alias: alias}
+
+	if file, err := os.Open("../../../files/keys/" + alias + ".pub"); err == nil {
+		defer file.Close()
+		key, err := pgp.LoadASCIIArmoredPublicKey(alias, file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		result.PGP = &key
+	}
+
+	if file, err := os.Open("../../../files/keys/" + alias + ".age"); err == nil {
+		defer file.Close()
+		key, err := age.LoadPublicKey(alias, file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		result.Age = &key
+	}
+
+	return result
+}
+
+func TestGetUnsupportedKeys(t *testing.T) {
+	keys := []*PublicKey{
+		loadTestPublicKey(t, "alice"),
+		loadTestPublicKey(t, "ivan"),
+		loadTestPublicKey(t, "judy"),
+	}
+
+	assert.Equal(t, []string{"ivan", "judy"}, GetUnsupportedKeys(&PGPBackend{}, keys))
+	assert.Equal(t, []string{"alice"}, GetUnsupportedKeys(&AgeBackend{}, keys))
+}
+
+func TestAgeBackend(t *testing.T) {
+	alice := loadTestPublicKey(t, "alice")
+	judy := loadTestPublicKey(t, "judy")
+	b := &AgeBackend{}
+
+	// Keys without an age public key are rejected.
+	var encrypted bytes.Buffer
+	assert.Error(t, b.Encrypt(strings.NewReader("foo"), &encrypted, []*PublicKey{alice, judy}, "bar"))
+
+	encrypted.Reset()
+	if err := b.Encrypt(strings.NewReader("foo"), &encrypted, []*PublicKey{judy}, "bar"); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, Age, Detect(bufio.NewReader(bytes.NewReader(encrypted.Bytes()))))
+	if ids, err := b.GetRecipientIds(bytes.NewReader(encrypted.Bytes())); assert.NoError(t, err) {
+		assert.Equal(t, b.GetKeyIds(judy), ids)
+	}
+
+	// A private key is required to decrypt.
+	_, err := b.Decrypt(bytes.NewReader(encrypted.Bytes()), &bytes.Buffer{})
+	assert.Error(t, err)
+}
+
+func TestPGPBackend(t *testing.T) {
+	alice := loadTestPublicKey(t, "alice")
+	b := &PGPBackend{}
+
+	var encrypted bytes.Buffer
+	if err := b.Encrypt(strings.NewReader("foo"), &encrypted, []*PublicKey{alice}, "bar"); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, PGP, Detect(bufio.NewReader(bytes.NewReader(encrypted.Bytes()))))
+	if ids, err := b.GetRecipientIds(bytes.NewReader(encrypted.Bytes())); assert.NoError(t, err) {
+		assert.Len(t, ids, 1)
+		assert.Contains(t, b.GetKeyIds(alice), ids[0])
+	}
+}
+
+func loadTestPrivateKey(t *testing.T, alias string) *pgp.PrivateKey {
+	file, err := os.Open("../../../files/keys/" + alias + ".pri")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	key, err := pgp.LoadASCIIArmoredPrivateKey(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &key
+}
+
+func TestPGPBackendWithSeveralPrivateKeys(t *testing.T) {
+	alice := loadTestPublicKey(t, "alice")
+	bob := loadTestPublicKey(t, "bob")
+
+	// Only private keys that are recipients are unlocked.
+	prompts := 0
+	b := &PGPBackend{
+		Prompt: func(key string, retry bool) ([]byte, error) {
+			prompts++
+			return []byte("s3cr3t"), nil
+		},
+		PrivateKeys: []*pgp.PrivateKey{
+			loadTestPrivateKey(t, "chuck"),
+			loadTestPrivateKey(t, "alice"),
+		},
+	}
+
+	var encrypted bytes.Buffer
+	if err := b.Encrypt(strings.NewReader("foo"), &encrypted, []*PublicKey{alice}, "bar"); err != nil {
+		t.Fatal(err)
+	}
+	var decrypted bytes.Buffer
+	if _, err := b.Decrypt(bytes.NewReader(encrypted.Bytes()), &decrypted); assert.NoError(t, err) {
+		assert.Equal(t, "foo", decrypted.String())
+		assert.Equal(t, 1, prompts)
+	}
+
+	encrypted.Reset()
+	if err := b.Encrypt(strings.NewReader("foo"), &encrypted, []*PublicKey{bob}, "bar"); err != nil {
+		t.Fatal(err)
+	}
+	_, err := b.Decrypt(bytes.NewReader(encrypted.Bytes()), ioutil.Discard)
+	if assert.IsType(t, &pgp.DecryptionError{}, err) {
+		assert.Equal(t, pgp.FailureNoSecretKey, err.(*pgp.DecryptionError).Reason)
+	}
+}
@@ -0,0 +1,164 @@
+package backend
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/pkg/errors"
+
+	"github.com/carlosabalde/pgp-tomb/internal/helpers/agent"
+	"github.com/carlosabalde/pgp-tomb/internal/helpers/passphrase"
+	"github.com/carlosabalde/pgp-tomb/internal/helpers/pgp"
+)
+
+// OpenPGP backend. Messages are decrypted using 'PrivateKeys' (trying those
+// that are recipients of the message) or, when not provided, using GPG. When
+// available, 'Agent' is preferred for decryption.
+type PGPBackend struct {
+	GPG         string
+	Prompt      passphrase.Prompt
+	PrivateKeys []*pgp.PrivateKey
+	Agent       *agent.Client
+
+	// Public keys used to check signatures.
+	Keys []*pgp.PublicKey
+
+	// When signing using the first private key it must be already unlocked
+	// (see pgp.UnlockPrivateKey()). Otherwise GPG signs using the key identified by
+	// 'SignerFingerprint', and public keys of recipients are read from files
+	// returned by 'KeyFile'.
+	Sign              bool
+	SignerFingerprint string
+	KeyFile           func(alias string) string
+
+	Settings pgp.Settings
+}
+
+func (self *PGPBackend) GetName() string {
+	return PGP
+}
+
+func (self *PGPBackend) Encrypt(input io.Reader, output io.Writer, keys []*PublicKey, fileName string) error {
+	pgpKeys := make([]*pgp.PublicKey, 0, len(keys))
+	for _, key := range keys {
+		if key.PGP == nil {
+			return errors.Errorf("key '%s' is not a PGP key", key.Alias)
+		}
+		pgpKeys = append(pgpKeys, key.PGP)
+	}
+
+	if !self.Sign {
+		return pgp.Encrypt(input, output, pgpKeys, nil, fileName, self.Settings)
+	} else if len(self.PrivateKeys) > 0 {
+		return pgp.Encrypt(input, output, pgpKeys, self.PrivateKeys[0], fileName, self.Settings)
+	}
+
+	files := make([]string, 0, len(keys))
+	for _, key := range keys {
+		files = append(files, self.KeyFile(key.Alias))
+	}
+	return pgp.EncryptAndSignWithGPG(
+		self.GPG, input, output, files, self.SignerFingerprint, fileName, self.Settings)
+}
+
+func (self *PGPBackend) Decrypt(input io.Reader, output io.Writer) (Verification, error) {
+	if self.Agent != nil {
+		encrypted, err := ioutil.ReadAll(input)
+		if err != nil {
+			return Verification{}, &pgp.DecryptionError{
+				Reason: pgp.FailureCorrupt, Err: errors.Wrap(err, "failed to read message")}
+		}
+
+		unwrapped := new(bytes.Buffer)
+		cipher, aead, err := self.Agent.Unwrap(bytes.NewReader(encrypted), unwrapped)
+		if err == nil {
+			result, err := pgp.DecryptUnwrapped(unwrapped, output, self.Keys)
+			if err != nil {
+				return Verification{}, err
+			}
+			result.Cipher = cipher
+			result.AEAD = aead
+			return result, nil
+		}
+
+		// Fall back to local decryption if the agent is gone (e.g. its TTL
+		// expired while running) or if it doesn't hold a recipient key.
+		if self.Agent.IsAvailable() && !isNoSecretKey(err) {
+			return Verification{}, err
+		}
+		input = bytes.NewReader(encrypted)
+	}
+
+	if len(self.PrivateKeys) > 0 {
+		return self.decryptWithPrivateKeys(input, output)
+	}
+	return pgp.DecryptWithGPG(self.GPG, input, output, self.Keys)
+}
+
+// Tries private keys in order. Keys that are not recipients are skipped
+// without asking for passphrases, while wrong passphrases move on to the
+// next key. The first relevant failure is reported.
+func (self *PGPBackend) decryptWithPrivateKeys(input io.Reader, output io.Writer) (Verification, error) {
+	encrypted, err := ioutil.ReadAll(input)
+	if err != nil {
+		return Verification{}, &pgp.DecryptionError{
+			Reason: pgp.FailureCorrupt, Err: errors.Wrap(err, "failed to read message")}
+	}
+
+	var result error
+	for _, key := range self.PrivateKeys {
+		decrypted := new(bytes.Buffer)
+		verification, err := pgp.Decrypt(self.Prompt, bytes.NewReader(encrypted), decrypted, key, self.Keys)
+		if err == nil {
+			if _, err := io.Copy(output, decrypted); err != nil {
+				return Verification{}, errors.Wrap(err, "failed to copy decrypted message")
+			}
+			return verification, nil
+		}
+
+		if result == nil || (isNoSecretKey(result) && !isNoSecretKey(err)) {
+			result = err
+		}
+		if anError, ok := err.(*pgp.DecryptionError); ok && anError.Reason == pgp.FailureCorrupt {
+			break
+		}
+	}
+
+	return Verification{}, result
+}
+
+func isNoSecretKey(err error) bool {
+	anError, ok := err.(*pgp.DecryptionError)
+	return ok && anError.Reason == pgp.FailureNoSecretKey
+}
+
+func (self *PGPBackend) GetRecipientIds(input io.Reader) ([]string, error) {
+	ids, err := pgp.GetRecipientKeyIdsForEncryptedMessage(input)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]string, 0, len(ids))
+	for _, id := range ids {
+		result = append(result, formatKeyId(id))
+	}
+	return result, nil
+}
+
+// Primary key & subkeys IDs.
+func (self *PGPBackend) GetKeyIds(key *PublicKey) []string {
+	result := make([]string, 0)
+	if key.PGP != nil {
+		result = append(result, formatKeyId(key.PGP.Entity.PrimaryKey.KeyId))
+		for _, subkey := range key.PGP.Entity.Subkeys {
+			result = append(result, formatKeyId(subkey.PublicKey.KeyId))
+		}
+	}
+	return result
+}
+
+func formatKeyId(id uint64) string {
+	return fmt.Sprintf("0x%x", id)
+}
@@ -0,0 +1,211 @@
+package passphrase
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Returns the passphrase of the private key identified by 'key' (e.g. its
+// fingerprint). 'retry' is set when the previously returned passphrase was
+// wrong. Returns nil if the prompt was cancelled.
+type Prompt func(key string, retry bool) ([]byte, error)
+
+// Passphrases are never requested concurrently (e.g. from several workers).
+var mutex = &sync.Mutex{}
+
+func serialize(prompt Prompt) Prompt {
+	return func(key string, retry bool) ([]byte, error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		return prompt(key, retry)
+	}
+}
+
+// Non-interactive sources always return the same passphrase, no matter the
+// key, so retrying is pointless: a wrong passphrase is reported as an error
+// instead.
+func nonInteractive(description string, read func() ([]byte, error)) Prompt {
+	return serialize(func(key string, retry bool) ([]byte, error) {
+		if retry {
+			return nil, errors.Errorf("wrong passphrase read from %s for key %s", description, key)
+		}
+
+		passphrase, err := read()
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read passphrase from %s", description)
+		}
+
+		return trimNewline(passphrase), nil
+	})
+}
+
+// Asks GPG Connect Agent for a passphrase (i.e. pinentry). Passphrases are
+// cached by the agent per key, and 'retry' clears the cached passphrase.
+func NewAgentPrompt(agent string) Prompt {
+	return serialize(func(key string, retry bool) ([]byte, error) {
+		return getAgentPassphrase(agent, key, retry)
+	})
+}
+
+// Returns true if passphrases are read from a non-interactive source, i.e.
+// the same passphrase is used for all keys.
+func IsNonInteractive(source string) bool {
+	return source == "env" || source == "file" || source == "command" || source == "fd"
+}
+
+func NewEnvPrompt(name string) Prompt {
+	return nonInteractive(
+		fmt.Sprintf("environment variable '%s'", name),
+		func() ([]byte, error) {
+			value, found := os.LookupEnv(name)
+			if !found {
+				return nil, errors.New("variable not set")
+			}
+			return []byte(value), nil
+		})
+}
+
+func NewFilePrompt(path string) Prompt {
+	return nonInteractive(
+		fmt.Sprintf("file '%s'", path),
+		func() ([]byte, error) {
+			return ioutil.ReadFile(path)
+		})
+}
+
+// Standard output of 'command' (executed using the shell) is the passphrase.
+func NewCommandPrompt(command string) Prompt {
+	return nonInteractive(
+		fmt.Sprintf("command '%s'", command),
+		func() ([]byte, error) {
+			var cmd *exec.Cmd
+			if runtime.GOOS == "windows" {
+				cmd = exec.Command("cmd", "/C", command)
+			} else {
+				cmd = exec.Command("sh", "-c", command)
+			}
+			// Standard input may hold the secret (e.g. 'set' & 'edit'
+			// commands), so it's never inherited.
+			cmd.Stdin = nil
+			cmd.Stderr = os.Stderr
+			return cmd.Output()
+		})
+}
+
+// The file descriptor can only be read once, so the passphrase is cached.
+func NewFdPrompt(fd int) Prompt {
+	var once sync.Once
+	var passphrase []byte
+	var err error
+	return nonInteractive(
+		fmt.Sprintf("file descriptor %d", fd),
+		func() ([]byte, error) {
+			once.Do(func() {
+				file := os.NewFile(uintptr(fd), fmt.Sprintf("fd%d", fd))
+				if file == nil {
+					err = errors.New("invalid file descriptor")
+					return
+				}
+				defer file.Close()
+				passphrase, err = ioutil.ReadAll(file)
+			})
+			return passphrase, err
+		})
+}
+
+func trimNewline(passphrase []byte) []byte {
+	passphrase = bytes.TrimSuffix(passphrase, []byte("\n"))
+	return bytes.TrimSuffix(passphrase, []byte("\r"))
+}
+
+// Escapes a GET_PASSPHRASE argument: spaces are encoded as '+', and other
+// special characters using percent escaping.
+func escapeAgentArgument(value string) string {
+	var result strings.Builder
+	for _, c := range []byte(value) {
+		switch {
+		case c == ' ':
+			result.WriteByte('+')
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9',
+			strings.IndexByte("-_.:/=", c) >= 0:
+			result.WriteByte(c)
+		default:
+			fmt.Fprintf(&result, "%%%02X", c)
+		}
+	}
+	return result.String()
+}
+
+func getAgentPassphrase(agent string, key string, error bool) ([]byte, error) {
+	// See:
+	//   - https://www.gnupg.org/documentation/manuals/gnupg/Agent-GET_005fPASSPHRASE.html
+
+	cacheId := escapeAgentArgument("pgp-tomb:private-key-passphrase:" + key)
+	description := escapeAgentArgument("PGP Tomb private key " + key)
+	errorMessage := "X"
+
+	if error {
+		if err := exec.Command(
+			agent,
+			fmt.Sprintf("CLEAR_PASSPHRASE %s", cacheId),
+			"/bye").Run(); err != nil {
+			return nil, errors.Wrap(err, "failed to clear GPG Connect Agent cache")
+		}
+		errorMessage = "Failed+to+decrypt+private+key!"
+	}
+
+	cmd := exec.Command(
+		agent,
+		fmt.Sprintf(
+			"GET_PASSPHRASE --data %s %s Passphrase %s",
+			cacheId, errorMessage, description),
+		"/bye")
+
+	cmd.Stderr = nil
+
+	cmd.Stdin = nil
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create GPG Connect Agent stdout pipe")
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, errors.Wrap(err, "failed to start GPG Connect Agent command execution")
+	}
+
+	passphrase := ""
+	cancelled := false
+	reader := bufio.NewReader(stdout)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			cancelled = true
+			break
+		}
+		if strings.HasPrefix(line, "D ") {
+			passphrase = strings.TrimSuffix(strings.TrimPrefix(line, "D "), "\n")
+		} else if strings.HasPrefix(line, "OK") {
+			break
+		}
+	}
+	if err := cmd.Wait(); err != nil {
+		return nil, errors.Wrap(err, "failed to complete GPG Connect Agent command execution")
+	}
+
+	if !cancelled {
+		return []byte(passphrase), nil
+	} else {
+		return nil, nil
+	}
+}
@@ -0,0 +1,95 @@
+package passphrase
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNonInteractivePrompts(t *testing.T) {
+	file, err := ioutil.TempFile("", "pgp-tomb-passphrase-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString("s3cr3t\n")
+	file.Close()
+
+	os.Setenv("PGP_TOMB_TEST_PASSPHRASE", "s3cr3t")
+	defer os.Unsetenv("PGP_TOMB_TEST_PASSPHRASE")
+
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer.WriteString("s3cr3t\r\n")
+	writer.Close()
+
+	prompts := map[string]Prompt{
+		"env":     NewEnvPrompt("PGP_TOMB_TEST_PASSPHRASE"),
+		"file":    NewFilePrompt(file.Name()),
+		"command": NewCommandPrompt("echo s3cr3t"),
+		"fd":      NewFdPrompt(int(reader.Fd())),
+	}
+	for name, prompt := range prompts {
+		// Trailing newlines are removed, and the passphrase can be read
+		// several times (e.g. several private keys).
+		for i := 0; i < 2; i++ {
+			if passphrase, err := prompt("ABCD", false); assert.NoError(t, err, name) {
+				assert.Equal(t, "s3cr3t", string(passphrase), name)
+			}
+		}
+
+		// Retrying is pointless.
+		_, err := prompt("ABCD", true)
+		assert.Error(t, err, name)
+	}
+}
+
+func TestCommandPromptKeepsStdin(t *testing.T) {
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	writer.WriteString("secret")
+	writer.Close()
+
+	stdin := os.Stdin
+	os.Stdin = reader
+	defer func() { os.Stdin = stdin }()
+
+	// Commands reading the standard input don't consume it.
+	if passphrase, err := NewCommandPrompt("cat; echo s3cr3t")("ABCD", false); assert.NoError(t, err) {
+		assert.Equal(t, "s3cr3t", string(passphrase))
+	}
+	data, err := ioutil.ReadAll(os.Stdin)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "secret", string(data))
+	}
+}
+
+func TestNonInteractivePromptsFailures(t *testing.T) {
+	os.Unsetenv("PGP_TOMB_TEST_PASSPHRASE")
+
+	prompts := map[string]Prompt{
+		"env":     NewEnvPrompt("PGP_TOMB_TEST_PASSPHRASE"),
+		"file":    NewFilePrompt("/nonexistent/passphrase"),
+		"command": NewCommandPrompt("exit 1"),
+	}
+	for name, prompt := range prompts {
+		_, err := prompt("ABCD", false)
+		assert.Error(t, err, name)
+	}
+}
+
+func TestEscapeAgentArgument(t *testing.T) {
+	assert.Equal(t,
+		"pgp-tomb:private-key-passphrase:SHA256:a/b%2Bc=",
+		escapeAgentArgument("pgp-tomb:private-key-passphrase:SHA256:a/b+c="))
+	assert.Equal(t,
+		"PGP+Tomb+private+key+ABCD%25%0A",
+		escapeAgentArgument("PGP Tomb private key ABCD%\n"))
+}
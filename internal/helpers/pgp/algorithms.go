@@ -0,0 +1,239 @@
+package pgp
+
+import (
+	"bufio"
+	"bytes"
+	"crypto"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"github.com/pkg/errors"
+)
+
+var ciphers = map[string]packet.CipherFunction{
+	"3des":   packet.Cipher3DES,
+	"cast5":  packet.CipherCAST5,
+	"aes128": packet.CipherAES128,
+	"aes192": packet.CipherAES192,
+	"aes256": packet.CipherAES256,
+}
+
+var hashes = map[string]crypto.Hash{
+	"md5":       crypto.MD5,
+	"sha1":      crypto.SHA1,
+	"ripemd160": crypto.RIPEMD160,
+	"sha224":    crypto.SHA224,
+	"sha256":    crypto.SHA256,
+	"sha384":    crypto.SHA384,
+	"sha512":    crypto.SHA512,
+}
+
+// OpenPGP identifiers of hash algorithms, as listed in preferences of keys.
+var hashIds = map[crypto.Hash]uint8{
+	crypto.MD5:       1,
+	crypto.SHA1:      2,
+	crypto.RIPEMD160: 3,
+	crypto.SHA256:    8,
+	crypto.SHA384:    9,
+	crypto.SHA512:    10,
+	crypto.SHA224:    11,
+	crypto.SHA3_256:  12,
+	crypto.SHA3_512:  14,
+}
+
+var aeadModes = map[string]packet.AEADMode{
+	AEADEAX: packet.AEADModeEAX,
+	AEADOCB: packet.AEADModeOCB,
+	AEADGCM: packet.AEADModeGCM,
+}
+
+// Relative strength of algorithms, used to detect messages encrypted or
+// signed using weaker settings. Unknown algorithms are considered weakest.
+var strengths = map[string]int{
+	"3des":      1,
+	"cast5":     1,
+	"aes128":    2,
+	"aes192":    3,
+	"aes256":    4,
+	"md5":       1,
+	"sha1":      1,
+	"ripemd160": 1,
+	"sha224":    2,
+	"sha256":    3,
+	"sha384":    4,
+	"sha512":    5,
+}
+
+// Names used by GPG in '--cipher-algo' & '--digest-algo' options.
+var gpgNames = map[string]string{
+	"aes128": "AES",
+	"aes192": "AES192",
+	"aes256": "AES256",
+	"sha256": "SHA256",
+	"sha384": "SHA384",
+	"sha512": "SHA512",
+}
+
+// Returns true if algorithm 'a' is weaker than algorithm 'b'.
+func IsWeakerAlgorithm(a, b string) bool {
+	return strengths[a] < strengths[b]
+}
+
+func getCipherName(cipher packet.CipherFunction) string {
+	for name, aCipher := range ciphers {
+		if aCipher == cipher {
+			return name
+		}
+	}
+	return "cipher-" + strconv.Itoa(int(cipher))
+}
+
+func getHashName(hash crypto.Hash) string {
+	for name, aHash := range hashes {
+		if aHash == hash {
+			return name
+		}
+	}
+	return "hash-" + strconv.Itoa(int(hash))
+}
+
+func getAEADName(mode packet.AEADMode) string {
+	for name, aMode := range aeadModes {
+		if aMode == mode {
+			return name
+		}
+	}
+	return "aead-" + strconv.Itoa(int(mode))
+}
+
+func getCompressionName(algorithm byte) string {
+	switch packet.CompressionAlgo(algorithm) {
+	case packet.CompressionZIP:
+		return CompressionZip
+	case packet.CompressionZLIB:
+		return CompressionZlib
+	case 3:
+		return "bzip2"
+	}
+	return "compression-" + strconv.Itoa(int(algorithm))
+}
+
+// Determines the symmetric cipher & AEAD mode (if any) used to encrypt
+// 'message', and the compression algorithm (if any) used inside the encrypted
+// payload. 'key' is the (unlocked) private key used to decrypt the message.
+func inspectMessage(message []byte, key openpgp.Key) (cipher, aead, compression string, e error) {
+	packets := packet.NewReader(bytes.NewReader(message))
+	var sessionKey *packet.EncryptedKey
+	for {
+		p, err := packets.Next()
+		if err != nil {
+			return "", "", "", errors.Wrap(err, "failed to read packet")
+		}
+
+		switch p := p.(type) {
+		case *packet.EncryptedKey:
+			if sessionKey == nil && key.PublicKey != nil && p.KeyId == key.PublicKey.KeyId {
+				if err := p.Decrypt(key.PrivateKey, nil); err != nil {
+					return "", "", "", errors.Wrap(err, "failed to decrypt session key")
+				}
+				sessionKey = p
+			}
+		case *packet.SymmetricallyEncrypted:
+			if sessionKey == nil {
+				return "", "", "", errors.New("no session key found")
+			}
+			contents, err := p.Decrypt(sessionKey.CipherFunc, sessionKey.Key)
+			if err != nil {
+				return "", "", "", errors.Wrap(err, "failed to decrypt payload")
+			}
+			compression, err := readCompression(contents)
+			if err != nil {
+				return "", "", "", errors.Wrap(err, "failed to read payload")
+			}
+			// Version 2 packets (i.e. AEAD) include the cipher.
+			if p.Version == 2 {
+				return getCipherName(p.Cipher), getAEADName(p.Mode), compression, nil
+			}
+			return getCipherName(sessionKey.CipherFunc), "", compression, nil
+		}
+	}
+}
+
+// Reads the header of the first packet in a (decrypted) sequence of packets
+// returning the compression algorithm if it is a compressed data packet.
+func readCompression(input io.Reader) (string, error) {
+	reader := bufio.NewReader(input)
+
+	tag, err := reader.ReadByte()
+	if err != nil {
+		return "", err
+	}
+	if tag&0x80 == 0 {
+		return "", errors.New("invalid packet header")
+	}
+
+	// Skip length octets.
+	var lengthOctets int
+	var packetType byte
+	if tag&0x40 != 0 {
+		packetType = tag & 0x3f
+		first, err := reader.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		switch {
+		case first >= 192 && first < 224:
+			lengthOctets = 1
+		case first == 255:
+			lengthOctets = 4
+		}
+	} else {
+		packetType = (tag & 0x3f) >> 2
+		switch tag & 3 {
+		case 0:
+			lengthOctets = 1
+		case 1:
+			lengthOctets = 2
+		case 2:
+			lengthOctets = 4
+		}
+	}
+	if _, err := reader.Discard(lengthOctets); err != nil {
+		return "", err
+	}
+
+	// See RFC 4880, section 5.6.
+	if packetType != 8 {
+		return CompressionNone, nil
+	}
+	algorithm, err := reader.ReadByte()
+	if err != nil {
+		return "", err
+	}
+	return getCompressionName(algorithm), nil
+}
+
+// Extracts the symmetric cipher & AEAD mode (if any) from the output of GPG's
+// '--status-fd'.
+func parseGPGStatusCipher(status []byte) (cipher, aead string) {
+	for _, line := range strings.Split(string(status), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 4 && fields[0] == "[GNUPG:]" && fields[1] == "DECRYPTION_INFO" {
+			if id, err := strconv.Atoi(fields[3]); err == nil {
+				cipher = getCipherName(packet.CipherFunction(id))
+			}
+			if len(fields) >= 5 {
+				if id, err := strconv.Atoi(fields[4]); err == nil && id != 0 {
+					aead = getAEADName(packet.AEADMode(id))
+				}
+			}
+			return
+		}
+	}
+	return
+}
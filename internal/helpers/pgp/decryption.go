@@ -1,152 +1,392 @@
 package pgp
 
 import (
-	"bufio"
-	"fmt"
+	"bytes"
 	"io"
+	"io/ioutil"
 	"os/exec"
 	"strings"
 	"sync"
 
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
 	"github.com/pkg/errors"
-	"golang.org/x/crypto/openpgp"
+
+	"github.com/carlosabalde/pgp-tomb/internal/helpers/passphrase"
+)
+
+// Reasons why a message cannot be decrypted.
+const (
+	FailureNoSecretKey = "no-secret-key"
+	FailurePassphrase  = "passphrase"
+	FailureCorrupt     = "corrupt"
+	FailureGPGMissing  = "gpg-missing"
 )
 
+type DecryptionError struct {
+	Reason string
+	Err    error
+}
+
+func (self *DecryptionError) Error() string {
+	return self.Err.Error()
+}
+
 var promptMutex = &sync.Mutex{}
 
-func Decrypt(agent string, input io.Reader, output io.Writer, key *PrivateKey) error {
-	promptError := false
-	prompt := func(keys []openpgp.Key, symmetric bool) ([]byte, error) {
-		promptMutex.Lock()
-		defer promptMutex.Unlock()
+// Failures unlocking private keys, cached for the process lifetime. Avoids
+// prompting again (e.g. once per secret) once the user cancelled the prompt or
+// exhausted all retries.
+var unlockErrors = make(map[*openpgp.Entity]error)
 
-		if symmetric {
-			return getPassphrase(agent, promptError)
-		}
+// The private key is unlocked (just once for the process lifetime) before
+// reading the message, so concurrent decryptions sharing the key never prompt
+// nor modify it. Unlocking is skipped for messages not addressed to the key.
+func Decrypt(
+	prompt passphrase.Prompt, input io.Reader, output io.Writer, key *PrivateKey,
+	keys []*PublicKey) (Verification, error) {
+	// Encrypted message is kept in order to inspect it once decrypted.
+	encrypted, err := ioutil.ReadAll(input)
+	if err != nil {
+		return Verification{}, &DecryptionError{
+			FailureCorrupt, errors.Wrap(err, "failed to read message")}
+	}
 
-		for _, k := range keys {
-			if k.PrivateKey.Encrypted {
-				passphrase, err := getPassphrase(agent, promptError)
-				if err != nil {
-					return nil, errors.Wrap(err, "failed to get passphrase for private key")
-				}
-				if passphrase == nil {
-					return nil, errors.New("no passphrase for private key")
-				}
-				if err := k.PrivateKey.Decrypt(passphrase); err != nil {
-					promptError = true
-				}
-			}
-		}
+	if found, err := isRecipient(encrypted, key); err != nil {
+		return Verification{}, &DecryptionError{
+			FailureCorrupt, errors.Wrap(err, "failed to read recipients")}
+	} else if !found {
+		return Verification{}, &DecryptionError{
+			FailureNoSecretKey, errors.New("private key is not a recipient of the message")}
+	}
 
-		return nil, nil
+	if err := UnlockPrivateKey(prompt, key); err != nil {
+		return Verification{}, &DecryptionError{
+			FailurePassphrase, errors.Wrap(err, "failed to unlock private key")}
 	}
 
-	message, err := openpgp.ReadMessage(input, openpgp.EntityList{key.Entity}, prompt, nil)
+	message, err := openpgp.ReadMessage(
+		bytes.NewReader(encrypted),
+		newKeyring(openpgp.EntityList{key.Entity}, keys),
+		func(keys []openpgp.Key, symmetric bool) ([]byte, error) {
+			return nil, errors.New("private key is locked")
+		}, nil)
 	if err != nil {
-		return errors.Wrap(err, "failed to read message")
+		return Verification{}, &DecryptionError{
+			FailureCorrupt, errors.Wrap(err, "failed to read message")}
 	}
 
 	if _, err := io.Copy(output, message.UnverifiedBody); err != nil {
-		return errors.Wrap(err, "failed to copy decrypted message")
+		return Verification{}, &DecryptionError{
+			FailureCorrupt, errors.Wrap(err, "failed to copy decrypted message")}
 	}
 
-	return nil
+	result := verify(message, keys)
+	if cipher, aead, compression, err := inspectMessage(encrypted, message.DecryptedWith); err == nil {
+		result.Cipher = cipher
+		result.AEAD = aead
+		result.Compression = compression
+	}
+
+	return result, nil
+}
+
+// Checks if the primary key or any subkey of 'key' is a recipient of the
+// encrypted message (anonymous recipients always match).
+func isRecipient(encrypted []byte, key *PrivateKey) (bool, error) {
+	ids, err := GetRecipientKeyIdsForEncryptedMessage(bytes.NewReader(encrypted))
+	if err != nil {
+		return false, err
+	}
+
+	for _, id := range ids {
+		if id == 0 || id == key.Entity.PrimaryKey.KeyId {
+			return true, nil
+		}
+		for _, subkey := range key.Entity.Subkeys {
+			if id == subkey.PublicKey.KeyId {
+				return true, nil
+			}
+		}
+	}
+
+	return false, nil
 }
 
-func DecryptWithGPG(gpg string, input io.Reader, output io.Writer) error {
+// Decryption is delegated to GPG, but signatures are checked natively using
+// 'keys'. That way signers don't need to be part of the local GPG keyring.
+func DecryptWithGPG(
+	gpg string, input io.Reader, output io.Writer,
+	keys []*PublicKey) (Verification, error) {
+	if gpg == "" {
+		return Verification{}, &DecryptionError{
+			FailureGPGMissing, errors.New("GPG executable not available")}
+	}
+
 	args := []string{
 		"--use-agent",
-		"-d",
+		"--status-fd",
+		"2",
+		"--decrypt",
+		"--unwrap",
 	}
 
 	cmd := exec.Command(gpg, args...)
 
-	cmd.Stdout = output
+	unwrapped := new(bytes.Buffer)
+	cmd.Stdout = unwrapped
 
-	cmd.Stderr = nil
+	status := new(bytes.Buffer)
+	cmd.Stderr = status
 
 	stdin, err := cmd.StdinPipe()
 	if err != nil {
-		return errors.Wrap(err, "failed to create GPG stdin pipe")
+		return Verification{}, errors.Wrap(err, "failed to create GPG stdin pipe")
 	}
 
 	if err := cmd.Start(); err != nil {
-		return errors.Wrap(err, "failed to start GPG command execution")
+		return Verification{}, &DecryptionError{
+			FailureGPGMissing, errors.Wrap(err, "failed to start GPG command execution")}
 	}
 
-	if _, err := io.Copy(stdin, input); err == nil {
-		stdin.Close()
-	} else {
-		return errors.Wrap(err, "failed to close GPG stdin pipe")
-	}
+	// GPG may exit before reading the whole message (e.g. no secret key), so
+	// its status is checked even if the message cannot be written.
+	_, copyErr := io.Copy(stdin, input)
+	stdin.Close()
 
 	if err := cmd.Wait(); err != nil {
-		return errors.Wrap(err, "failed to complete GPG command execution")
+		return Verification{}, &DecryptionError{
+			parseGPGStatusFailure(status.Bytes()),
+			errors.Wrapf(err, "failed to complete GPG command execution (%s)", getGPGErrorMessage(status.Bytes()))}
 	}
 
-	return nil
-}
+	if copyErr != nil {
+		return Verification{}, errors.Wrap(copyErr, "failed to write to GPG stdin pipe")
+	}
 
-func getPassphrase(agent string, error bool) ([]byte, error) {
-	// See:
-	//   - https://www.gnupg.org/documentation/manuals/gnupg/Agent-GET_005fPASSPHRASE.html
+	result, err := DecryptUnwrapped(unwrapped, output, keys)
+	if err != nil {
+		return Verification{}, err
+	}
+	result.Cipher, result.AEAD = parseGPGStatusCipher(status.Bytes())
 
-	cacheId := "pgp-tomb:private-key-passphrase"
-	errorMessage := "X"
+	return result, nil
+}
 
-	if error {
-		if err := exec.Command(
-			agent,
-			fmt.Sprintf("CLEAR_PASSPHRASE %s", cacheId),
-			"/bye").Run(); err != nil {
-			return nil, errors.Wrap(err, "failed to clear GPG Connect Agent cache")
+// Classifies a failed GPG decryption using the output of GPG's '--status-fd'.
+// GPG usually reports several problems (e.g. 'NO_SECKEY' & 'DECRYPTION_FAILED'
+// when a passphrase is cancelled), so the most specific one wins.
+func parseGPGStatusFailure(status []byte) string {
+	found := make(map[string]bool)
+	for _, line := range strings.Split(string(status), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && fields[0] == "[GNUPG:]" {
+			switch fields[1] {
+			case "BAD_PASSPHRASE", "MISSING_PASSPHRASE":
+				found[FailurePassphrase] = true
+			case "NO_SECKEY":
+				found[FailureNoSecretKey] = true
+			}
+		} else if strings.Contains(line, "Operation cancelled") ||
+			strings.Contains(line, "No passphrase given") ||
+			strings.Contains(line, "Bad passphrase") {
+			found[FailurePassphrase] = true
 		}
-		errorMessage = "Failed+to+decrypt+private+key!"
 	}
 
-	cmd := exec.Command(
-		agent,
-		fmt.Sprintf(
-			"GET_PASSPHRASE --data %s %s Passphrase PGP+Tomb+Private+Key",
-			cacheId, errorMessage),
-		"/bye")
-
-	cmd.Stderr = nil
+	for _, reason := range []string{FailurePassphrase, FailureNoSecretKey} {
+		if found[reason] {
+			return reason
+		}
+	}
+	return FailureCorrupt
+}
 
-	cmd.Stdin = nil
+// Returns the last human readable line in the output of GPG's '--status-fd'.
+func getGPGErrorMessage(status []byte) string {
+	message := ""
+	for _, line := range strings.Split(string(status), "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "[GNUPG:]") {
+			message = line
+		}
+	}
+	return message
+}
 
-	stdout, err := cmd.StdoutPipe()
+// Decrypts the outer layer of a message using 'key' and writes the resulting
+// sequence of packets (i.e. the equivalent to 'gpg --decrypt --unwrap') to
+// 'output'. Returns the cipher & AEAD mode used to encrypt the message.
+// Signatures are checked later using DecryptUnwrapped(), so whoever unwraps
+// the message doesn't need to trust public keys of signers.
+func Unwrap(prompt passphrase.Prompt, input io.Reader, output io.Writer, key *PrivateKey) (cipher, aead string, e error) {
+	encrypted, err := ioutil.ReadAll(input)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to create GPG Connect Agent stdout pipe")
+		return "", "", &DecryptionError{
+			FailureCorrupt, errors.Wrap(err, "failed to read message")}
 	}
 
-	if err := cmd.Start(); err != nil {
-		return nil, errors.Wrap(err, "failed to start GPG Connect Agent command execution")
+	if found, err := isRecipient(encrypted, key); err != nil {
+		return "", "", &DecryptionError{
+			FailureCorrupt, errors.Wrap(err, "failed to read recipients")}
+	} else if !found {
+		return "", "", &DecryptionError{
+			FailureNoSecretKey, errors.New("private key is not a recipient of the message")}
 	}
 
-	passphrase := ""
-	cancelled := false
-	reader := bufio.NewReader(stdout)
+	if err := UnlockPrivateKey(prompt, key); err != nil {
+		return "", "", &DecryptionError{
+			FailurePassphrase, errors.Wrap(err, "failed to unlock private key")}
+	}
+
+	// Strongly based on openpgp.ReadMessage().
+	entities := openpgp.EntityList{key.Entity}
+	packets := packet.NewReader(bytes.NewReader(encrypted))
+	var sessionKey *packet.EncryptedKey
 	for {
-		line, err := reader.ReadString('\n')
+		p, err := packets.Next()
 		if err != nil {
-			cancelled = true
-			break
+			return "", "", &DecryptionError{
+				FailureCorrupt, errors.Wrap(err, "failed to read packet")}
 		}
-		if strings.HasPrefix(line, "D ") {
-			passphrase = strings.TrimSuffix(strings.TrimPrefix(line, "D "), "\n")
-		} else if strings.HasPrefix(line, "OK") {
-			break
+
+		switch p := p.(type) {
+		case *packet.EncryptedKey:
+			if sessionKey != nil {
+				continue
+			}
+			candidates := entities.KeysById(p.KeyId)
+			if p.KeyId == 0 {
+				candidates = entities.DecryptionKeys()
+			}
+			for _, candidate := range candidates {
+				if candidate.PrivateKey == nil || candidate.PrivateKey.Encrypted {
+					continue
+				}
+				if err := p.Decrypt(candidate.PrivateKey, nil); err == nil {
+					sessionKey = p
+					break
+				}
+			}
+		case *packet.SymmetricallyEncrypted:
+			if sessionKey == nil {
+				return "", "", &DecryptionError{
+					FailureCorrupt, errors.New("failed to decrypt session key")}
+			}
+			// Like openpgp.ReadMessage(), messages without integrity
+			// protection (i.e. no MDC) are refused: their plaintext is
+			// malleable.
+			if !p.IntegrityProtected {
+				return "", "", &DecryptionError{
+					FailureCorrupt, errors.New("message is not integrity protected")}
+			}
+			contents, err := p.Decrypt(sessionKey.CipherFunc, sessionKey.Key)
+			if err != nil {
+				return "", "", &DecryptionError{
+					FailureCorrupt, errors.Wrap(err, "failed to decrypt payload")}
+			}
+			// Integrity is checked once the whole payload has been read.
+			if _, err := io.Copy(output, contents); err != nil {
+				return "", "", &DecryptionError{
+					FailureCorrupt, errors.Wrap(err, "failed to copy decrypted payload")}
+			}
+			if err := contents.Close(); err != nil {
+				return "", "", &DecryptionError{
+					FailureCorrupt, errors.Wrap(err, "failed to check integrity of payload")}
+			}
+			// Version 2 packets (i.e. AEAD) include the cipher.
+			if p.Version == 2 {
+				return getCipherName(p.Cipher), getAEADName(p.Mode), nil
+			}
+			return getCipherName(sessionKey.CipherFunc), "", nil
+		default:
+			return "", "", &DecryptionError{
+				FailureCorrupt, errors.New("unexpected packet in encrypted message")}
 		}
 	}
-	if err := cmd.Wait(); err != nil {
-		return nil, errors.Wrap(err, "failed to complete GPG Connect Agent command execution")
+}
+
+// Reads a message previously unwrapped using GPG or Unwrap(), checking
+// signatures natively using 'keys'. Algorithms used to encrypt the message are
+// unknown at this point, so only compression & hash are included in the
+// verification.
+func DecryptUnwrapped(input io.Reader, output io.Writer, keys []*PublicKey) (Verification, error) {
+	unwrapped, err := ioutil.ReadAll(input)
+	if err != nil {
+		return Verification{}, errors.Wrap(err, "failed to read unwrapped message")
+	}
+
+	compression, err := readCompression(bytes.NewReader(unwrapped))
+	if err != nil {
+		compression = ""
+	}
+
+	message, err := openpgp.ReadMessage(bytes.NewReader(unwrapped), newKeyring(nil, keys), nil, nil)
+	if err != nil {
+		return Verification{}, &DecryptionError{
+			FailureCorrupt, errors.Wrap(err, "failed to read unwrapped message")}
 	}
 
-	if !cancelled {
-		return []byte(passphrase), nil
-	} else {
-		return nil, nil
+	if _, err := io.Copy(output, message.UnverifiedBody); err != nil {
+		return Verification{}, &DecryptionError{
+			FailureCorrupt, errors.Wrap(err, "failed to copy decrypted message")}
 	}
+
+	result := verify(message, keys)
+	result.Compression = compression
+
+	return result, nil
+}
+
+// Decrypts (if needed) all private keys in the entity. Required before
+// signing, given that a signing subkey may be selected. The passphrase is
+// requested at most once per process (plus retries): decrypted keys stay
+// unlocked, and failures are cached.
+func UnlockPrivateKey(prompt passphrase.Prompt, key *PrivateKey) error {
+	locked := key.IsLocked
+
+	promptMutex.Lock()
+	defer promptMutex.Unlock()
+
+	if err, found := unlockErrors[key.Entity]; found {
+		return err
+	}
+
+	if prompt == nil && locked() {
+		return errors.New("no passphrase prompt for private key")
+	}
+
+	promptError := false
+	for i := 0; i < 3 && locked(); i++ {
+		value, err := prompt(key.GetFingerprint(), promptError)
+		if err != nil {
+			unlockErrors[key.Entity] = errors.Wrap(err, "failed to get passphrase for private key")
+			return unlockErrors[key.Entity]
+		}
+		if value == nil {
+			unlockErrors[key.Entity] = errors.New("no passphrase for private key")
+			return unlockErrors[key.Entity]
+		}
+		promptError = false
+		if key.Entity.PrivateKey != nil && key.Entity.PrivateKey.Encrypted {
+			if err := key.Entity.PrivateKey.Decrypt(value); err != nil {
+				promptError = true
+				continue
+			}
+		}
+		for _, subkey := range key.Entity.Subkeys {
+			if subkey.PrivateKey != nil && subkey.PrivateKey.Encrypted {
+				if err := subkey.PrivateKey.Decrypt(value); err != nil {
+					promptError = true
+				}
+			}
+		}
+	}
+
+	if locked() {
+		unlockErrors[key.Entity] = errors.New("failed to decrypt private key")
+		return unlockErrors[key.Entity]
+	}
+
+	return nil
 }
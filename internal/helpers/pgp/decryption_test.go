@@ -0,0 +1,270 @@
+package pgp
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/rand"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"github.com/stretchr/testify/assert"
+)
+
+// Secrets in the 'files/secrets/' folder were encrypted by previous versions
+// (i.e. version 1 of the file format: gzipped OpenPGP message).
+func TestDecryptLegacyMessages(t *testing.T) {
+	privateKey := loadTestPrivateKey(t, "alice")
+	keys := loadTestPublicKeys(t, "alice", "bob")
+
+	for _, name := range []string{"example.com.login", "foo/Lenna.png"} {
+		input, err := os.Open("../../../files/secrets/" + name + ".secret")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer input.Close()
+
+		reader, err := gzip.NewReader(input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		message, err := ioutil.ReadAll(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if ids, err := GetRecipientKeyIdsForEncryptedMessage(bytes.NewReader(message)); assert.NoError(t, err, name) {
+			assert.NotEmpty(t, ids, name)
+		}
+
+		var decrypted bytes.Buffer
+		verification, err := Decrypt(nil, bytes.NewReader(message), &decrypted, privateKey, keys)
+		if assert.NoError(t, err, name) {
+			assert.NotEmpty(t, decrypted.Bytes(), name)
+			assert.Equal(t, SignatureNone, verification.State, name)
+			assert.NotEmpty(t, verification.Cipher, name)
+		}
+	}
+}
+
+// Failures unlocking private keys are cached, so the passphrase is requested
+// at most once per process.
+func TestDecryptWithLockedKey(t *testing.T) {
+	input, err := os.Open("../../../files/keys/alice.pri")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer input.Close()
+	privateKey, err := LoadASCIIArmoredPrivateKey(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := loadTestPublicKeys(t, "alice", "bob")
+
+	var encrypted bytes.Buffer
+	if err := Encrypt(bytes.NewReader([]byte("foo")), &encrypted, keys, nil, "", Settings{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Wrong passphrases are retried, but the failure is cached.
+	prompts := 0
+	prompt := func(key string, retry bool) ([]byte, error) {
+		assert.Equal(t, privateKey.GetFingerprint(), key)
+		assert.Equal(t, prompts > 0, retry)
+		prompts++
+		return []byte("foo"), nil
+	}
+	_, err = Decrypt(prompt, bytes.NewReader(encrypted.Bytes()), ioutil.Discard, &privateKey, keys)
+	if assert.IsType(t, &DecryptionError{}, err) {
+		assert.Equal(t, FailurePassphrase, err.(*DecryptionError).Reason)
+	}
+	assert.Equal(t, 3, prompts)
+	cached, found := unlockErrors[privateKey.Entity]
+	if assert.True(t, found) {
+		assert.Equal(t, cached, UnlockPrivateKey(prompt, &privateKey))
+		assert.Equal(t, 3, prompts)
+	}
+}
+
+func TestUnwrap(t *testing.T) {
+	// Keys accepting compression & AEAD.
+	config := &packet.Config{
+		Algorithm:              packet.PubKeyAlgoEdDSA,
+		Curve:                  packet.Curve25519,
+		DefaultCompressionAlgo: packet.CompressionZLIB,
+		AEADConfig:             &packet.AEADConfig{DefaultMode: packet.AEADModeOCB},
+	}
+	alice, aliceKey := newTestKeyPair(t, "alice", config)
+	_, bobKey := newTestKeyPair(t, "bob", config)
+	keys := []*PublicKey{aliceKey, bobKey}
+
+	for _, settings := range []Settings{{Compression: "none"}, {AEAD: "ocb", Compression: "zlib"}} {
+		var encrypted bytes.Buffer
+		if err := Encrypt(bytes.NewReader([]byte("foo")), &encrypted, keys, alice, "bar", settings); err != nil {
+			t.Fatal(err)
+		}
+
+		var unwrapped bytes.Buffer
+		cipher, aead, err := Unwrap(nil, bytes.NewReader(encrypted.Bytes()), &unwrapped, alice)
+		if !assert.NoError(t, err) {
+			continue
+		}
+
+		var decrypted bytes.Buffer
+		verification, err := DecryptUnwrapped(&unwrapped, &decrypted, keys)
+		if assert.NoError(t, err) {
+			assert.Equal(t, "foo", decrypted.String())
+			assert.Equal(t, "bar", verification.FileName)
+			assert.Equal(t, SignatureValid, verification.State)
+			assert.Equal(t, keys[0], verification.Signer)
+			assert.Equal(t, settings.Compression, verification.Compression)
+		}
+
+		// Algorithms match those reported by native decryption.
+		if expected, err := Decrypt(nil, bytes.NewReader(encrypted.Bytes()), ioutil.Discard, alice, keys); assert.NoError(t, err) {
+			assert.Equal(t, expected.Cipher, cipher)
+			assert.Equal(t, expected.AEAD, aead)
+		}
+	}
+
+	// Messages not addressed to the key are rejected without unlocking it.
+	var encrypted bytes.Buffer
+	if err := Encrypt(bytes.NewReader([]byte("foo")), &encrypted, keys[1:], nil, "", Settings{}); err != nil {
+		t.Fatal(err)
+	}
+	_, _, err := Unwrap(nil, bytes.NewReader(encrypted.Bytes()), ioutil.Discard, alice)
+	assert.Error(t, err)
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+func TestUnwrapWithoutIntegrityProtection(t *testing.T) {
+	alice, _ := newTestKeyPair(t, "alice", nil)
+
+	// Session key encrypted to alice.
+	var encrypted bytes.Buffer
+	sessionKey := make([]byte, 32)
+	if _, err := rand.Read(sessionKey); err != nil {
+		t.Fatal(err)
+	}
+	encryptionKey, ok := alice.Entity.EncryptionKey(time.Now())
+	if !ok {
+		t.Fatal("no encryption key")
+	}
+	if err := packet.SerializeEncryptedKey(
+		&encrypted, encryptionKey.PublicKey, packet.CipherAES256, sessionKey, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Literal data packet encrypted using a symmetrically encrypted data
+	// packet (i.e. tag 9, no MDC).
+	var literal bytes.Buffer
+	writer, err := packet.SerializeLiteral(nopWriteCloser{&literal}, true, "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer.Write([]byte("foo"))
+	writer.Close()
+	block, err := aes.NewCipher(sessionKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream, prefix := packet.NewOCFBEncrypter(block, make([]byte, block.BlockSize()), packet.OCFBResync)
+	contents := make([]byte, literal.Len())
+	stream.XORKeyStream(contents, literal.Bytes())
+	contents = append(prefix, contents...)
+	encrypted.Write([]byte{0xc0 | 9, byte(len(contents))})
+	encrypted.Write(contents)
+
+	var unwrapped bytes.Buffer
+	_, _, err = Unwrap(nil, bytes.NewReader(encrypted.Bytes()), &unwrapped, alice)
+	if assert.IsType(t, &DecryptionError{}, err) {
+		assert.Equal(t, FailureCorrupt, err.(*DecryptionError).Reason)
+		assert.Contains(t, err.Error(), "not integrity protected")
+	}
+	assert.Empty(t, unwrapped.Bytes())
+}
+
+func TestDecryptionFailures(t *testing.T) {
+	alice := loadTestPrivateKey(t, "alice")
+	keys := loadTestPublicKeys(t, "alice", "bob")
+
+	var encrypted bytes.Buffer
+	if err := Encrypt(bytes.NewReader([]byte("foo")), &encrypted, keys[:1], nil, "", Settings{}); err != nil {
+		t.Fatal(err)
+	}
+	var encryptedForBob bytes.Buffer
+	if err := Encrypt(bytes.NewReader([]byte("foo")), &encryptedForBob, keys[1:], nil, "", Settings{}); err != nil {
+		t.Fatal(err)
+	}
+	truncated := encrypted.Bytes()[:encrypted.Len()-8]
+
+	tests := []struct {
+		message []byte
+		reason  string
+	}{
+		{encryptedForBob.Bytes(), FailureNoSecretKey},
+		{truncated, FailureCorrupt},
+		{[]byte("foo"), FailureCorrupt},
+	}
+
+	for _, test := range tests {
+		_, err := Decrypt(nil, bytes.NewReader(test.message), ioutil.Discard, alice, keys)
+		if assert.IsType(t, &DecryptionError{}, err) {
+			assert.Equal(t, test.reason, err.(*DecryptionError).Reason)
+		}
+
+		_, _, err = Unwrap(nil, bytes.NewReader(test.message), ioutil.Discard, alice)
+		if assert.IsType(t, &DecryptionError{}, err) {
+			assert.Equal(t, test.reason, err.(*DecryptionError).Reason)
+		}
+	}
+
+	_, err := DecryptWithGPG("", bytes.NewReader(encrypted.Bytes()), ioutil.Discard, keys)
+	if assert.IsType(t, &DecryptionError{}, err) {
+		assert.Equal(t, FailureGPGMissing, err.(*DecryptionError).Reason)
+	}
+}
+
+func TestParseGPGStatusFailure(t *testing.T) {
+	tests := []struct {
+		status string
+		reason string
+	}{
+		{"[GNUPG:] ENC_TO 0123456789ABCDEF 1 0\n" +
+			"[GNUPG:] NO_SECKEY 0123456789ABCDEF\n" +
+			"[GNUPG:] BEGIN_DECRYPTION\n" +
+			"[GNUPG:] DECRYPTION_FAILED\n" +
+			"gpg: decryption failed: No secret key\n",
+			FailureNoSecretKey},
+		{"[GNUPG:] PINENTRY_LAUNCHED 1234 curses 1.1.0\n" +
+			"gpg: public key decryption failed: Operation cancelled\n" +
+			"[GNUPG:] ERROR pkdecrypt_failed 83886179\n" +
+			"[GNUPG:] NO_SECKEY 0123456789ABCDEF\n" +
+			"[GNUPG:] DECRYPTION_FAILED\n",
+			FailurePassphrase},
+		{"[GNUPG:] BAD_PASSPHRASE 0123456789ABCDEF\n", FailurePassphrase},
+		{"[GNUPG:] NODATA 3\n" +
+			"gpg: decrypt_message failed: Unknown system error\n",
+			FailureCorrupt},
+		{"", FailureCorrupt},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.reason, parseGPGStatusFailure([]byte(test.status)))
+	}
+
+	assert.Equal(
+		t, "gpg: decryption failed: No secret key",
+		getGPGErrorMessage([]byte(tests[0].status)))
+}
@@ -1,28 +1,342 @@
 package pgp
 
 import (
+	"crypto"
 	"io"
+	"os/exec"
+	"strconv"
 
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
 	"github.com/pkg/errors"
-	"golang.org/x/crypto/openpgp"
 )
 
-func Encrypt(input io.Reader, output io.Writer, keys []*PublicKey) error {
+const (
+	CompressionNone = "none"
+	CompressionZip  = "zip"
+	CompressionZlib = "zlib"
+)
+
+const (
+	AEADNone = "none"
+	AEADEAX  = "eax"
+	AEADOCB  = "ocb"
+	AEADGCM  = "gcm"
+)
+
+// Level used to select the default compression level.
+const DefaultCompressionLevel = -1
+
+// Settings used when encrypting messages. The zero value uses no compression
+// nor AEAD, and negotiates cipher & hash algorithms according with preferences
+// of keys.
+type Settings struct {
+	Cipher           string
+	Hash             string
+	AEAD             string
+	Compression      string
+	CompressionLevel int
+}
+
+func (self Settings) toPacketConfig() *packet.Config {
+	result := &packet.Config{
+		DefaultCipher:          ciphers[self.Cipher],
+		DefaultHash:            hashes[self.Hash],
+		DefaultCompressionAlgo: packet.CompressionNone,
+		CompressionConfig: &packet.CompressionConfig{
+			Level: self.CompressionLevel,
+		},
+	}
+
+	if mode, found := aeadModes[self.AEAD]; found {
+		result.AEADConfig = &packet.AEADConfig{
+			DefaultMode: mode,
+		}
+	}
+
+	switch self.Compression {
+	case CompressionZip:
+		result.DefaultCompressionAlgo = packet.CompressionZIP
+	case CompressionZlib:
+		result.DefaultCompressionAlgo = packet.CompressionZLIB
+	}
+
+	return result
+}
+
+func (self Settings) toGPGArgs() []string {
+	result := make([]string, 0)
+
+	if name, found := gpgNames[self.Cipher]; found {
+		result = append(result, "--cipher-algo", name)
+	}
+
+	if name, found := gpgNames[self.Hash]; found {
+		result = append(result, "--digest-algo", name)
+	}
+
+	result = append(result, "--compress-algo")
+
+	switch self.Compression {
+	case CompressionZip, CompressionZlib:
+		result = append(result, self.Compression)
+		if self.CompressionLevel != DefaultCompressionLevel {
+			result = append(result, "--compress-level", strconv.Itoa(self.CompressionLevel))
+		}
+	default:
+		result = append(result, CompressionNone)
+	}
+
+	return result
+}
+
+// If 'signer' is provided the message is also signed. In that case the
+// private key must be already unlocked (see UnlockPrivateKey()). 'fileName'
+// is stored in the (encrypted) literal data packet.
+func Encrypt(
+	input io.Reader, output io.Writer, keys []*PublicKey, signer *PrivateKey,
+	fileName string, settings Settings) error {
 	entities := make([]*openpgp.Entity, 0)
 	for _, key := range keys {
 		entities = append(entities, key.Entity)
 	}
 
-	hints := openpgp.FileHints{IsBinary: true}
-	plain, err := openpgp.Encrypt(output, entities, nil, &hints, nil)
+	var signed *openpgp.Entity
+	if signer != nil {
+		signed = signer.Entity
+	}
+
+	if err := checkSettings(keys, signer, settings); err != nil {
+		return errors.Wrap(err, "PGP encryption failed")
+	}
+
+	hints := openpgp.FileHints{IsBinary: true, FileName: fileName}
+	plain, err := openpgp.Encrypt(output, entities, signed, &hints, settings.toPacketConfig())
 	if err != nil {
 		return errors.Wrap(err, "PGP encryption failed")
 	}
-	defer plain.Close()
 
 	if _, err := io.Copy(plain, input); err != nil {
+		plain.Close()
+		return errors.Wrap(err, "PGP encryption failed")
+	}
+
+	if err := plain.Close(); err != nil {
 		return errors.Wrap(err, "PGP encryption failed")
 	}
 
 	return nil
 }
+
+// Algorithms openpgp.Encrypt() is able to use, no matter the preferences of
+// recipients.
+var (
+	supportedCiphers = []uint8{
+		uint8(packet.CipherAES256),
+		uint8(packet.CipherAES128),
+	}
+	supportedHashes = []uint8{
+		hashIds[crypto.SHA256],
+		hashIds[crypto.SHA384],
+		hashIds[crypto.SHA512],
+		hashIds[crypto.SHA3_256],
+		hashIds[crypto.SHA3_512],
+	}
+)
+
+// Settings not accepted by all recipients are refused: otherwise messages
+// would use algorithms recipients may not be able to handle or, when
+// negotiated like openpgp.Encrypt() does, silently different ones.
+func checkSettings(keys []*PublicKey, signer *PrivateKey, settings Settings) error {
+	config := settings.toPacketConfig()
+
+	// Candidates used by openpgp.Encrypt(), intersected below with
+	// preferences of all recipients.
+	cipherIds := supportedCiphers
+	hashCandidates := supportedHashes
+	suites := [][2]uint8{
+		{uint8(packet.CipherAES256), uint8(packet.AEADModeGCM)},
+		{uint8(packet.CipherAES256), uint8(packet.AEADModeEAX)},
+		{uint8(packet.CipherAES256), uint8(packet.AEADModeOCB)},
+		{uint8(packet.CipherAES128), uint8(packet.AEADModeGCM)},
+		{uint8(packet.CipherAES128), uint8(packet.AEADModeEAX)},
+		{uint8(packet.CipherAES128), uint8(packet.AEADModeOCB)},
+	}
+
+	signatures := make([]*packet.Signature, len(keys))
+	for i, key := range keys {
+		signature := getPrimarySelfSignature(key.Entity)
+		if signature == nil {
+			continue
+		}
+		signatures[i] = signature
+
+		// Keys not listing preferred compression algorithms only accept
+		// uncompressed messages.
+		if algorithm := config.Compression(); algorithm != packet.CompressionNone &&
+			!containsAlgorithm(signature.PreferredCompression, uint8(algorithm)) {
+			return errors.Errorf(
+				"compression %s is not accepted by key '%s'", settings.Compression, key.Alias)
+		}
+
+		if config.AEAD() != nil && !signature.SEIPDv2 {
+			return errors.Errorf("AEAD is not accepted by key '%s'", key.Alias)
+		}
+
+		cipherIds = intersectAlgorithms(cipherIds, signature.PreferredSymmetric)
+		hashCandidates = intersectAlgorithms(hashCandidates, signature.PreferredHash)
+		suites = intersectCipherSuites(suites, signature.PreferredCipherSuites)
+	}
+
+	// Mandatory algorithms are used when recipients share no preferences.
+	if len(cipherIds) == 0 {
+		cipherIds = []uint8{uint8(packet.CipherAES128)}
+	}
+	if len(hashCandidates) == 0 {
+		hashCandidates = []uint8{hashIds[crypto.SHA256]}
+	}
+	if len(suites) == 0 {
+		suites = [][2]uint8{{uint8(packet.CipherAES128), uint8(packet.AEADModeOCB)}}
+	}
+
+	// The cipher suite preferred by recipients is always used for AEAD,
+	// ignoring the configured cipher & mode.
+	if aead := config.AEAD(); aead != nil {
+		suite := suites[0]
+		if packet.AEADMode(suite[1]) != aead.Mode() ||
+			(settings.Cipher != "" && packet.CipherFunction(suite[0]) != config.DefaultCipher) {
+			return errors.Errorf(
+				"AEAD %s is not preferred by all recipients (%s %s would be used)",
+				settings.AEAD, getCipherName(packet.CipherFunction(suite[0])),
+				getAEADName(packet.AEADMode(suite[1])))
+		}
+	} else if settings.Cipher != "" &&
+		!containsAlgorithm(cipherIds, uint8(config.DefaultCipher)) {
+		return refuseAlgorithm(
+			"cipher", settings.Cipher, uint8(config.DefaultCipher), supportedCiphers,
+			keys, signatures,
+			func(signature *packet.Signature) []uint8 { return signature.PreferredSymmetric })
+	}
+
+	if signer != nil && settings.Hash != "" {
+		id := hashIds[config.DefaultHash]
+		if key, found := signer.Entity.SigningKey(config.Now()); found &&
+			containsAlgorithm(supportedHashes, id) &&
+			!containsAlgorithm(getAcceptableSignatureHashes(key.PublicKey), id) {
+			return errors.Errorf("hash %s cannot be used by the signing key", settings.Hash)
+		}
+		if !containsAlgorithm(hashCandidates, id) {
+			return refuseAlgorithm(
+				"hash", settings.Hash, id, supportedHashes, keys, signatures,
+				func(signature *packet.Signature) []uint8 { return signature.PreferredHash })
+		}
+	}
+
+	return nil
+}
+
+// Builds the error refusing an algorithm, naming the first key not listing it
+// among its preferences, if any.
+func refuseAlgorithm(
+	kind, name string, id uint8, supported []uint8, keys []*PublicKey,
+	signatures []*packet.Signature, preferences func(*packet.Signature) []uint8) error {
+	if !containsAlgorithm(supported, id) {
+		return errors.Errorf("%s %s is not supported", kind, name)
+	}
+	for i, signature := range signatures {
+		if signature != nil && !containsAlgorithm(preferences(signature), id) {
+			return errors.Errorf("%s %s is not accepted by key '%s'", kind, name, keys[i].Alias)
+		}
+	}
+	return errors.Errorf("%s %s is not accepted by all recipients", kind, name)
+}
+
+// Hashes openpgp.Encrypt() is willing to use when signing with 'key': keys
+// using larger curves require larger hashes.
+func getAcceptableSignatureHashes(key *packet.PublicKey) []uint8 {
+	large := []uint8{hashIds[crypto.SHA512], hashIds[crypto.SHA3_512]}
+	switch key.PubKeyAlgo {
+	case packet.PubKeyAlgoEd448:
+		return large
+	case packet.PubKeyAlgoECDSA, packet.PubKeyAlgoEdDSA:
+		if curve, err := key.Curve(); err == nil {
+			switch curve {
+			case packet.Curve448, packet.CurveNistP521, packet.CurveBrainpoolP512:
+				return large
+			case packet.CurveNistP384, packet.CurveBrainpoolP384:
+				return append([]uint8{hashIds[crypto.SHA384]}, large...)
+			}
+		}
+	}
+	return supportedHashes
+}
+
+func containsAlgorithm(algorithms []uint8, algorithm uint8) bool {
+	for _, item := range algorithms {
+		if item == algorithm {
+			return true
+		}
+	}
+	return false
+}
+
+func intersectAlgorithms(a, b []uint8) []uint8 {
+	result := make([]uint8, 0)
+	for _, algorithm := range a {
+		if containsAlgorithm(b, algorithm) {
+			result = append(result, algorithm)
+		}
+	}
+	return result
+}
+
+func intersectCipherSuites(a, b [][2]uint8) [][2]uint8 {
+	result := make([][2]uint8, 0)
+	for _, suite := range a {
+		for _, aSuite := range b {
+			if suite == aSuite {
+				result = append(result, suite)
+				break
+			}
+		}
+	}
+	return result
+}
+
+// Encrypts & signs using GPG. Recipients are provided as files containing
+// public keys, so they don't need to be part of the local GPG keyring.
+func EncryptAndSignWithGPG(
+	gpg string, input io.Reader, output io.Writer, files []string,
+	fingerprint, fileName string, settings Settings) error {
+	if _, found := aeadModes[settings.AEAD]; found {
+		return errors.New("AEAD encryption is not supported by GPG")
+	}
+
+	args := []string{
+		"--use-agent",
+		"--encrypt",
+		"--sign",
+		"--local-user",
+		fingerprint,
+		"--set-filename",
+		fileName,
+	}
+	args = append(args, settings.toGPGArgs()...)
+	for _, file := range files {
+		args = append(args, "--recipient-file", file)
+	}
+
+	cmd := exec.Command(gpg, args...)
+
+	cmd.Stdin = input
+
+	cmd.Stdout = output
+
+	cmd.Stderr = nil
+
+	if err := cmd.Run(); err != nil {
+		return errors.Wrap(err, "failed to complete GPG command execution")
+	}
+
+	return nil
+}
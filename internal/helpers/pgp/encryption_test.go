@@ -0,0 +1,251 @@
+package pgp
+
+import (
+	"bytes"
+	"crypto"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncryptWithCompression(t *testing.T) {
+	privateKey := loadTestPrivateKey(t, "heidi")
+	keys := loadTestPublicKeys(t, "heidi")
+	message := strings.Repeat("foo", 4096)
+
+	var uncompressed bytes.Buffer
+	if err := Encrypt(strings.NewReader(message), &uncompressed, keys, nil, "bar", Settings{}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, compression := range []string{CompressionZip, CompressionZlib} {
+		for _, signer := range []*PrivateKey{nil, privateKey} {
+			settings := Settings{
+				Compression:      compression,
+				CompressionLevel: DefaultCompressionLevel,
+			}
+
+			var encrypted, decrypted bytes.Buffer
+			if err := Encrypt(strings.NewReader(message), &encrypted, keys, signer, "bar", settings); err != nil {
+				t.Fatal(err)
+			}
+			assert.True(t, encrypted.Len() < uncompressed.Len(), compression)
+
+			verification, err := Decrypt(nil, bytes.NewReader(encrypted.Bytes()), &decrypted, privateKey, keys)
+			if assert.NoError(t, err, compression) {
+				assert.Equal(t, message, decrypted.String())
+				assert.Equal(t, "bar", verification.FileName)
+				if signer == nil {
+					assert.Equal(t, SignatureNone, verification.State)
+				} else {
+					assert.Equal(t, SignatureValid, verification.State)
+				}
+			}
+		}
+	}
+
+	// Compression not accepted by all recipients is refused.
+	keys = append(keys, loadTestPublicKeys(t, "alice")...)
+	settings := Settings{Compression: CompressionZlib, CompressionLevel: DefaultCompressionLevel}
+	err := Encrypt(strings.NewReader(message), ioutil.Discard, keys, privateKey, "bar", settings)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "compression zlib is not accepted by key 'alice'")
+	}
+}
+
+func TestEncryptWithAlgorithms(t *testing.T) {
+	signer := loadTestPrivateKey(t, "alice")
+	privateKey := loadTestPrivateKey(t, "heidi")
+	recipients := loadTestPublicKeys(t, "heidi")
+	keys := loadTestPublicKeys(t, "alice", "heidi")
+
+	tests := []Settings{
+		{Cipher: "aes128", Hash: "sha256", Compression: CompressionNone},
+		{Cipher: "aes128", Hash: "sha384", Compression: CompressionZip},
+		{Cipher: "aes256", Hash: "sha512", Compression: CompressionZlib},
+	}
+
+	for _, settings := range tests {
+		settings.CompressionLevel = DefaultCompressionLevel
+
+		var encrypted, decrypted bytes.Buffer
+		if err := Encrypt(strings.NewReader("foo"), &encrypted, recipients, signer, "bar", settings); err != nil {
+			t.Fatal(err)
+		}
+
+		verification, err := Decrypt(nil, bytes.NewReader(encrypted.Bytes()), &decrypted, privateKey, keys)
+		if assert.NoError(t, err, settings.Cipher) {
+			assert.Equal(t, "foo", decrypted.String())
+			assert.Equal(t, SignatureValid, verification.State)
+			assert.Equal(t, settings.Cipher, verification.Cipher)
+			assert.Equal(t, settings.Hash, verification.Hash)
+			assert.Equal(t, settings.Compression, verification.Compression)
+		}
+	}
+}
+
+// Generates a new key pair. The public key is loaded from its ASCII armored
+// version, like keys in the 'keys/' folder.
+func newTestKeyPair(t *testing.T, alias string, config *packet.Config) (*PrivateKey, *PublicKey) {
+	entity, err := openpgp.NewEntity(alias, "", alias+"@example.com", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buffer bytes.Buffer
+	writer, err := armor.Encode(&buffer, openpgp.PublicKeyType, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := entity.Serialize(writer); err != nil {
+		t.Fatal(err)
+	}
+	writer.Close()
+
+	key, err := LoadASCIIArmoredPublicKey(alias, &buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &PrivateKey{entity}, &key
+}
+
+func TestEncryptWithECCKeys(t *testing.T) {
+	heidi := loadTestPrivateKey(t, "heidi")
+	keys := loadTestPublicKeys(t, "alice", "heidi")
+	privateKeys := []*PrivateKey{loadTestPrivateKey(t, "alice"), heidi}
+
+	// Version 4 keys using EdDSA & ECDH, and version 6 keys using Ed25519 &
+	// X25519.
+	for i, config := range []*packet.Config{
+		{Algorithm: packet.PubKeyAlgoEdDSA, Curve: packet.Curve25519,
+			DefaultCipher: packet.CipherAES256, DefaultHash: crypto.SHA512},
+		{Algorithm: packet.PubKeyAlgoEd25519, V6Keys: true,
+			DefaultCipher: packet.CipherAES256, DefaultHash: crypto.SHA512},
+	} {
+		privateKey, publicKey := newTestKeyPair(t, fmt.Sprintf("ivan-%d", i), config)
+		keys = append(keys, publicKey)
+		privateKeys = append(privateKeys, privateKey)
+	}
+	assert.Equal(t, "ed25519", keys[2].GetPrimaryKeyInfo().Algorithm)
+	assert.Equal(t, "cv25519", keys[2].GetSubkeysInfo()[0].Algorithm)
+	assert.Equal(t, "ed25519", keys[3].GetPrimaryKeyInfo().Algorithm)
+	assert.Equal(t, "cv25519", keys[3].GetSubkeysInfo()[0].Algorithm)
+
+	for _, signer := range privateKeys {
+		var encrypted bytes.Buffer
+		settings := Settings{Cipher: "aes256", Hash: "sha512"}
+		if err := Encrypt(strings.NewReader("foo"), &encrypted, keys, signer, "bar", settings); err != nil {
+			t.Fatal(err)
+		}
+
+		if ids, err := GetRecipientKeyIdsForEncryptedMessage(bytes.NewReader(encrypted.Bytes())); assert.NoError(t, err) {
+			assert.Len(t, ids, len(keys))
+		}
+
+		for _, privateKey := range privateKeys {
+			var decrypted bytes.Buffer
+			verification, err := Decrypt(nil, bytes.NewReader(encrypted.Bytes()), &decrypted, privateKey, keys)
+			if assert.NoError(t, err) {
+				assert.Equal(t, "foo", decrypted.String())
+				assert.Equal(t, SignatureValid, verification.State)
+				assert.Equal(t, fmt.Sprintf("%X", signer.Entity.PrimaryKey.Fingerprint), verification.Signer.GetFingerprint())
+				assert.Equal(t, "aes256", verification.Cipher)
+				assert.Equal(t, "sha512", verification.Hash)
+			}
+		}
+	}
+}
+
+func TestEncryptWithAEAD(t *testing.T) {
+	for _, aead := range []string{AEADEAX, AEADOCB, AEADGCM} {
+		// Keys preferring the AEAD mode & accepting compression.
+		config := &packet.Config{
+			Algorithm:              packet.PubKeyAlgoEdDSA,
+			Curve:                  packet.Curve25519,
+			DefaultCompressionAlgo: packet.CompressionZIP,
+			AEADConfig:             &packet.AEADConfig{DefaultMode: aeadModes[aead]},
+		}
+		privateKey, publicKey := newTestKeyPair(t, "ivan", config)
+		_, otherPublicKey := newTestKeyPair(t, "judy", config)
+		keys := []*PublicKey{publicKey, otherPublicKey}
+
+		var encrypted, decrypted bytes.Buffer
+		settings := Settings{Cipher: "aes128", AEAD: aead, Compression: CompressionZip}
+		if err := Encrypt(strings.NewReader("foo"), &encrypted, keys, privateKey, "bar", settings); err != nil {
+			t.Fatal(err)
+		}
+
+		verification, err := Decrypt(nil, bytes.NewReader(encrypted.Bytes()), &decrypted, privateKey, keys)
+		if assert.NoError(t, err, aead) {
+			assert.Equal(t, "foo", decrypted.String())
+			assert.Equal(t, SignatureValid, verification.State)
+			assert.Equal(t, "aes128", verification.Cipher)
+			assert.Equal(t, aead, verification.AEAD)
+			assert.Equal(t, CompressionZip, verification.Compression)
+		}
+	}
+
+	// AEAD is not used by default.
+	privateKey := loadTestPrivateKey(t, "heidi")
+	keys := loadTestPublicKeys(t, "alice", "heidi")
+	var encrypted, decrypted bytes.Buffer
+	if err := Encrypt(strings.NewReader("foo"), &encrypted, keys, nil, "bar", Settings{}); err != nil {
+		t.Fatal(err)
+	}
+	if verification, err := Decrypt(nil, &encrypted, &decrypted, privateKey, keys); assert.NoError(t, err) {
+		assert.Equal(t, "", verification.AEAD)
+	}
+}
+
+func TestEncryptWithRefusedSettings(t *testing.T) {
+	signer := loadTestPrivateKey(t, "alice")
+	keys := loadTestPublicKeys(t, "alice", "heidi")
+
+	// Generated keys only accept AES-128 & SHA-256, and AEAD using OCB.
+	_, ivan := newTestKeyPair(t, "ivan", &packet.Config{
+		Algorithm:  packet.PubKeyAlgoEdDSA,
+		Curve:      packet.Curve25519,
+		AEADConfig: &packet.AEADConfig{DefaultMode: packet.AEADModeOCB},
+	})
+
+	tests := []struct {
+		keys     []*PublicKey
+		settings Settings
+		expected string
+	}{
+		{keys, Settings{Cipher: "aes192"}, "cipher aes192 is not supported"},
+		{append(keys, ivan), Settings{Cipher: "aes256"}, "cipher aes256 is not accepted by key 'ivan'"},
+		{append(keys, ivan), Settings{Hash: "sha512"}, "hash sha512 is not accepted by key 'ivan'"},
+		{keys, Settings{Hash: "sha224"}, "hash sha224 is not supported"},
+		{append(keys, ivan), Settings{AEAD: AEADOCB}, "AEAD is not accepted by key 'alice'"},
+		{[]*PublicKey{ivan}, Settings{AEAD: AEADGCM}, "AEAD gcm is not preferred by all recipients (aes128 ocb would be used)"},
+		{[]*PublicKey{ivan}, Settings{Cipher: "aes256", AEAD: AEADOCB}, "AEAD ocb is not preferred by all recipients (aes128 ocb would be used)"},
+	}
+
+	for _, test := range tests {
+		err := Encrypt(strings.NewReader("foo"), ioutil.Discard, test.keys, signer, "bar", test.settings)
+		if assert.Error(t, err, test.expected) {
+			assert.Contains(t, err.Error(), test.expected)
+		}
+	}
+
+	// Accepted AEAD settings.
+	err := Encrypt(strings.NewReader("foo"), ioutil.Discard, []*PublicKey{ivan}, signer, "bar",
+		Settings{Cipher: "aes128", AEAD: AEADOCB})
+	assert.NoError(t, err)
+}
+
+func TestIsWeakerAlgorithm(t *testing.T) {
+	assert.True(t, IsWeakerAlgorithm("cast5", "aes128"))
+	assert.True(t, IsWeakerAlgorithm("aes128", "aes256"))
+	assert.False(t, IsWeakerAlgorithm("aes256", "aes256"))
+	assert.False(t, IsWeakerAlgorithm("sha512", "sha256"))
+	assert.True(t, IsWeakerAlgorithm("cipher-42", "aes128"))
+}
@@ -1,12 +1,18 @@
 package pgp
 
 import (
+	"bytes"
+	"fmt"
 	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"time"
 
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
 	"github.com/pkg/errors"
-	"golang.org/x/crypto/openpgp"
-	"golang.org/x/crypto/openpgp/armor"
-	"golang.org/x/crypto/openpgp/packet"
 )
 
 type PrivateKey struct {
@@ -18,6 +24,24 @@ type PublicKey struct {
 	Entity *openpgp.Entity
 }
 
+type KeyIssue struct {
+	// Severe issues make a key unsuitable for encryption; otherwise the issue
+	// is just a warning (e.g. key about to expire).
+	Severe      bool
+	Description string
+}
+
+type KeyInfo struct {
+	KeyId      string
+	Algorithm  string
+	RSABits    int
+	Created    time.Time
+	Expires    *time.Time
+	Revoked    bool
+	CanSign    bool
+	CanEncrypt bool
+}
+
 func LoadASCIIArmoredPrivateKey(input io.Reader) (PrivateKey, error) {
 	block, err := armor.Decode(input)
 	if err != nil {
@@ -38,6 +62,64 @@ func LoadASCIIArmoredPrivateKey(input io.Reader) (PrivateKey, error) {
 	return PrivateKey{entity}, nil
 }
 
+// Loads a private key from a keyring (i.e. one or more private keys, ASCII
+// armored or binary; e.g. the output of 'gpg --export-secret-keys' or a
+// legacy 'secring.gpg' file). The key is selected using the fingerprint of its
+// primary key; an empty fingerprint is only allowed when the keyring contains
+// a single private key.
+func LoadPrivateKeyFromKeyring(input io.Reader, fingerprint string) (PrivateKey, error) {
+	data, err := ioutil.ReadAll(input)
+	if err != nil {
+		return PrivateKey{}, errors.Wrap(err, "failed to read keyring")
+	}
+
+	// ASCII armored keyrings may consist of several concatenated blocks.
+	var entities openpgp.EntityList
+	if _, err := armor.Decode(bytes.NewReader(data)); err == nil {
+		reader := bytes.NewReader(data)
+		for {
+			block, err := armor.Decode(reader)
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				return PrivateKey{}, errors.Wrap(err, "failed to decode ASCII armor of keyring")
+			}
+			items, err := openpgp.ReadKeyRing(block.Body)
+			if err != nil {
+				return PrivateKey{}, errors.Wrap(err, "failed to read ASCII armored keyring")
+			}
+			entities = append(entities, items...)
+		}
+	} else {
+		entities, err = openpgp.ReadKeyRing(bytes.NewReader(data))
+		if err != nil {
+			return PrivateKey{}, errors.Wrap(err, "failed to read keyring")
+		}
+	}
+
+	candidates := make([]*openpgp.Entity, 0)
+	for _, entity := range entities {
+		if entity.PrivateKey == nil {
+			continue
+		}
+		if fingerprint == "" ||
+			strings.EqualFold(fmt.Sprintf("%X", entity.PrimaryKey.Fingerprint), fingerprint) {
+			candidates = append(candidates, entity)
+		}
+	}
+
+	if len(candidates) == 0 {
+		if fingerprint != "" {
+			return PrivateKey{}, errors.Errorf("no private key with fingerprint %s found in keyring", fingerprint)
+		}
+		return PrivateKey{}, errors.New("no private keys found in keyring")
+	} else if len(candidates) > 1 {
+		return PrivateKey{}, errors.New("several private keys found in keyring")
+	}
+
+	return PrivateKey{candidates[0]}, nil
+}
+
 func LoadASCIIArmoredPublicKey(alias string, input io.Reader) (PublicKey, error) {
 	block, err := armor.Decode(input)
 	if err != nil {
@@ -78,3 +160,230 @@ func GetRecipientKeyIdsForEncryptedMessage(input io.Reader) ([]uint64, error) {
 
 	return result, nil
 }
+
+// Accepts both ASCII armored and binary public keys and returns the ASCII
+// armored version. Already armored keys are returned untouched.
+func ArmorPublicKey(alias string, input io.Reader) ([]byte, error) {
+	data, err := ioutil.ReadAll(input)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read key '%s'", alias)
+	}
+
+	if block, err := armor.Decode(bytes.NewReader(data)); err == nil {
+		if block.Type != openpgp.PublicKeyType {
+			return nil, errors.Errorf("invalid public key '%s'", alias)
+		}
+		return data, nil
+	}
+
+	entity, err := openpgp.ReadEntity(packet.NewReader(bytes.NewReader(data)))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to create entity from public key '%s'", alias)
+	}
+
+	buffer := new(bytes.Buffer)
+	writer, err := armor.Encode(buffer, openpgp.PublicKeyType, nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to encode ASCII armor of key '%s'", alias)
+	}
+	if err := entity.Serialize(writer); err != nil {
+		writer.Close()
+		return nil, errors.Wrapf(err, "failed to serialize public key '%s'", alias)
+	}
+	if err := writer.Close(); err != nil {
+		return nil, errors.Wrapf(err, "failed to encode ASCII armor of key '%s'", alias)
+	}
+	buffer.WriteString("\n")
+
+	return buffer.Bytes(), nil
+}
+
+// Returns true if the primary key or any subkey is still passphrase protected.
+func (self *PrivateKey) IsLocked() bool {
+	if self.Entity.PrivateKey != nil && self.Entity.PrivateKey.Encrypted {
+		return true
+	}
+	for _, subkey := range self.Entity.Subkeys {
+		if subkey.PrivateKey != nil && subkey.PrivateKey.Encrypted {
+			return true
+		}
+	}
+	return false
+}
+
+func (self *PrivateKey) GetFingerprint() string {
+	return fmt.Sprintf("%X", self.Entity.PrimaryKey.Fingerprint)
+}
+
+func (self *PublicKey) GetFingerprint() string {
+	return fmt.Sprintf("%X", self.Entity.PrimaryKey.Fingerprint)
+}
+
+func (self *PublicKey) GetUserIds() []string {
+	result := make([]string, 0)
+	for name := range self.Entity.Identities {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return result
+}
+
+func (self *PublicKey) GetPrimaryKeyInfo() KeyInfo {
+	result := newKeyInfo(self.Entity.PrimaryKey, getPrimarySelfSignature(self.Entity))
+	result.Revoked = len(self.Entity.Revocations) > 0
+
+	return result
+}
+
+func (self *PublicKey) GetSubkeysInfo() []KeyInfo {
+	result := make([]KeyInfo, 0)
+	for _, subkey := range self.Entity.Subkeys {
+		info := newKeyInfo(subkey.PublicKey, subkey.Sig)
+		info.Revoked = len(subkey.Revocations) > 0
+		result = append(result, info)
+	}
+	return result
+}
+
+// Looks for revoked, expired (or about to expire) and weak keys, as well as
+// for missing encryption-capable keys.
+func (self *PublicKey) Check(now time.Time, minRSABits int, expirationWarning time.Duration) []KeyIssue {
+	result := make([]KeyIssue, 0)
+
+	// Check primary key.
+	primary := self.GetPrimaryKeyInfo()
+	if primary.Revoked {
+		result = append(result, KeyIssue{true, "primary key revoked"})
+	}
+	if primary.Expires != nil {
+		if now.After(*primary.Expires) {
+			result = append(result, KeyIssue{true, fmt.Sprintf(
+				"primary key expired on %s", primary.Expires.Format("2006-01-02"))})
+		} else if now.Add(expirationWarning).After(*primary.Expires) {
+			result = append(result, KeyIssue{false, fmt.Sprintf(
+				"primary key expires on %s", primary.Expires.Format("2006-01-02"))})
+		}
+	}
+	if primary.RSABits > 0 && primary.RSABits < minRSABits {
+		result = append(result, KeyIssue{true, fmt.Sprintf(
+			"weak primary key (%s)", primary.Algorithm)})
+	}
+
+	// Check encryption keys. The primary key is only a candidate when there
+	// are no subkeys, like in openpgp.Entity.encryptionKey().
+	candidates := self.GetSubkeysInfo()
+	if len(candidates) == 0 {
+		candidates = append(candidates, primary)
+	}
+	var lastExpiration *time.Time
+	usable := 0
+	for _, candidate := range candidates {
+		if !candidate.CanEncrypt || candidate.Revoked ||
+			(candidate.Expires != nil && now.After(*candidate.Expires)) {
+			continue
+		}
+		usable++
+		if candidate.Expires == nil {
+			lastExpiration = nil
+		} else if usable == 1 || (lastExpiration != nil && candidate.Expires.After(*lastExpiration)) {
+			lastExpiration = candidate.Expires
+		}
+		if candidate.RSABits > 0 && candidate.RSABits < minRSABits {
+			result = append(result, KeyIssue{true, fmt.Sprintf(
+				"weak encryption key %s (%s)", candidate.KeyId, candidate.Algorithm)})
+		}
+	}
+	if usable == 0 {
+		result = append(result, KeyIssue{true, "no usable (i.e. not expired or revoked) encryption key"})
+	} else if lastExpiration != nil && now.Add(expirationWarning).After(*lastExpiration) {
+		result = append(result, KeyIssue{false, fmt.Sprintf(
+			"encryption keys expire on %s", lastExpiration.Format("2006-01-02"))})
+	}
+
+	return result
+}
+
+// Self signature holding key properties & preferences: self signature of the
+// primary identity or, for version 6 keys, the direct key signature.
+func getPrimarySelfSignature(entity *openpgp.Entity) *packet.Signature {
+	result, _ := entity.PrimarySelfSignature()
+	return result
+}
+
+func newKeyInfo(key *packet.PublicKey, signature *packet.Signature) KeyInfo {
+	result := KeyInfo{
+		KeyId:      key.KeyIdString(),
+		Algorithm:  getAlgorithmName(key),
+		Created:    key.CreationTime,
+		CanSign:    key.PubKeyAlgo.CanSign(),
+		CanEncrypt: key.PubKeyAlgo.CanEncrypt(),
+	}
+
+	switch key.PubKeyAlgo {
+	case packet.PubKeyAlgoRSA, packet.PubKeyAlgoRSAEncryptOnly, packet.PubKeyAlgoRSASignOnly:
+		if bits, err := key.BitLength(); err == nil {
+			result.RSABits = int(bits)
+		}
+	}
+
+	if signature != nil {
+		// Key expiration time is relative to key creation time. See:
+		//   - https://tools.ietf.org/html/rfc4880#section-5.2.3.6
+		if signature.KeyLifetimeSecs != nil && *signature.KeyLifetimeSecs != 0 {
+			expires := key.CreationTime.Add(
+				time.Duration(*signature.KeyLifetimeSecs) * time.Second)
+			result.Expires = &expires
+		}
+		if signature.FlagsValid {
+			result.CanSign = result.CanSign && signature.FlagSign
+			result.CanEncrypt = result.CanEncrypt &&
+				(signature.FlagEncryptCommunications || signature.FlagEncryptStorage)
+		}
+	}
+
+	return result
+}
+
+func getAlgorithmName(key *packet.PublicKey) string {
+	var name string
+	switch key.PubKeyAlgo {
+	case packet.PubKeyAlgoRSA, packet.PubKeyAlgoRSAEncryptOnly, packet.PubKeyAlgoRSASignOnly:
+		name = "rsa"
+	case packet.PubKeyAlgoDSA:
+		name = "dsa"
+	case packet.PubKeyAlgoElGamal:
+		name = "elg"
+	case packet.PubKeyAlgoECDSA, packet.PubKeyAlgoECDH, packet.PubKeyAlgoEdDSA,
+		packet.PubKeyAlgoEd25519, packet.PubKeyAlgoEd448,
+		packet.PubKeyAlgoX25519, packet.PubKeyAlgoX448:
+		return getCurveName(key)
+	default:
+		return fmt.Sprintf("unknown%d", key.PubKeyAlgo)
+	}
+
+	if bits, err := key.BitLength(); err == nil {
+		return fmt.Sprintf("%s%d", name, bits)
+	}
+	return name
+}
+
+// Names of elliptic curve keys follow GPG conventions (e.g. 'ed25519' for
+// signing keys & 'cv25519' for encryption keys).
+func getCurveName(key *packet.PublicKey) string {
+	curve, err := key.Curve()
+	if err != nil {
+		return fmt.Sprintf("unknown%d", key.PubKeyAlgo)
+	}
+
+	switch curve {
+	case packet.Curve25519, packet.Curve448:
+		suffix := strings.TrimPrefix(strings.ToLower(string(curve)), "curve")
+		if key.PubKeyAlgo.CanSign() {
+			return "ed" + suffix
+		}
+		return "cv" + suffix
+	case packet.CurveNistP256, packet.CurveNistP384, packet.CurveNistP521:
+		return "nist" + strings.ToLower(string(curve))
+	}
+	return strings.ToLower(string(curve))
+}
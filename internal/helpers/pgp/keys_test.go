@@ -0,0 +1,176 @@
+package pgp
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/stretchr/testify/assert"
+)
+
+func loadTestPublicKey(t *testing.T, alias string) []byte {
+	data, err := ioutil.ReadFile("../../../files/keys/" + alias + ".pub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestArmorPublicKey(t *testing.T) {
+	armored := loadTestPublicKey(t, "alice")
+
+	// Already armored keys are returned untouched.
+	if result, err := ArmorPublicKey("alice", bytes.NewReader(armored)); assert.NoError(t, err) {
+		assert.Equal(t, armored, result)
+	}
+
+	// Binary keys are armored.
+	block, err := armor.Decode(bytes.NewReader(armored))
+	if err != nil {
+		t.Fatal(err)
+	}
+	binary, err := ioutil.ReadAll(block.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result, err := ArmorPublicKey("alice", bytes.NewReader(binary)); assert.NoError(t, err) {
+		if key, err := LoadASCIIArmoredPublicKey("alice", bytes.NewReader(result)); assert.NoError(t, err) {
+			assert.Equal(t, "FA47DEB05289E92E4504962BE94F302B75D78168", key.GetFingerprint())
+			assert.Equal(t, []string{"alice <alice@example.com>"}, key.GetUserIds())
+		}
+	}
+
+	// Garbage is rejected.
+	_, err = ArmorPublicKey("alice", bytes.NewReader([]byte("foo")))
+	assert.Error(t, err)
+}
+
+func TestGetKeyInfo(t *testing.T) {
+	key, err := LoadASCIIArmoredPublicKey("alice", bytes.NewReader(loadTestPublicKey(t, "alice")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	primary := key.GetPrimaryKeyInfo()
+	assert.Equal(t, "E94F302B75D78168", primary.KeyId)
+	assert.Equal(t, "rsa1024", primary.Algorithm)
+	assert.Nil(t, primary.Expires)
+	assert.False(t, primary.Revoked)
+
+	subkeys := key.GetSubkeysInfo()
+	if assert.Len(t, subkeys, 2) {
+		for _, subkey := range subkeys {
+			assert.Equal(t, "rsa1024", subkey.Algorithm)
+			assert.True(t, subkey.CanEncrypt)
+			if assert.NotNil(t, subkey.Expires) {
+				assert.Equal(t, 2027, subkey.Expires.Year())
+			}
+		}
+	}
+}
+
+func TestGetECCKeyInfo(t *testing.T) {
+	key, err := LoadASCIIArmoredPublicKey("heidi", bytes.NewReader(loadTestPublicKey(t, "heidi")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	primary := key.GetPrimaryKeyInfo()
+	assert.Equal(t, "ed25519", primary.Algorithm)
+	assert.Equal(t, 0, primary.RSABits)
+	assert.True(t, primary.CanSign)
+	assert.False(t, primary.CanEncrypt)
+
+	subkeys := key.GetSubkeysInfo()
+	if assert.Len(t, subkeys, 1) {
+		assert.Equal(t, "cv25519", subkeys[0].Algorithm)
+		assert.True(t, subkeys[0].CanEncrypt)
+		assert.Nil(t, subkeys[0].Expires)
+	}
+
+	// RSA constraints don't apply to elliptic curve keys.
+	now := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	assert.Empty(t, key.Check(now, 4096, 30*24*time.Hour))
+}
+
+func TestCheck(t *testing.T) {
+	key, err := LoadASCIIArmoredPublicKey("alice", bytes.NewReader(loadTestPublicKey(t, "alice")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	descriptions := func(issues []KeyIssue) []string {
+		result := make([]string, 0)
+		for _, issue := range issues {
+			result = append(result, issue.Description)
+		}
+		return result
+	}
+
+	// Healthy.
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	assert.Empty(t, key.Check(now, 1024, 30*24*time.Hour))
+
+	// Weak.
+	issues := key.Check(now, 2048, 30*24*time.Hour)
+	if assert.Len(t, issues, 3) {
+		assert.True(t, issues[0].Severe)
+		assert.Equal(t, "weak primary key (rsa1024)", issues[0].Description)
+	}
+
+	// About to expire.
+	now = time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)
+	issues = key.Check(now, 1024, 365*24*time.Hour)
+	if assert.Len(t, issues, 1) {
+		assert.False(t, issues[0].Severe)
+		assert.Contains(t, issues[0].Description, "encryption keys expire on 2027-")
+	}
+
+	// Expired.
+	now = time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	assert.Equal(
+		t,
+		[]string{"no usable (i.e. not expired or revoked) encryption key"},
+		descriptions(key.Check(now, 1024, 0)))
+}
+
+func TestLoadPrivateKeyFromKeyring(t *testing.T) {
+	var keyring bytes.Buffer
+	for _, alias := range []string{"alice", "bob"} {
+		data, err := ioutil.ReadFile("../../../files/keys/" + alias + ".pri")
+		if err != nil {
+			t.Fatal(err)
+		}
+		keyring.Write(data)
+	}
+	bob := loadTestPublicKeys(t, "bob")[0]
+
+	// Keys are selected using their fingerprints.
+	if key, err := LoadPrivateKeyFromKeyring(bytes.NewReader(keyring.Bytes()), bob.GetFingerprint()); assert.NoError(t, err) {
+		assert.Equal(t, bob.Entity.PrimaryKey.Fingerprint, key.Entity.PrimaryKey.Fingerprint)
+		assert.NotNil(t, key.Entity.PrivateKey)
+	}
+	_, err := LoadPrivateKeyFromKeyring(bytes.NewReader(keyring.Bytes()), "")
+	assert.Error(t, err)
+	_, err = LoadPrivateKeyFromKeyring(bytes.NewReader(keyring.Bytes()), "FOO")
+	assert.Error(t, err)
+
+	// Binary keyrings are also supported.
+	block, err := armor.Decode(bytes.NewReader(keyring.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	binary, err := ioutil.ReadAll(block.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key, err := LoadPrivateKeyFromKeyring(bytes.NewReader(binary), ""); assert.NoError(t, err) {
+		assert.NotNil(t, key.Entity.PrivateKey)
+	}
+
+	// Public keys are ignored.
+	_, err = LoadPrivateKeyFromKeyring(bytes.NewReader(loadTestPublicKey(t, "alice")), "")
+	assert.Error(t, err)
+}
@@ -0,0 +1,191 @@
+package pgp
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os/exec"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/pkg/errors"
+
+	"github.com/carlosabalde/pgp-tomb/internal/helpers/passphrase"
+)
+
+const signatureBegin = "-----BEGIN PGP SIGNATURE-----"
+
+func Sign(prompt passphrase.Prompt, input io.Reader, output io.Writer, key *PrivateKey) error {
+	if key.Entity.PrivateKey == nil {
+		return errors.New("no private key available for signing")
+	}
+
+	if err := UnlockPrivateKey(prompt, key); err != nil {
+		return errors.Wrap(err, "failed to unlock private key")
+	}
+
+	if err := openpgp.ArmoredDetachSign(output, key.Entity, input, nil); err != nil {
+		return errors.Wrap(err, "failed to sign message")
+	}
+
+	return nil
+}
+
+func SignWithGPG(gpg string, fingerprint string, input io.Reader, output io.Writer) error {
+	args := []string{
+		"--use-agent",
+		"--armor",
+		"--detach-sign",
+		"--local-user",
+		fingerprint,
+	}
+
+	cmd := exec.Command(gpg, args...)
+
+	cmd.Stdin = input
+
+	cmd.Stdout = output
+
+	cmd.Stderr = nil
+
+	if err := cmd.Run(); err != nil {
+		return errors.Wrap(err, "failed to complete GPG command execution")
+	}
+
+	return nil
+}
+
+const (
+	SignatureNone    = "unsigned"
+	SignatureValid   = "valid"
+	SignatureInvalid = "invalid"
+	SignatureUnknown = "unknown-signer"
+)
+
+// Result of checking the signature of a decrypted message. Also includes the
+// file name stored in the literal data packet, and the algorithms used to
+// encrypt, compress & sign the message (if known). 'AEAD' is empty if the
+// message was not encrypted using AEAD.
+type Verification struct {
+	State       string
+	KeyId       string
+	Signer      *PublicKey
+	FileName    string
+	Cipher      string
+	AEAD        string
+	Compression string
+	Hash        string
+}
+
+// Keyring used when reading messages: private keys are used for decryption,
+// while public keys are used to check signatures.
+type keyring struct {
+	private openpgp.EntityList
+	public  openpgp.EntityList
+}
+
+func newKeyring(private openpgp.EntityList, keys []*PublicKey) keyring {
+	result := keyring{
+		private: private,
+		public:  make(openpgp.EntityList, 0, len(keys)),
+	}
+	for _, key := range keys {
+		result.public = append(result.public, key.Entity)
+	}
+	return result
+}
+
+func (self keyring) KeysById(id uint64) []openpgp.Key {
+	return self.private.KeysById(id)
+}
+
+func (self keyring) KeysByIdUsage(id uint64, requiredUsage byte) []openpgp.Key {
+	return self.public.KeysByIdUsage(id, requiredUsage)
+}
+
+func (self keyring) DecryptionKeys() []openpgp.Key {
+	return self.private.DecryptionKeys()
+}
+
+// Must be called once the body of the message has been completely read.
+func verify(message *openpgp.MessageDetails, keys []*PublicKey) Verification {
+	result := Verification{}
+	if message.LiteralData != nil {
+		result.FileName = message.LiteralData.FileName
+	}
+
+	if !message.IsSigned {
+		result.State = SignatureNone
+		return result
+	}
+
+	result.KeyId = fmt.Sprintf("%016X", message.SignedByKeyId)
+	if message.Signature != nil {
+		result.Hash = getHashName(message.Signature.Hash)
+	}
+	if message.SignedBy == nil {
+		result.State = SignatureUnknown
+	} else if message.SignatureError != nil || message.Signature == nil {
+		result.State = SignatureInvalid
+	} else {
+		result.State = SignatureValid
+		for _, key := range keys {
+			if key.Entity == message.SignedBy.Entity {
+				result.Signer = key
+				break
+			}
+		}
+	}
+
+	return result
+}
+
+// Checks a sequence of ASCII armored detached signatures over 'message' and
+// returns the keys that produced valid signatures. Invalid signatures or
+// signatures made by unknown keys are ignored.
+func VerifySignatures(signatures []byte, message []byte, keys []*PublicKey) ([]*PublicKey, error) {
+	keyring := make(openpgp.EntityList, 0, len(keys))
+	for _, key := range keys {
+		keyring = append(keyring, key.Entity)
+	}
+
+	result := make([]*PublicKey, 0)
+	for _, signature := range SplitSignatures(signatures) {
+		decoded, err := armor.Decode(bytes.NewReader(signature))
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to decode ASCII armor of signature")
+		}
+
+		signer, err := openpgp.CheckDetachedSignature(keyring, bytes.NewReader(message), decoded.Body, nil)
+		if err != nil {
+			continue
+		}
+
+		for _, key := range keys {
+			if key.Entity == signer && !containsKey(result, key) {
+				result = append(result, key)
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// Splits a sequence of ASCII armored signatures in individual signatures.
+func SplitSignatures(signatures []byte) [][]byte {
+	result := make([][]byte, 0)
+	blocks := bytes.Split(signatures, []byte(signatureBegin))
+	for _, block := range blocks[1:] {
+		result = append(result, append([]byte(signatureBegin), block...))
+	}
+	return result
+}
+
+func containsKey(keys []*PublicKey, key *PublicKey) bool {
+	for _, aKey := range keys {
+		if aKey == key {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,107 @@
+package pgp
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/stretchr/testify/assert"
+)
+
+func loadTestPrivateKey(t *testing.T, alias string) *PrivateKey {
+	input, err := os.Open("../../../files/keys/" + alias + ".pri")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer input.Close()
+	key, err := LoadASCIIArmoredPrivateKey(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := key.Entity.PrivateKey.Decrypt([]byte("s3cr3t")); err != nil {
+		t.Fatal(err)
+	}
+	for _, subkey := range key.Entity.Subkeys {
+		if err := subkey.PrivateKey.Decrypt([]byte("s3cr3t")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return &key
+}
+
+func loadTestPublicKeys(t *testing.T, aliases ...string) []*PublicKey {
+	result := make([]*PublicKey, 0)
+	for _, alias := range aliases {
+		key, err := LoadASCIIArmoredPublicKey(alias, bytes.NewReader(loadTestPublicKey(t, alias)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		result = append(result, &key)
+	}
+	return result
+}
+
+func TestVerifySignatures(t *testing.T) {
+	privateKey := loadTestPrivateKey(t, "alice")
+	keys := loadTestPublicKeys(t, "alice", "bob")
+
+	message := []byte("foo")
+	var signature bytes.Buffer
+	if err := openpgp.ArmoredDetachSign(&signature, privateKey.Entity, bytes.NewReader(message), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Duplicated signatures count once.
+	signatures := append(append([]byte{}, signature.Bytes()...), signature.Bytes()...)
+	assert.Len(t, SplitSignatures(signatures), 2)
+	if signers, err := VerifySignatures(signatures, message, keys); assert.NoError(t, err) {
+		if assert.Len(t, signers, 1) {
+			assert.Equal(t, "alice", signers[0].Alias)
+		}
+	}
+
+	// Signatures over a different message are ignored.
+	if signers, err := VerifySignatures(signatures, []byte("bar"), keys); assert.NoError(t, err) {
+		assert.Empty(t, signers)
+	}
+
+	// Signatures made by unknown keys are ignored.
+	if signers, err := VerifySignatures(signatures, message, keys[1:]); assert.NoError(t, err) {
+		assert.Empty(t, signers)
+	}
+}
+
+func TestEncryptAndVerify(t *testing.T) {
+	privateKey := loadTestPrivateKey(t, "alice")
+	keys := loadTestPublicKeys(t, "alice", "bob")
+
+	for _, signer := range []*PrivateKey{nil, privateKey} {
+		var encrypted, decrypted bytes.Buffer
+		if err := Encrypt(bytes.NewReader([]byte("foo")), &encrypted, keys[:1], signer, "bar", Settings{}); err != nil {
+			t.Fatal(err)
+		}
+
+		// Signer is unknown if not included in the list of keys.
+		verification, err := Decrypt(nil, bytes.NewReader(encrypted.Bytes()), &decrypted, privateKey, keys[1:])
+		if assert.NoError(t, err) {
+			assert.Equal(t, "foo", decrypted.String())
+			assert.Equal(t, "bar", verification.FileName)
+			if signer == nil {
+				assert.Equal(t, SignatureNone, verification.State)
+			} else {
+				assert.Equal(t, SignatureUnknown, verification.State)
+				assert.Nil(t, verification.Signer)
+			}
+		}
+
+		decrypted.Reset()
+		verification, err = Decrypt(nil, bytes.NewReader(encrypted.Bytes()), &decrypted, privateKey, keys)
+		if assert.NoError(t, err) && signer != nil {
+			assert.Equal(t, SignatureValid, verification.State)
+			if assert.NotNil(t, verification.Signer) {
+				assert.Equal(t, "alice", verification.Signer.Alias)
+			}
+		}
+	}
+}
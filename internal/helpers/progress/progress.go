@@ -0,0 +1,77 @@
+package progress
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+const barWidth = 30
+
+// Single line progress bar overwriting itself. Rendering is disabled when the
+// output is not a terminal, so redirected output is never polluted. Not safe
+// for concurrent use.
+type Bar struct {
+	output  io.Writer
+	total   int
+	start   time.Time
+	enabled bool
+}
+
+func New(output *os.File, total int) *Bar {
+	enabled := false
+	if info, err := output.Stat(); err == nil {
+		enabled = info.Mode()&os.ModeCharDevice != 0
+	}
+	return &Bar{
+		output:  output,
+		total:   total,
+		start:   time.Now(),
+		enabled: enabled,
+	}
+}
+
+func (self *Bar) Update(done int, details string) {
+	if self.enabled {
+		fmt.Fprintf(
+			self.output, "\r%s\033[K",
+			Render(done, self.total, time.Since(self.start), details))
+	}
+}
+
+// Removes the bar, e.g. before writing other lines to the same terminal.
+func (self *Bar) Clear() {
+	if self.enabled {
+		fmt.Fprint(self.output, "\r\033[K")
+	}
+}
+
+// Renders something like '[=====>    ] 12/50 (24%) details, ETA 1m20s'. The
+// ETA assumes a constant rate since the beginning.
+func Render(done, total int, elapsed time.Duration, details string) string {
+	if total < 1 {
+		total = 1
+	}
+	if done > total {
+		done = total
+	}
+
+	filled := barWidth * done / total
+	bar := strings.Repeat("=", filled)
+	if filled < barWidth {
+		bar += ">" + strings.Repeat(" ", barWidth-filled-1)
+	}
+
+	eta := "-"
+	if done > 0 {
+		eta = (elapsed * time.Duration(total-done) / time.Duration(done)).Round(time.Second).String()
+	}
+
+	result := fmt.Sprintf("[%s] %d/%d (%d%%)", bar, done, total, 100*done/total)
+	if details != "" {
+		result += " " + details
+	}
+	return result + ", ETA " + eta
+}
@@ -0,0 +1,37 @@
+package progress
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRender(t *testing.T) {
+	tests := []struct {
+		done     int
+		total    int
+		elapsed  time.Duration
+		details  string
+		expected string
+	}{
+		{0, 10, 0, "", "[>                             ] 0/10 (0%), ETA -"},
+		{5, 10, 10 * time.Second, "2 failed", "[===============>              ] 5/10 (50%) 2 failed, ETA 10s"},
+		{10, 10, time.Minute, "", "[==============================] 10/10 (100%), ETA 0s"},
+		{1, 3, 1500 * time.Millisecond, "", "[==========>                   ] 1/3 (33%), ETA 3s"},
+		{0, 0, 0, "", "[>                             ] 0/1 (0%), ETA -"},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, Render(test.done, test.total, test.elapsed, test.details))
+	}
+}
+
+func TestDisabledBar(t *testing.T) {
+	var output bytes.Buffer
+	bar := &Bar{output: &output, total: 10, start: time.Now()}
+	bar.Update(5, "")
+	bar.Clear()
+	assert.Empty(t, output.String())
+}
@@ -0,0 +1,140 @@
+package shamir
+
+import (
+	"crypto/rand"
+
+	"github.com/pkg/errors"
+)
+
+// Shamir's secret sharing over GF(2^8). Each byte of the secret is the
+// intercept of a random polynomial of degree 'threshold - 1', and each share
+// holds the evaluations of all those polynomials at a distinct non-zero point,
+// stored in its last byte.
+
+var (
+	expTable [255]byte
+	logTable [256]byte
+)
+
+// Tables are generated using 0x03 as generator & the AES reducing polynomial.
+func init() {
+	value := byte(1)
+	for i := 0; i < 255; i++ {
+		expTable[i] = value
+		logTable[value] = byte(i)
+		value = add(value, xtime(value))
+	}
+}
+
+func xtime(a byte) byte {
+	if a&0x80 != 0 {
+		return (a << 1) ^ 0x1b
+	}
+	return a << 1
+}
+
+func add(a, b byte) byte {
+	return a ^ b
+}
+
+func mult(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return expTable[(int(logTable[a])+int(logTable[b]))%255]
+}
+
+func div(a, b byte) byte {
+	if a == 0 {
+		return 0
+	}
+	return expTable[(int(logTable[a])-int(logTable[b])+255)%255]
+}
+
+// Evaluates the polynomial with 'coefficients' (lowest degree first) at 'x'.
+func evaluate(coefficients []byte, x byte) byte {
+	result := byte(0)
+	for i := len(coefficients) - 1; i >= 0; i-- {
+		result = add(mult(result, x), coefficients[i])
+	}
+	return result
+}
+
+// Splits 'secret' into 'parts' shares, any 'threshold' of them being enough
+// to rebuild it.
+func Split(secret []byte, parts, threshold int) ([][]byte, error) {
+	if len(secret) == 0 {
+		return nil, errors.New("cannot split an empty secret")
+	}
+	if threshold < 2 {
+		return nil, errors.New("threshold must be at least 2")
+	}
+	if parts < threshold {
+		return nil, errors.New("parts cannot be less than threshold")
+	}
+	if parts > 255 {
+		return nil, errors.New("parts cannot exceed 255")
+	}
+
+	result := make([][]byte, parts)
+	for i := range result {
+		result[i] = make([]byte, len(secret)+1)
+		result[i][len(secret)] = byte(i + 1)
+	}
+
+	coefficients := make([]byte, threshold)
+	for i, value := range secret {
+		coefficients[0] = value
+		if _, err := rand.Read(coefficients[1:]); err != nil {
+			return nil, errors.Wrap(err, "failed to generate random coefficients")
+		}
+		for _, share := range result {
+			share[i] = evaluate(coefficients, share[len(secret)])
+		}
+	}
+
+	return result, nil
+}
+
+// Rebuilds a secret using Lagrange interpolation at zero. Beware using less
+// shares than the threshold silently returns garbage.
+func Combine(shares [][]byte) ([]byte, error) {
+	if len(shares) < 2 {
+		return nil, errors.New("at least two shares are required")
+	}
+
+	length := len(shares[0])
+	if length < 2 {
+		return nil, errors.New("shares are too short")
+	}
+
+	xs := make([]byte, len(shares))
+	seen := make(map[byte]bool)
+	for i, share := range shares {
+		if len(share) != length {
+			return nil, errors.New("all shares must have the same length")
+		}
+		xs[i] = share[length-1]
+		if xs[i] == 0 || seen[xs[i]] {
+			return nil, errors.New("invalid or duplicated share")
+		}
+		seen[xs[i]] = true
+	}
+
+	result := make([]byte, length-1)
+	for i := range result {
+		value := byte(0)
+		for j, share := range shares {
+			basis := byte(1)
+			for k := range shares {
+				if k != j {
+					basis = mult(basis, div(xs[k], add(xs[k], xs[j])))
+				}
+			}
+			value = add(value, mult(share[i], basis))
+		}
+		result[i] = value
+	}
+
+	return result, nil
+}